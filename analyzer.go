@@ -1,10 +1,13 @@
 package evmopt
 
 import (
+    "context"
     "fmt"
     "log"
     "math/big"
+    "sort"
     "strings"
+    "time"
 )
 
 type ReachingPool []map[int]bool
@@ -82,7 +85,13 @@ type Operation struct {
 }
 
 func (self *Operation) Source() int { return self.source }
-func (self *Operation) Value() *big.Int { return self.instruction.Arg }
+// Value returns the constant pushed by the operation, or nil if it is not a known constant.
+func (self *Operation) Value() *big.Int {
+    if self.instruction.Symbol != "" {
+        return nil
+    }
+    return self.instruction.Arg
+}
 func (self *Operation) String() string { return self.instruction.String() }
 
 type StackFrame struct {
@@ -99,6 +108,14 @@ func NewFrame(up *StackFrame, value *Operation) *StackFrame {
     }
 }
 
+// Depth returns the number of values on the stack; the nil frame is the empty stack.
+func (self *StackFrame) Depth() int {
+    if self == nil {
+        return 0
+    }
+    return self.Height + 1
+}
+
 func (self *StackFrame) UpBy(num int) *StackFrame {
     ret := self
     for i := 0; i < num; i++ {
@@ -141,52 +158,202 @@ func (self *StackFrame) Popn(n int) (values []*StackFrame, stack *StackFrame) {
 type programState struct {
     pc int
     stack *StackFrame
+    context []int           // PCs of the pushes of the return addresses of the innermost calls, outermost first
+}
+
+// reachingKey identifies the pool a state is merged into: its PC, and its calling context
+// when Options.ContextDepth is set.
+type reachingKey struct {
+    pc int
+    context string
+}
+
+func contextKey(calls []int) string {
+    var b strings.Builder
+    for _, pc := range calls {
+        fmt.Fprintf(&b, "%x,", pc)
+    }
+    return b.String()
+}
+
+// ContextReaching holds the sources of an instruction's operands in a single calling context.
+type ContextReaching struct {
+    Context []int                   // PCs of the pushes of the return addresses of the innermost calls, outermost first
+    ReachedBy []map[int]bool
 }
 
-func (self *Program) buildReachings() {
-    pools := make(map[int]ReachingPool)
-    states := []*programState{
-        &programState{0, nil},
+// How many states to process between checks for cancellation
+const contextCheckInterval = 256
+
+// expired returns true if ctx's deadline has passed. The context's timer may fire well
+// after its deadline on a loaded machine, leaving ctx.Err() nil in the meantime.
+func expired(ctx context.Context) bool {
+    deadline, ok := ctx.Deadline()
+    return ok && !time.Now().Before(deadline)
+}
+
+func (self *Program) buildReachings(ctx context.Context, opts *Options) (err error) {
+    pools := make(map[reachingKey]ReachingPool)
+    contexts := make(map[reachingKey][]int)
+    edges := make(map[int]map[int]bool)
+    var states []*programState
+    if _, ok := self.Instructions[0]; ok {
+        states = append(states, &programState{0, nil, nil})
     }
 
     i := 0
     for len(states) > 0 {
+        if opts.MaxStates > 0 && i >= opts.MaxStates {
+            err = ErrBudgetExceeded
+            break
+        }
+        if i % contextCheckInterval == 0 {
+            if err = ctx.Err(); err != nil {
+                break
+            }
+            if expired(ctx) {
+                err = context.DeadlineExceeded
+                break
+            }
+        }
+
         var state *programState
         state, states = states[len(states) - 1], states[:len(states) - 1]
-        log.Printf("%v PC: 0x%X, op: %v, stack: %v", i, state.pc, self.Instructions[state.pc], state.stack)
+        if opts.Tracer != nil {
+            opts.Tracer.CaptureState(state.pc, self.Instructions[state.pc], state.stack)
+        }
         i += 1
-        //log.Printf("PC: 0x%X, op: %v, pool: %v", state.pc, self.Instructions[state.pc], pools[state.pc])
         successors := processInstruction(self, state)
+        key := reachingKey{state.pc, contextKey(state.context)}
+        calls := self.callContext(state, opts.ContextDepth)
 
         for _, successor := range successors {
-            result := getValue(self, successor, pools[state.pc])
-            if pools[successor.pc] == nil {
-                pools[successor.pc] = result
+            if edges[state.pc] == nil {
+                edges[state.pc] = make(map[int]bool)
+            }
+            edges[state.pc][successor.pc] = true
+
+            successor.context = calls
+            next := reachingKey{successor.pc, contextKey(calls)}
+            result := getValue(self, successor, pools[key])
+            if pools[next] == nil {
+                pools[next] = result
+                contexts[next] = calls
                 states = append(states, successor)
             } else {
-                newPool := result.Combine(pools[successor.pc])
-                if !newPool.Equal(pools[successor.pc]) {
-                    pools[successor.pc] = newPool
+                newPool := result.Combine(pools[next])
+                if !newPool.Equal(pools[next]) {
+                    pools[next] = newPool
                     states = append(states, successor)
                 }
             }
         }
     }
 
+    // Collapse the pools for each context into one per instruction
+    reachings := make(map[int]ReachingPool)
+    if opts.ContextDepth > 0 {
+        self.contexts = make(map[int][]*ContextReaching)
+    }
+    for key, pool := range pools {
+        if reachings[key.pc] == nil {
+            reachings[key.pc] = pool
+        } else {
+            reachings[key.pc] = reachings[key.pc].Combine(pool)
+        }
+        if self.contexts != nil {
+            self.contexts[key.pc] = append(self.contexts[key.pc], &ContextReaching{contexts[key], operandSources(self.Instructions[key.pc], pool)})
+        }
+    }
+    for _, reachings := range self.contexts {
+        sort.Slice(reachings, func(i, j int) bool { return lessContext(reachings[i].Context, reachings[j].Context) })
+    }
+
     for pc, instruction := range self.Instructions {
-        reachedBy := pools[pc]
-
-        // Build the list of instructions that can be the input for each arg, and vice-versa
-        for i := 0; i < instruction.Op.StackReads(); i++ {
-            instruction.ReachedBy[i] = make(map[int]bool, len(reachedBy[i]))
-            for j := range reachedBy[i] {
-                instruction.ReachedBy[i][j] = true
-                if !instruction.Op.IsDup() && !instruction.Op.IsSwap() {
-                    self.Instructions[j].Reaches[pc] = true
-                }
+        instruction.ReachedBy = operandSources(instruction, reachings[pc])
+        if instruction.Op.IsDup() || instruction.Op.IsSwap() {
+            continue
+        }
+        for _, sources := range instruction.ReachedBy {
+            for j := range sources {
+                self.Instructions[j].Reaches[pc] = true
             }
         }
     }
+
+    self.buildBlocks(edges)
+
+    return err
+}
+
+// operandSources returns the sources of each operand of inst in pool.
+func operandSources(inst *Instruction, pool ReachingPool) []map[int]bool {
+    ret := make([]map[int]bool, inst.Op.StackReads())
+    for i := range ret {
+        ret[i] = make(map[int]bool)
+        if i >= len(pool) {
+            // Unreached, or reached only by states that were cut short
+            continue
+        }
+        for j := range pool[i] {
+            ret[i][j] = true
+        }
+    }
+    return ret
+}
+
+func lessContext(a, b []int) bool {
+    for i := 0; i < len(a) && i < len(b); i++ {
+        if a[i] != b[i] {
+            return a[i] < b[i]
+        }
+    }
+    return len(a) < len(b)
+}
+
+// callContext returns the calling context of the successors of state, as the PCs of at
+// most depth pushes of return addresses. A JUMP to the address pushed innermost returns
+// from the call; any other JUMP made with a code address on the stack other than that one
+// is a call returning to the topmost such address, recorded by the PC that pushed it.
+func (self *Program) callContext(state *programState, depth int) []int {
+    calls := state.context
+    if depth == 0 || self.Instructions[state.pc].Op != JUMP || state.stack == nil {
+        return calls
+    }
+    if n := len(calls); n > 0 && state.stack.Value.Source() == calls[n - 1] {
+        return calls[:n - 1]
+    }
+
+    ret := -1
+    for s := state.stack.Up; s != nil; s = s.Up {
+        if value := s.Value.Value(); s.Value.instruction.Op.IsPush() && value != nil {
+            if _, ok := self.jumpDest(value); ok {
+                ret = s.Value.Source()
+                break
+            }
+        }
+    }
+    if ret == -1 {
+        return calls
+    }
+    for i := len(calls) - 1; i >= 0; i-- {
+        if calls[i] == ret {
+            // Already returned from the calls inside this one
+            return calls[:i + 1]
+        }
+    }
+    if len(calls) == depth {
+        calls = calls[1:]
+    }
+    return append(append([]int{}, calls...), ret)
+}
+
+// ReachingContexts returns the sources of the operands of the instruction at pc separately
+// for each calling context it was reached in, ordered by context. Instruction.ReachedBy
+// holds their union. It returns nil unless the program was analysed with
+// Options.ContextDepth set.
+func (self *Program) ReachingContexts(pc int) []*ContextReaching {
+    return self.contexts[pc]
 }
 
 func getValue(prog *Program, state *programState, inpool ReachingPool) (pool ReachingPool) {
@@ -196,132 +363,110 @@ func getValue(prog *Program, state *programState, inpool ReachingPool) (pool Rea
     return pool
 }
 
-func processInstruction(prog *Program, state *programState) (nextstates []*programState) {
-    inst := prog.Instructions[state.pc]
+// stepStack applies the stack effect of the instruction at pc to stack, returning the
+// operations it consumes and the resulting stack.
+func stepStack(prog *Program, pc int, stack *StackFrame) (operands []*Operation, result *StackFrame) {
+    inst := prog.Instructions[pc]
     op := inst.Op
-    stack := state.stack
 
-    operandFrames, stack := stack.Popn(op.StackReads())
-    operands := make([]*Operation, len(operandFrames))
+    operandFrames, rest := stack.Popn(op.StackReads())
+    operands = make([]*Operation, len(operandFrames))
     for i, frame := range operandFrames {
         operands[i] = frame.Value
     }
 
+    switch {
+    case op.IsDup():
+        // Uses stack instead of rest, because we don't actually want to pop all those elements
+        result = NewFrame(stack, stack.UpBy(op.StackReads() - 1).Value)
+    case op.IsSwap():
+        result = stack.Swap(op.StackReads() - 1)
+    default:
+        switch op.StackWrites() {
+        case 0:
+            result = rest
+        case 1:
+            result = NewFrame(rest, &Operation{inst, pc})
+        default:
+            log.Fatalf("Unexpected op %v makes %v writes to the stack", op, op.StackWrites())
+        }
+    }
+    return operands, result
+}
+
+func processInstruction(prog *Program, state *programState) (nextstates []*programState) {
+    inst := prog.Instructions[state.pc]
+    op := inst.Op
+
+    if !op.ValidIn(prog.Fork) {
+        // Undefined opcodes halt execution
+        return nil
+    }
+    if state.stack.Depth() < op.StackReads() {
+        prog.diagnose(state.pc, "stack underflow")
+        return nil
+    }
+
+    operands, stack := stepStack(prog, state.pc, state.stack)
+
     switch op {
     // Ops that terminate execution
     case STOP: break
     case RETURN: break
+    case REVERT: break
+    case INVALID: break
     case SELFDESTRUCT: break
 
-    case PUSH1: fallthrough
-    case PUSH2: fallthrough
-    case PUSH3: fallthrough
-    case PUSH4: fallthrough
-    case PUSH5: fallthrough
-    case PUSH6: fallthrough
-    case PUSH7: fallthrough
-    case PUSH8: fallthrough
-    case PUSH9: fallthrough
-    case PUSH10: fallthrough
-    case PUSH11: fallthrough
-    case PUSH12: fallthrough
-    case PUSH13: fallthrough
-    case PUSH14: fallthrough
-    case PUSH15: fallthrough
-    case PUSH16: fallthrough
-    case PUSH17: fallthrough
-    case PUSH18: fallthrough
-    case PUSH19: fallthrough
-    case PUSH20: fallthrough
-    case PUSH21: fallthrough
-    case PUSH22: fallthrough
-    case PUSH23: fallthrough
-    case PUSH24: fallthrough
-    case PUSH25: fallthrough
-    case PUSH26: fallthrough
-    case PUSH27: fallthrough
-    case PUSH28: fallthrough
-    case PUSH29: fallthrough
-    case PUSH30: fallthrough
-    case PUSH31: fallthrough
-    case PUSH32:
-        nextstates = []*programState{
-            &programState{state.pc + op.OperandSize() + 1, NewFrame(stack, &Operation{inst, state.pc})},
-        }
-    case JUMP:
+    case JUMP, JUMPI:
         if operands[0].Value() == nil {
-            log.Fatalf("%v: Could not determine jump location statically; source is %v", state.pc, operands[0].Source())
+            prog.diagnose(state.pc, "could not determine jump location statically; source is %v", operands[0].Source())
+        } else if dest, ok := prog.jumpDest(operands[0].Value()); !ok {
+            prog.diagnose(state.pc, "invalid jump destination 0x%x", operands[0].Value())
+        } else {
+            nextstates = append(nextstates, &programState{pc: dest, stack: stack})
         }
-        nextstates = []*programState{
-            &programState{int(operands[0].Value().Int64()), stack},
-        }
-    case JUMPI:
-        if operands[0].Value() == nil {
-            log.Fatalf("%v: Could not determine jump location statically; source is %v", state.pc, operands[0].Source())
-        }
-        nextstates = []*programState{
-            &programState{int(operands[0].Value().Int64()), stack},
-            &programState{state.pc + 1, stack},
-        }
-    case DUP1: fallthrough
-    case DUP2: fallthrough
-    case DUP3: fallthrough
-    case DUP4: fallthrough
-    case DUP5: fallthrough
-    case DUP6: fallthrough
-    case DUP7: fallthrough
-    case DUP8: fallthrough
-    case DUP9: fallthrough
-    case DUP10: fallthrough
-    case DUP11: fallthrough
-    case DUP12: fallthrough
-    case DUP13: fallthrough
-    case DUP14: fallthrough
-    case DUP15: fallthrough
-    case DUP16:
-        // Uses state.stack instead of stack, because we don't actually want to pop all those elements
-        nextstates = []*programState{
-            &programState{state.pc + 1, NewFrame(state.stack, state.stack.UpBy(op.StackReads() - 1).Value)},
-        }
-    case SWAP1: fallthrough
-    case SWAP2: fallthrough
-    case SWAP3: fallthrough
-    case SWAP4: fallthrough
-    case SWAP5: fallthrough
-    case SWAP6: fallthrough
-    case SWAP7: fallthrough
-    case SWAP8: fallthrough
-    case SWAP9: fallthrough
-    case SWAP10: fallthrough
-    case SWAP11: fallthrough
-    case SWAP12: fallthrough
-    case SWAP13: fallthrough
-    case SWAP14: fallthrough
-    case SWAP15: fallthrough
-    case SWAP16:
-        // Uses state.stack instead of stack, because we don't actually want to pop all those elements
-        nextstates = []*programState{
-            &programState{state.pc + 1, state.stack.Swap(op.StackReads() - 1)},
+        if op == JUMPI {
+            nextstates = append(nextstates, &programState{pc: state.pc + 1, stack: stack})
         }
     default:
-        switch op.StackWrites() {
-        case 0:
-            nextstates = []*programState{
-                &programState{state.pc + 1, stack},
-            }
-        case 1:
-            nextstates = []*programState{
-                &programState{state.pc + 1, NewFrame(stack, &Operation{inst, state.pc})},
-            }
-        default:
-            log.Fatalf("Unexpected op %v makes %v writes to the stack", op, op.StackWrites())
+        nextstates = []*programState{
+            &programState{pc: state.pc + op.OperandSize() + 1, stack: stack},
         }
     }
 
-    if stack != nil && stack.Height > 1024 {
+    if stack.Depth() > 1024 {
         // Stack too tall; no future states.
         return nil
     }
 
-    return nextstates
+    // Running off the end of the code stops execution
+    valid := nextstates[:0]
+    for _, next := range nextstates {
+        if _, ok := prog.Instructions[next.pc]; ok {
+            valid = append(valid, next)
+        }
+    }
+    return valid
+}
+
+// jumpDest returns dest as a PC if it is the location of a JUMPDEST instruction.
+func (self *Program) jumpDest(dest *big.Int) (int, bool) {
+    if !dest.IsInt64() {
+        return 0, false
+    }
+    inst, ok := self.Instructions[int(dest.Int64())]
+    if !ok || inst.Op != JUMPDEST {
+        return 0, false
+    }
+    return int(dest.Int64()), true
+}
+
+func (self *Program) diagnose(pc int, format string, args ...interface{}) {
+    message := fmt.Sprintf(format, args...)
+    for _, d := range self.Diagnostics {
+        if d.PC == pc && d.Message == message {
+            return
+        }
+    }
+    self.Diagnostics = append(self.Diagnostics, Diagnostic{pc, message})
 }
@@ -0,0 +1,305 @@
+package evmopt
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Contract is a compiled contract loaded from a build artifact.
+type Contract struct {
+    Name string
+    SourceName string                   // File the contract is defined in, if known
+    ABI json.RawMessage
+    Creation *Bytecode
+    Runtime *Bytecode
+    SourceFiles []string                // Source file names by the index used in the source maps, if known
+}
+
+// Bytecode is the creation or runtime code of a compiled contract. Library addresses
+// that have not been linked read as zero in Code.
+type Bytecode struct {
+    Code []byte
+    SourceMap string
+    LinkReferences []LinkReference
+    ImmutableReferences []ImmutableReference
+}
+
+// LinkReference is a placeholder in the code for the address of a library.
+type LinkReference struct {
+    Source string                       // File the library is defined in, if known
+    Library string                      // Name of the library, if known
+    Placeholder string                  // Hash in a __$hash$__ placeholder, if the code had one
+    Start int                           // Offset of the placeholder in the code
+    Length int
+}
+
+// ImmutableReference is a slot in runtime code that the constructor fills in with the
+// value of an immutable variable.
+type ImmutableReference struct {
+    ID int                              // AST ID of the variable
+    Start int                           // Offset of the value in the code
+    Length int
+}
+
+var ErrUnknownArtifact = errors.New("unrecognised artifact format")
+
+// program analyses code, attaching its source map if it has one.
+func (self *Contract) program(ctx context.Context, code *Bytecode, opts *Options) (*Program, error) {
+    if code == nil || len(code.Code) == 0 {
+        return nil, fmt.Errorf("contract %v has no code", self.Name)
+    }
+    prog, err := NewProgramFromBytecode(ctx, code, opts)
+    if code.SourceMap != "" {
+        if serr := prog.SetSourceMap(code.SourceMap, self.SourceFiles); serr != nil {
+            return prog, fmt.Errorf("source map: %v", serr)
+        }
+    }
+    return prog, err
+}
+
+// RuntimeProgram analyses the contract's runtime code, as NewProgramFromBytecode does.
+func (self *Contract) RuntimeProgram(ctx context.Context, opts *Options) (*Program, error) {
+    return self.program(ctx, self.Runtime, opts)
+}
+
+// CreationProgram analyses the contract's creation code, as NewProgramFromBytecode does.
+func (self *Contract) CreationProgram(ctx context.Context, opts *Options) (*Program, error) {
+    return self.program(ctx, self.Creation, opts)
+}
+
+// LoadArtifact reads the contracts in a solc standard JSON output, or a Foundry or
+// Hardhat artifact. Foundry artifacts that do not record their contract's name are named
+// after the file.
+func LoadArtifact(path string) ([]*Contract, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+    contracts, err := ParseArtifact(data, name)
+    if err != nil {
+        return nil, fmt.Errorf("%v: %v", path, err)
+    }
+    return contracts, nil
+}
+
+// FindContract returns the contract called name, which may be qualified by its source
+// file as "file.sol:Name", or the only contract if name is empty.
+func FindContract(contracts []*Contract, name string) (*Contract, error) {
+    var found []*Contract
+    for _, contract := range contracts {
+        if name == "" || contract.Name == name || contract.SourceName + ":" + contract.Name == name {
+            found = append(found, contract)
+        }
+    }
+    switch {
+    case len(found) == 1:
+        return found[0], nil
+    case len(found) == 0:
+        return nil, fmt.Errorf("no contract %q", name)
+    case name == "":
+        return nil, fmt.Errorf("%d contracts; a name is required", len(found))
+    }
+    return nil, fmt.Errorf("contract name %q is ambiguous; qualify it with its source file", name)
+}
+
+type artifactCode struct {
+    Object string `json:"object"`
+    SourceMap string `json:"sourceMap"`
+    LinkReferences linkReferences `json:"linkReferences"`
+    ImmutableReferences map[string][]artifactRange `json:"immutableReferences"`
+}
+
+type linkReferences map[string]map[string][]artifactRange
+
+type artifactRange struct {
+    Start int `json:"start"`
+    Length int `json:"length"`
+}
+
+// ParseArtifact decodes the contracts in a build artifact, detecting its format. name is
+// used for a Foundry artifact that does not record its contract's name.
+func ParseArtifact(data []byte, name string) ([]*Contract, error) {
+    var probe struct {
+        Format string `json:"_format"`
+        Contracts json.RawMessage `json:"contracts"`
+        Bytecode json.RawMessage `json:"bytecode"`
+    }
+    if err := json.Unmarshal(data, &probe); err != nil {
+        return nil, err
+    }
+    switch {
+    case strings.HasPrefix(probe.Format, "hh-sol-artifact"):
+        return parseHardhat(data)
+    case probe.Contracts != nil:
+        return parseStandardJSON(data)
+    case len(probe.Bytecode) > 0 && probe.Bytecode[0] == '{':
+        return parseFoundry(data, name)
+    }
+    return nil, ErrUnknownArtifact
+}
+
+// parseStandardJSON decodes the output of solc --standard-json.
+func parseStandardJSON(data []byte) ([]*Contract, error) {
+    var output struct {
+        Contracts map[string]map[string]struct {
+            ABI json.RawMessage `json:"abi"`
+            EVM struct {
+                Bytecode *artifactCode `json:"bytecode"`
+                DeployedBytecode *artifactCode `json:"deployedBytecode"`
+            } `json:"evm"`
+        } `json:"contracts"`
+        Sources map[string]struct {
+            ID int `json:"id"`
+        } `json:"sources"`
+    }
+    if err := json.Unmarshal(data, &output); err != nil {
+        return nil, err
+    }
+
+    var files []string
+    for name, source := range output.Sources {
+        if source.ID < 0 || source.ID >= len(output.Sources) {
+            return nil, fmt.Errorf("source %v has invalid ID %d", name, source.ID)
+        }
+        for len(files) <= source.ID {
+            files = append(files, "")
+        }
+        files[source.ID] = name
+    }
+
+    var contracts []*Contract
+    for file, compiledFile := range output.Contracts {
+        for name, compiled := range compiledFile {
+            contract := &Contract{Name: name, SourceName: file, ABI: compiled.ABI, SourceFiles: files}
+            var err error
+            if contract.Creation, err = compiled.EVM.Bytecode.decode(); err != nil {
+                return nil, fmt.Errorf("%v:%v: %v", file, name, err)
+            }
+            if contract.Runtime, err = compiled.EVM.DeployedBytecode.decode(); err != nil {
+                return nil, fmt.Errorf("%v:%v: %v", file, name, err)
+            }
+            contracts = append(contracts, contract)
+        }
+    }
+    sort.Slice(contracts, func(i, j int) bool {
+        if contracts[i].SourceName != contracts[j].SourceName {
+            return contracts[i].SourceName < contracts[j].SourceName
+        }
+        return contracts[i].Name < contracts[j].Name
+    })
+    return contracts, nil
+}
+
+// parseFoundry decodes an artifact from a Foundry out directory.
+func parseFoundry(data []byte, name string) ([]*Contract, error) {
+    var artifact struct {
+        ABI json.RawMessage `json:"abi"`
+        Bytecode *artifactCode `json:"bytecode"`
+        DeployedBytecode *artifactCode `json:"deployedBytecode"`
+        Metadata json.RawMessage `json:"metadata"`
+    }
+    if err := json.Unmarshal(data, &artifact); err != nil {
+        return nil, err
+    }
+
+    contract := &Contract{Name: name, ABI: artifact.ABI}
+    // Older versions of Foundry record the metadata as a string, which is ignored
+    var metadata struct {
+        Settings struct {
+            CompilationTarget map[string]string `json:"compilationTarget"`
+        } `json:"settings"`
+    }
+    if json.Unmarshal(artifact.Metadata, &metadata) == nil {
+        for file, target := range metadata.Settings.CompilationTarget {
+            contract.Name, contract.SourceName = target, file
+        }
+    }
+    var err error
+    if contract.Creation, err = artifact.Bytecode.decode(); err != nil {
+        return nil, err
+    }
+    if contract.Runtime, err = artifact.DeployedBytecode.decode(); err != nil {
+        return nil, err
+    }
+    return []*Contract{contract}, nil
+}
+
+// parseHardhat decodes a Hardhat artifact, which has no source maps.
+func parseHardhat(data []byte) ([]*Contract, error) {
+    var artifact struct {
+        ContractName string `json:"contractName"`
+        SourceName string `json:"sourceName"`
+        ABI json.RawMessage `json:"abi"`
+        Bytecode string `json:"bytecode"`
+        DeployedBytecode string `json:"deployedBytecode"`
+        LinkReferences linkReferences `json:"linkReferences"`
+        DeployedLinkReferences linkReferences `json:"deployedLinkReferences"`
+    }
+    if err := json.Unmarshal(data, &artifact); err != nil {
+        return nil, err
+    }
+
+    contract := &Contract{Name: artifact.ContractName, SourceName: artifact.SourceName, ABI: artifact.ABI}
+    var err error
+    contract.Creation, err = (&artifactCode{Object: artifact.Bytecode, LinkReferences: artifact.LinkReferences}).decode()
+    if err != nil {
+        return nil, err
+    }
+    contract.Runtime, err = (&artifactCode{Object: artifact.DeployedBytecode, LinkReferences: artifact.DeployedLinkReferences}).decode()
+    if err != nil {
+        return nil, err
+    }
+    return []*Contract{contract}, nil
+}
+
+// decode converts an artifact's code and references, returning nil if there is no code.
+func (self *artifactCode) decode() (*Bytecode, error) {
+    if self == nil {
+        return nil, nil
+    }
+    ret, err := ParseBytecode(self.Object)
+    if err != nil || ret == nil {
+        return nil, err
+    }
+    ret.SourceMap = self.SourceMap
+
+    // Name the placeholders found in the code from the artifact's references
+    placeholders := make(map[int]string)
+    for _, ref := range ret.LinkReferences {
+        placeholders[ref.Start] = ref.Placeholder
+    }
+    ret.LinkReferences = nil
+    for source, libraries := range self.LinkReferences {
+        for library, ranges := range libraries {
+            for _, r := range ranges {
+                if r.Start < 0 || r.Length < 0 || r.Start + r.Length > len(ret.Code) {
+                    return nil, fmt.Errorf("link reference to %v outside the code", library)
+                }
+                ret.LinkReferences = append(ret.LinkReferences, LinkReference{source, library, placeholders[r.Start], r.Start, r.Length})
+            }
+        }
+    }
+    for key, ranges := range self.ImmutableReferences {
+        id, err := strconv.Atoi(key)
+        if err != nil {
+            return nil, fmt.Errorf("immutable reference %q: %v", key, err)
+        }
+        for _, r := range ranges {
+            if r.Start < 0 || r.Length < 0 || r.Start + r.Length > len(ret.Code) {
+                return nil, fmt.Errorf("immutable reference %v outside the code", id)
+            }
+            ret.ImmutableReferences = append(ret.ImmutableReferences, ImmutableReference{id, r.Start, r.Length})
+        }
+    }
+    sort.Slice(ret.LinkReferences, func(i, j int) bool { return ret.LinkReferences[i].Start < ret.LinkReferences[j].Start })
+    sort.Slice(ret.ImmutableReferences, func(i, j int) bool { return ret.ImmutableReferences[i].Start < ret.ImmutableReferences[j].Start })
+    return ret, nil
+}
@@ -0,0 +1,134 @@
+package evmopt
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "io/ioutil"
+    "strings"
+    "testing"
+)
+
+// TestLoadArtifact loads the same contracts from each artifact format in testdata/artifacts.
+func TestLoadArtifact(t *testing.T) {
+    data, err := ioutil.ReadFile("testdata/contracts/ternary_call.hex")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ternary, err := hex.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        path string
+        name string
+        sourceMap bool
+        links int
+        immutables int
+    }{
+        {"testdata/artifacts/solc-output.json", "contracts/Ternary.sol:Ternary", true, 0, 0},
+        {"testdata/artifacts/solc-output.json", "Linked", false, 1, 1},
+        {"testdata/artifacts/Ternary.json", "", true, 0, 0},
+        {"testdata/artifacts/Linked.json", "Linked", false, 1, 0},
+    }
+    for _, test := range tests {
+        contracts, err := LoadArtifact(test.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        contract, err := FindContract(contracts, test.name)
+        if err != nil {
+            t.Fatalf("%v: %v", test.path, err)
+        }
+        if contract.Creation == nil || contract.Runtime == nil {
+            t.Fatalf("%v: %v is missing code", test.path, contract.Name)
+        }
+        if len(contract.Runtime.LinkReferences) != test.links || len(contract.Runtime.ImmutableReferences) != test.immutables {
+            t.Errorf("%v: %v has %d link and %d immutable references", test.path, contract.Name, len(contract.Runtime.LinkReferences), len(contract.Runtime.ImmutableReferences))
+        }
+        for _, ref := range contract.Runtime.LinkReferences {
+            if ref.Library != "Lib" || !bytes.Equal(contract.Runtime.Code[ref.Start:ref.Start + ref.Length], make([]byte, 20)) {
+                t.Errorf("%v: unexpected link reference %+v", test.path, ref)
+            }
+        }
+        if contract.Name != "Ternary" {
+            continue
+        }
+
+        if !bytes.Equal(contract.Runtime.Code, ternary) || !bytes.HasSuffix(contract.Creation.Code, ternary) {
+            t.Errorf("%v: wrong code for %v", test.path, contract.Name)
+        }
+        prog, err := contract.RuntimeProgram(context.Background(), nil)
+        if err != nil {
+            t.Fatalf("%v: %v", test.path, err)
+        }
+        if (prog.Instructions[0].Source != nil) != test.sourceMap {
+            t.Errorf("%v: source map attached is %v", test.path, prog.Instructions[0].Source != nil)
+        }
+        if fns := prog.InternalFunctions(); len(fns) != 1 {
+            t.Errorf("%v: found internal functions %v", test.path, fns)
+        }
+    }
+
+    if _, err := ParseArtifact([]byte(`{"abi": []}`), "Empty"); err != ErrUnknownArtifact {
+        t.Errorf("unrecognised artifact returned %v", err)
+    }
+}
+
+// TestLinkBytecode checks that placeholders are analysed as unknown values until linked.
+func TestLinkBytecode(t *testing.T) {
+    placeholder := "__$" + strings.Repeat("ab", 17) + "$__"
+    code, err := ParseBytecode("0x73" + placeholder + "31600055")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(code.LinkReferences) != 1 || code.LinkReferences[0].Start != 1 || !bytes.Equal(code.Code[1:21], make([]byte, 20)) {
+        t.Fatalf("unexpected references %+v in %x", code.LinkReferences, code.Code)
+    }
+
+    prog, err := NewProgramFromBytecode(context.Background(), code, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if prog.Instructions[0].Symbol != code.LinkReferences[0].Name() || prog.OperandValue(0x15, 0) != nil {
+        t.Errorf("placeholder was not analysed as unknown")
+    }
+    if expr := prog.Expression(0x15).String(); !strings.Contains(expr, "$" + strings.Repeat("ab", 17) + "$") {
+        t.Errorf("placeholder appears as %v", expr)
+    }
+    if _, err := prog.LowerSSA().Generate(); err != ErrUnlinked {
+        t.Errorf("generating unlinked code returned %v", err)
+    }
+
+    // solc derives the placeholder from the keccak of the library's qualified name
+    hash := Keccak256([]byte("lib/Lib.sol:Lib"))
+    code.LinkReferences[0].Placeholder = hex.EncodeToString(hash[:17])
+    linked, err := code.Link(map[string]Address{"lib/Lib.sol:Lib": {19: 0xaa}}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(linked.LinkReferences) != 0 || linked.Code[20] != 0xaa {
+        t.Fatalf("link left %+v in %x", linked.LinkReferences, linked.Code)
+    }
+    prog, err = NewProgramFromBytecode(context.Background(), linked, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if value := prog.OperandValue(0x15, 0); value == nil || value.Int64() != 0xaa {
+        t.Errorf("linked address is %v", value)
+    }
+}
+
+// TestMisplacedReference checks that a reference that does not fit in a PUSH is reported
+// at the PUSH it starts in.
+func TestMisplacedReference(t *testing.T) {
+    code := &Bytecode{Code: mustDecodeHex(t, "6000" + "600055"), ImmutableReferences: []ImmutableReference{{ID: 7, Start: 1, Length: 32}}}
+    prog, err := NewProgramFromBytecode(context.Background(), code, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(prog.Diagnostics) != 1 || prog.Diagnostics[0].PC != 0 || prog.Instructions[0].Symbol != "" {
+        t.Errorf("got diagnostics %+v, want one at 0x0", prog.Diagnostics)
+    }
+}
@@ -0,0 +1,175 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+)
+
+// Label marks a location in assembled code that can be pushed as a jump target.
+type Label struct {
+    pos int
+    placed bool
+}
+
+func NewLabel() *Label {
+    return &Label{}
+}
+
+type asmItem struct {
+    op OpCode
+    arg *big.Int
+    label *Label                    // If set, the item pushes the address of this label
+    mark *Label                     // If set, the item places this label and emits nothing
+    data []byte                     // If set, the item emits these bytes verbatim
+}
+
+// Assembler builds bytecode from instructions, resolving label addresses. All label
+// pushes are given the same width, the smallest that fits every label.
+type Assembler struct {
+    Fork Fork
+    items []asmItem
+}
+
+func NewAssembler(fork Fork) *Assembler {
+    return &Assembler{Fork: fork}
+}
+
+// Op appends an instruction that has no immediate.
+func (self *Assembler) Op(op OpCode) {
+    self.items = append(self.items, asmItem{op: op})
+}
+
+// Instruction appends op with the given immediate, which must fit its operand size.
+func (self *Assembler) Instruction(op OpCode, arg *big.Int) {
+    self.items = append(self.items, asmItem{op: op, arg: arg})
+}
+
+// Push appends the shortest push of value.
+func (self *Assembler) Push(value *big.Int) {
+    if value.Sign() == 0 && PUSH0.ValidIn(self.Fork) {
+        self.Op(PUSH0)
+        return
+    }
+    size := (value.BitLen() + 7) / 8
+    if size == 0 {
+        size = 1
+    }
+    self.Instruction(PUSH1 + OpCode(size - 1), value)
+}
+
+// PushLabel appends a push of the address label will have once placed.
+func (self *Assembler) PushLabel(label *Label) {
+    self.items = append(self.items, asmItem{label: label})
+}
+
+// Mark places label at the current position.
+func (self *Assembler) Mark(label *Label) {
+    self.items = append(self.items, asmItem{mark: label})
+}
+
+// Data appends raw bytes.
+func (self *Assembler) Data(data []byte) {
+    self.items = append(self.items, asmItem{data: data})
+}
+
+// Append adds all the items of other to the end of this assembler.
+func (self *Assembler) Append(other *Assembler) {
+    self.items = append(self.items, other.items...)
+}
+
+func (self asmItem) size(labelWidth int) int {
+    switch {
+    case self.mark != nil:
+        return 0
+    case self.data != nil:
+        return len(self.data)
+    case self.label != nil:
+        return 1 + labelWidth
+    }
+    return 1 + self.op.OperandSize()
+}
+
+func (self *Assembler) size(labelWidth int) int {
+    size := 0
+    for _, item := range self.items {
+        size += item.size(labelWidth)
+    }
+    return size
+}
+
+// Size returns the length of the assembled code, assuming two byte label addresses.
+func (self *Assembler) Size() int {
+    return self.size(2)
+}
+
+// StaticGas returns the static gas of all the instructions, for comparing alternative
+// code sequences.
+func (self *Assembler) StaticGas() uint64 {
+    gas := uint64(0)
+    for _, item := range self.items {
+        switch {
+        case item.mark != nil || item.data != nil:
+        case item.label != nil:
+            gas += PUSH1.StaticGas(self.Fork)
+        default:
+            gas += item.op.StaticGas(self.Fork)
+        }
+    }
+    return gas
+}
+
+// Assemble returns the bytecode, or an error if a pushed label was never placed or an
+// immediate does not fit its instruction.
+func (self *Assembler) Assemble() ([]byte, error) {
+    width := 1
+    for ; ; width++ {
+        if width > 4 {
+            return nil, fmt.Errorf("code too large to address")
+        }
+        pos := 0
+        for _, item := range self.items {
+            if item.mark != nil {
+                item.mark.pos = pos
+                item.mark.placed = true
+            }
+            pos += item.size(width)
+        }
+        if pos < 1 << uint(8 * width) {
+            break
+        }
+    }
+
+    code := make([]byte, 0, self.size(width))
+    for _, item := range self.items {
+        switch {
+        case item.mark != nil:
+        case item.data != nil:
+            code = append(code, item.data...)
+        case item.label != nil:
+            if !item.label.placed {
+                return nil, fmt.Errorf("label pushed at 0x%X was never placed", len(code))
+            }
+            code = append(code, byte(PUSH1) + byte(width - 1))
+            code = appendImmediate(code, big.NewInt(int64(item.label.pos)), width)
+        default:
+            code = append(code, byte(item.op))
+            if size := item.op.OperandSize(); size > 0 {
+                arg := item.arg
+                if arg == nil {
+                    arg = new(big.Int)
+                }
+                if arg.Sign() < 0 || arg.BitLen() > 8 * size {
+                    return nil, fmt.Errorf("immediate 0x%x does not fit %v at 0x%X", arg, item.op, len(code) - 1)
+                }
+                code = appendImmediate(code, arg, size)
+            }
+        }
+    }
+    return code, nil
+}
+
+func appendImmediate(code []byte, value *big.Int, size int) []byte {
+    buf := make([]byte, size)
+    value.FillBytes(buf)
+    return append(code, buf...)
+}
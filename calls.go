@@ -0,0 +1,242 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "sort"
+    "strings"
+)
+
+// TargetKind is where the address an external call is made to comes from.
+type TargetKind int
+
+const (
+    TargetUnknown TargetKind = iota
+    TargetConstant                  // Address pushed by the code, or an unlinked library
+    TargetStorage                   // Address loaded from storage
+    TargetCalldata                  // Address read from, or computed from, calldata
+    TargetCreated                   // The contract created by CREATE or CREATE2
+)
+
+func (self TargetKind) String() string {
+    switch self {
+    case TargetUnknown:
+        return "unknown"
+    case TargetConstant:
+        return "constant"
+    case TargetStorage:
+        return "storage"
+    case TargetCalldata:
+        return "calldata"
+    case TargetCreated:
+        return "created"
+    }
+    return fmt.Sprintf("TargetKind(%d)", int(self))
+}
+
+// ExternalCall is a site that calls or creates another contract.
+type ExternalCall struct {
+    PC int
+    Op OpCode
+    Target TargetKind
+    Address *Address                // Address called, if Target is TargetConstant and it is linked
+    Symbol string                   // Name of the unlinked library called, if any
+    Slot *big.Int                   // Storage slot the address is loaded from, if Target is TargetStorage and it is constant
+    Selector *uint32                // Function selector written to memory for the call, if found
+    Value *big.Int                  // Wei transferred, if constant; zero for calls that cannot transfer value
+    Gas *big.Int                    // Gas forwarded, if constant
+    AllGas bool                     // True if the gas forwarded is computed from the gas remaining
+    Functions []uint32              // Selectors of the external functions that can make the call
+}
+
+// TargetString returns a description of the address called.
+func (self *ExternalCall) TargetString() string {
+    switch {
+    case self.Address != nil:
+        return self.Address.String()
+    case self.Symbol != "":
+        return self.Symbol
+    case self.Slot != nil:
+        return fmt.Sprintf("storage[0x%x]", self.Slot)
+    }
+    return self.Target.String()
+}
+
+func (self *ExternalCall) String() string {
+    var w strings.Builder
+    fmt.Fprintf(&w, "0x%X: %v %s", self.PC, self.Op, self.TargetString())
+    if self.Selector != nil {
+        fmt.Fprintf(&w, " selector=0x%08x", *self.Selector)
+    }
+    switch {
+    case self.Value == nil:
+        w.WriteString(" value=?")
+    case self.Value.Sign() != 0:
+        fmt.Fprintf(&w, " value=0x%x", self.Value)
+    }
+    switch {
+    case self.AllGas:
+        w.WriteString(" gas=all")
+    case self.Gas != nil:
+        fmt.Fprintf(&w, " gas=%v", self.Gas)
+    case self.Op != CREATE && self.Op != CREATE2:
+        w.WriteString(" gas=?")
+    }
+    if len(self.Functions) > 0 {
+        selectors := make([]string, len(self.Functions))
+        for i, selector := range self.Functions {
+            selectors[i] = fmt.Sprintf("0x%08x", selector)
+        }
+        fmt.Fprintf(&w, " in %s", strings.Join(selectors, ","))
+    }
+    return w.String()
+}
+
+// How many blocks to search back from a call for the store of its selector
+const selectorStoreSearchDepth = 32
+
+// ExternalCalls returns the reachable CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE and
+// CREATE2 instructions in order, with where the address called comes from, the value and
+// gas passed, and the external functions that can reach each. The selector is the last
+// one stored to memory before the call, searching back through the blocks that must
+// precede it and into the internal functions it follows.
+func (self *Program) ExternalCalls() []*ExternalCall {
+    var calls []*ExternalCall
+    byPC := make(map[int]*ExternalCall)
+    returns := make(map[int][]*CallSite)
+    for _, fn := range self.InternalFunctions() {
+        for _, site := range fn.CallSites {
+            returns[site.Return] = append(returns[site.Return], site)
+        }
+    }
+
+    for _, pc := range self.reachableOps(CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2) {
+        call := &ExternalCall{PC: pc, Op: self.Instructions[pc].Op}
+        switch call.Op {
+        case CREATE, CREATE2:
+            call.Target = TargetCreated
+            call.Value = self.OperandValue(pc, 0)
+        default:
+            self.callTarget(call)
+            call.Gas = self.OperandValue(pc, 0)
+            _, call.AllGas = self.derivedFrom(pc, 0, false, GAS)
+            if call.Op == CALL || call.Op == CALLCODE {
+                call.Value = self.OperandValue(pc, 2)
+            } else {
+                call.Value = new(big.Int)
+            }
+            call.Selector = self.storedSelector(pc, returns)
+        }
+        calls = append(calls, call)
+        byPC[pc] = call
+    }
+    if len(calls) == 0 {
+        return calls
+    }
+
+    for _, fn := range self.ExternalFunctions() {
+        blocks, _ := self.functionBlocks(fn)
+        for block := range blocks {
+            for pc := block.Start; pc <= block.End; pc += self.Instructions[pc].Op.OperandSize() + 1 {
+                if call, ok := byPC[pc]; ok {
+                    call.Functions = append(call.Functions, fn.Selector)
+                }
+            }
+        }
+    }
+    for _, call := range calls {
+        sort.Slice(call.Functions, func(i, j int) bool { return call.Functions[i] < call.Functions[j] })
+    }
+    return calls
+}
+
+// callTarget sets where the address of call comes from.
+func (self *Program) callTarget(call *ExternalCall) {
+    if value := self.OperandValue(call.PC, 1); value != nil {
+        address := BigToAddress(value)
+        call.Target, call.Address = TargetConstant, &address
+        return
+    }
+    if source, ok := self.OperandSource(call.PC, 1); ok && self.Instructions[source].Symbol != "" {
+        call.Target, call.Symbol = TargetConstant, self.Instructions[source].Symbol
+        return
+    }
+    if _, ok := self.derivedFrom(call.PC, 1, false, CALLDATALOAD); ok {
+        call.Target = TargetCalldata
+        return
+    }
+    if load, ok := self.derivedFrom(call.PC, 1, false, SLOAD); ok {
+        call.Target, call.Slot = TargetStorage, self.OperandValue(load, 0)
+    }
+}
+
+// storedSelector returns the selector in the last MSTORE before the call at pc and after
+// any earlier call, searching back through blocks with a single predecessor and from the
+// places internal functions return to into the calls that made them.
+func (self *Program) storedSelector(pc int, returns map[int][]*CallSite) *uint32 {
+    block := self.BlockAt(pc)
+    end := pc
+    for depth := 0; block != nil && depth < selectorStoreSearchDepth; depth++ {
+        for p := end; p >= block.Start; p-- {
+            inst, ok := self.Instructions[p]
+            if !ok || p == pc {
+                continue
+            }
+            switch inst.Op {
+            case CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2:
+                // Memory written before an earlier call was for that call
+                return nil
+            case MSTORE:
+                if selector, ok := self.selectorValue(p); ok {
+                    return &selector
+                }
+            }
+        }
+
+        if sites := returns[block.Start]; len(sites) == 1 {
+            block, end = self.BlockAt(sites[0].Jump), sites[0].Jump
+        } else if len(block.Predecessors) == 1 {
+            block = block.Predecessors[0]
+            end = block.End
+        } else {
+            break
+        }
+    }
+    return nil
+}
+
+// selectorValue returns the selector stored by the MSTORE at pc, if it stores a constant
+// four bytes aligned to the start of the word, as PUSH32, as PUSH4 then SHL by 224, or as
+// PUSH4 multiplied by 2^224.
+func (self *Program) selectorValue(pc int) (uint32, bool) {
+    shift := new(big.Int).Lsh(big.NewInt(1), 224)
+    if value := self.OperandValue(pc, 1); value != nil {
+        selector, rem := new(big.Int).QuoRem(value, shift, new(big.Int))
+        if rem.Sign() == 0 && selector.Sign() > 0 && selector.BitLen() <= 32 {
+            return uint32(selector.Uint64()), true
+        }
+        return 0, false
+    }
+
+    source, ok := self.OperandSource(pc, 1)
+    if !ok {
+        return 0, false
+    }
+    var selector *big.Int
+    switch self.Instructions[source].Op {
+    case SHL:
+        if amount := self.OperandValue(source, 0); amount != nil && amount.Cmp(big.NewInt(224)) == 0 {
+            selector = self.OperandValue(source, 1)
+        }
+    case MUL:
+        for i := 0; i < 2; i++ {
+            if factor := self.OperandValue(source, i); factor != nil && factor.Cmp(shift) == 0 {
+                selector = self.OperandValue(source, 1 - i)
+            }
+        }
+    }
+    if selector == nil || selector.Sign() == 0 || selector.BitLen() > 32 {
+        return 0, false
+    }
+    return uint32(selector.Uint64()), true
+}
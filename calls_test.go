@@ -0,0 +1,50 @@
+package evmopt
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestExternalCalls(t *testing.T) {
+    const (
+        // Ways of writing the selector 0xa9059cbb to the start of memory
+        selectorPush32 = "7fa9059cbb00000000000000000000000000000000000000000000000000000000600052"
+        selectorShl = "63a9059cbb60e01b600052"
+        selectorMul = "63a9059cbb7c010000000000000000000000000000000000000000000000000000000002600052"
+        selectorMulSwapped = "7c010000000000000000000000000000000000000000000000000000000063a9059cbb02600052"
+
+        // Output and input ranges of a call with four bytes of input, pushed before the value, address and gas
+        callArgs = "6000600060046000"
+        constant = "730000000000000000000000000000000000c0ffee"
+        storage = "600354"
+        calldata = "600435"
+    )
+
+    tests := []struct {
+        code string
+        want string                 // The call, without its PC
+    }{
+        {selectorPush32 + callArgs + "6000" + constant + "61c350" + "f100", "CALL 0x0000000000000000000000000000000000c0ffee selector=0xa9059cbb gas=50000"},
+        {selectorShl + callArgs + "6000" + constant + "61c350" + "f100", "CALL 0x0000000000000000000000000000000000c0ffee selector=0xa9059cbb gas=50000"},
+        {selectorMul + callArgs + storage + "5a" + "fa00", "STATICCALL storage[0x3] selector=0xa9059cbb gas=all"},
+        {selectorMulSwapped + callArgs + calldata + "5a" + "f400", "DELEGATECALL calldata selector=0xa9059cbb gas=all"},
+        {callArgs + "34" + calldata + "5a" + "f100", "CALL calldata value=? gas=all"},
+        {callArgs + "6001" + storage + "5a" + "f100", "CALL storage[0x3] value=0x1 gas=all"},
+        // The address is loaded from a slot that depends on calldata
+        {callArgs + "6000" + "600035" + "54" + "5a" + "f100", "CALL storage gas=all"},
+        // Memory written before an earlier call was for that call
+        {selectorShl + callArgs + "6000" + constant + "5a" + "f150" + callArgs + "6000" + constant + "5a" + "f100", "CALL 0x0000000000000000000000000000000000c0ffee selector=0xa9059cbb gas=all, CALL 0x0000000000000000000000000000000000c0ffee gas=all"},
+        // CREATE2 of the first four bytes of memory
+        {"600060046000" + "34" + "f500", "CREATE2 created value=?"},
+    }
+
+    for _, tt := range tests {
+        var got []string
+        for _, call := range NewProgram(mustDecodeHex(t, tt.code)).ExternalCalls() {
+            got = append(got, strings.SplitN(call.String(), ": ", 2)[1])
+        }
+        if strings.Join(got, ", ") != tt.want {
+            t.Errorf("%v: got %v, want %v", tt.code, strings.Join(got, ", "), tt.want)
+        }
+    }
+}
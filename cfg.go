@@ -0,0 +1,176 @@
+package evmopt
+
+import (
+    "fmt"
+    "sort"
+)
+
+type BasicBlock struct {
+    ID int
+    Start int                       // PC of the first instruction in the block
+    End int                         // PC of the last instruction in the block
+    Reachable bool                  // True if analysis found a path from the entry point to this block
+    Successors []*BasicBlock
+    Predecessors []*BasicBlock
+}
+
+func (self *BasicBlock) String() string {
+    return fmt.Sprintf("block %d [0x%X-0x%X]", self.ID, self.Start, self.End)
+}
+
+// Contains returns true if pc falls within the block.
+func (self *BasicBlock) Contains(pc int) bool {
+    return pc >= self.Start && pc <= self.End
+}
+
+// PCs returns the program counters of all instructions in the program, in order.
+func (self *Program) PCs() []int {
+    pcs := make([]int, 0, len(self.Instructions))
+    for pc := range self.Instructions {
+        pcs = append(pcs, pc)
+    }
+    sort.Ints(pcs)
+    return pcs
+}
+
+// BlockAt returns the basic block containing pc, or nil if there is none.
+func (self *Program) BlockAt(pc int) *BasicBlock {
+    i := sort.Search(len(self.Blocks), func(i int) bool { return self.Blocks[i].End >= pc })
+    if i < len(self.Blocks) && self.Blocks[i].Contains(pc) {
+        return self.Blocks[i]
+    }
+    return nil
+}
+
+// Returns true if execution never continues to the next instruction after op.
+func endsBlock(op OpCode, fork Fork) bool {
+    switch op {
+    case JUMP, JUMPI, STOP, RETURN, REVERT, INVALID, SELFDESTRUCT:
+        return true
+    }
+    return !op.ValidIn(fork)
+}
+
+// buildBlocks partitions the program into basic blocks, using the control flow
+// edges found during analysis.
+func (self *Program) buildBlocks(edges map[int]map[int]bool) {
+    pcs := self.PCs()
+    visited := map[int]bool{0: true}
+    leaders := map[int]bool{0: true}
+    for from, targets := range edges {
+        visited[from] = true
+        for to := range targets {
+            visited[to] = true
+            if inst, ok := self.Instructions[from]; !ok || to != from + inst.Op.OperandSize() + 1 {
+                leaders[to] = true
+            }
+        }
+    }
+    for i, pc := range pcs {
+        op := self.Instructions[pc].Op
+        if op == JUMPDEST {
+            leaders[pc] = true
+        }
+        if endsBlock(op, self.Fork) && i + 1 < len(pcs) {
+            leaders[pcs[i + 1]] = true
+        }
+    }
+
+    self.Blocks = nil
+    var block *BasicBlock
+    for _, pc := range pcs {
+        if block == nil || leaders[pc] {
+            block = &BasicBlock{ID: len(self.Blocks), Start: pc}
+            self.Blocks = append(self.Blocks, block)
+        }
+        block.End = pc
+        block.Reachable = block.Reachable || visited[pc]
+    }
+
+    for _, block := range self.Blocks {
+        targets := make([]int, 0, len(edges[block.End]))
+        for to := range edges[block.End] {
+            targets = append(targets, to)
+        }
+        sort.Ints(targets)
+        for _, to := range targets {
+            if succ := self.BlockAt(to); succ != nil && succ.Start == to {
+                block.Successors = append(block.Successors, succ)
+                succ.Predecessors = append(succ.Predecessors, block)
+            }
+        }
+    }
+}
+
+// immediateDominators computes the immediate dominator of every node reachable from entry,
+// using the iterative algorithm of Cooper, Harvey and Kennedy. The entry maps to itself.
+func immediateDominators(entry *BasicBlock, succs, preds func(*BasicBlock) []*BasicBlock) map[*BasicBlock]*BasicBlock {
+    // Number nodes in postorder
+    order := make(map[*BasicBlock]int)
+    var postorder []*BasicBlock
+    var visit func(*BasicBlock)
+    visit = func(block *BasicBlock) {
+        order[block] = -1
+        for _, succ := range succs(block) {
+            if _, ok := order[succ]; !ok {
+                visit(succ)
+            }
+        }
+        order[block] = len(postorder)
+        postorder = append(postorder, block)
+    }
+    visit(entry)
+
+    idom := map[*BasicBlock]*BasicBlock{entry: entry}
+    intersect := func(a, b *BasicBlock) *BasicBlock {
+        for a != b {
+            for order[a] < order[b] {
+                a = idom[a]
+            }
+            for order[b] < order[a] {
+                b = idom[b]
+            }
+        }
+        return a
+    }
+
+    for changed := true; changed; {
+        changed = false
+        for i := len(postorder) - 1; i >= 0; i-- {
+            block := postorder[i]
+            if block == entry {
+                continue
+            }
+            var newIdom *BasicBlock
+            for _, pred := range preds(block) {
+                if _, ok := idom[pred]; !ok {
+                    continue
+                }
+                if newIdom == nil {
+                    newIdom = pred
+                } else {
+                    newIdom = intersect(pred, newIdom)
+                }
+            }
+            if newIdom != nil && idom[block] != newIdom {
+                idom[block] = newIdom
+                changed = true
+            }
+        }
+    }
+    return idom
+}
+
+// dominates returns true if a dominates b according to the immediate dominator map idom.
+func dominates(idom map[*BasicBlock]*BasicBlock, a, b *BasicBlock) bool {
+    for {
+        if b == a {
+            return true
+        }
+        next, ok := idom[b]
+        if !ok || next == b {
+            return false
+        }
+        b = next
+    }
+}
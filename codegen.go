@@ -0,0 +1,547 @@
+package evmopt
+
+import (
+    "errors"
+    "fmt"
+    "math/big"
+)
+
+// ErrCodeDependent is returned when generating code for a program that reads its own
+// code, and so cannot be laid out differently.
+var ErrCodeDependent = errors.New("program reads its own code")
+
+// ErrUnrelocatable is returned when generating code for a program with jumps whose targets
+// are not all known, or code addresses that are also used as data, since these cannot be
+// updated for a new layout.
+var ErrUnrelocatable = errors.New("program uses code addresses that cannot be relocated")
+
+// ErrUnlinked is returned when generating code for a program with unlinked library
+// addresses or immutables, whose locations would no longer match their references.
+var ErrUnlinked = errors.New("program has unlinked library addresses or immutables")
+
+// Deepest stack position DUP and SWAP can reach
+const (
+    maxDupDepth = 15
+    maxSwapDepth = 16
+)
+
+type codegen struct {
+    ssa *SSAProgram
+    fork Fork
+    spillBase *big.Int                      // Nil if values may not be spilled
+    spills int                              // Number of spill slots allocated so far
+    labels map[*SSABlock]*Label
+    blockAt map[int]*SSABlock               // Blocks by original start PC
+    addresses map[int]*SSABlock             // PUSHes of jump targets, to the block they address
+    next map[*SSABlock]*SSABlock            // Block laid out after each block
+}
+
+// Generate schedules the SSA program back onto the stack machine and assembles it. Values
+// keep the stack positions they had in the original program at block boundaries; within
+// each block operands are brought into place with as few DUPs, SWAPs and POPs as possible,
+// and values that would fall out of reach are spilled to memory from SpillBase upwards,
+// if it is set and the program does not read MSIZE; otherwise a block that would need a
+// spill keeps its original code. Each block keeps its original code where the scheduled code would cost more gas, or the
+// same gas and no fewer bytes.
+func (self *SSAProgram) Generate() ([]byte, error) {
+    if self.Program.Status != Complete {
+        return nil, fmt.Errorf("cannot generate code from incomplete analysis: %v", self.Program.Status)
+    }
+    spillBase := self.SpillBase
+    for _, inst := range self.Program.Instructions {
+        if inst.Op == CODECOPY || inst.Op == CODESIZE {
+            return nil, ErrCodeDependent
+        }
+        if inst.Symbol != "" {
+            return nil, ErrUnlinked
+        }
+        if inst.Op == MSIZE {
+            // Spilling would change the result
+            spillBase = nil
+        }
+    }
+
+    gen := &codegen{
+        ssa: self,
+        fork: self.Program.Fork,
+        spillBase: spillBase,
+        labels: make(map[*SSABlock]*Label),
+        blockAt: make(map[int]*SSABlock),
+        addresses: make(map[int]*SSABlock),
+        next: make(map[*SSABlock]*SSABlock),
+    }
+    for _, sb := range self.Blocks {
+        gen.labels[sb] = NewLabel()
+        gen.blockAt[sb.Block.Start] = sb
+    }
+    if err := gen.findAddresses(); err != nil {
+        return nil, err
+    }
+
+    layout := gen.layout()
+    dests := gen.jumpDests(layout)
+    asm := NewAssembler(gen.fork)
+    for _, sb := range layout {
+        asm.Mark(gen.labels[sb])
+        if dests[sb] {
+            asm.Op(JUMPDEST)
+        }
+        spills := gen.spills
+        scheduled := gen.schedule(sb)
+        original := gen.original(sb)
+        if scheduled != nil && gen.better(scheduled, spills, original) {
+            asm.Append(scheduled)
+        } else {
+            gen.spills = spills
+            asm.Append(original)
+        }
+    }
+    return asm.Assemble()
+}
+
+// better reports whether scheduled code is cheaper than the original code for a block.
+// Scheduling the block took the spill slots from spills upwards, and if it took any its
+// cost includes expanding memory to hold them.
+func (self *codegen) better(scheduled *Assembler, spills int, original *Assembler) bool {
+    gas := scheduled.StaticGas()
+    if self.spills > spills {
+        end := new(big.Int).Add(self.spillBase, big.NewInt(int64(32 * self.spills)))
+        if !end.IsUint64() {
+            return false
+        }
+        gas += memoryGas(toWords(end.Uint64()))
+    }
+    if gas != original.StaticGas() {
+        return gas < original.StaticGas()
+    }
+    return scheduled.Size() < original.Size()
+}
+
+// findAddresses records every PUSH whose value is used as a jump destination.
+func (self *codegen) findAddresses() error {
+    prog := self.ssa.Program
+    for _, sb := range self.ssa.Blocks {
+        inst := prog.Instructions[sb.Block.End]
+        if inst.Op != JUMP && inst.Op != JUMPI {
+            continue
+        }
+        for source := range inst.ReachedBy[0] {
+            push := prog.Instructions[source]
+            if !push.Op.IsPush() {
+                return ErrUnrelocatable
+            }
+            dest, ok := prog.jumpDest(push.Arg)
+            if !ok || self.blockAt[dest] == nil {
+                return ErrUnrelocatable
+            }
+            self.addresses[source] = self.blockAt[dest]
+        }
+    }
+
+    for source := range self.addresses {
+        for pc := range prog.Instructions[source].Reaches {
+            inst := prog.Instructions[pc]
+            if inst.Op != JUMP && inst.Op != JUMPI || !inst.ReachedBy[0][source] || inst.Op == JUMPI && inst.ReachedBy[1][source] {
+                return ErrUnrelocatable
+            }
+        }
+    }
+    return nil
+}
+
+// fallthroughOf returns the successor sb continues to when it does not jump, if any.
+func (self *codegen) fallthroughOf(sb *SSABlock) *SSABlock {
+    last := self.ssa.Program.Instructions[sb.Block.End]
+    if last.Op == JUMP {
+        return nil
+    }
+    next := sb.Block.End + last.Op.OperandSize() + 1
+    for _, succ := range sb.Succs {
+        if succ.Block.Start == next {
+            return succ
+        }
+    }
+    return nil
+}
+
+// layout orders the blocks, keeping fallthrough chains together where possible.
+func (self *codegen) layout() (order []*SSABlock) {
+    placed := make(map[*SSABlock]bool)
+    for _, sb := range self.ssa.Blocks {
+        for cur := sb; cur != nil && !placed[cur]; cur = self.fallthroughOf(cur) {
+            placed[cur] = true
+            if len(order) > 0 {
+                self.next[order[len(order) - 1]] = cur
+            }
+            order = append(order, cur)
+        }
+    }
+    return order
+}
+
+// jumpDests returns the blocks that are entered by a jump, and so must start with a JUMPDEST.
+func (self *codegen) jumpDests(layout []*SSABlock) map[*SSABlock]bool {
+    dests := make(map[*SSABlock]bool)
+    for _, sb := range layout {
+        fall := self.fallthroughOf(sb)
+        for _, succ := range sb.Succs {
+            if succ != fall || self.next[sb] != succ {
+                dests[succ] = true
+            }
+        }
+    }
+    for _, target := range self.addresses {
+        dests[target] = true
+    }
+    return dests
+}
+
+func (self *codegen) pushConstant(asm *Assembler, v *SSAValue) {
+    if target, ok := self.addresses[v.PC]; ok && v.Const != nil {
+        asm.PushLabel(self.labels[target])
+    } else {
+        asm.Push(v.Const)
+    }
+}
+
+// finish emits the jump to the fallthrough successor if it is not laid out next, or a STOP
+// if the block runs off the end of the code.
+func (self *codegen) finish(asm *Assembler, sb *SSABlock) {
+    last := self.ssa.Program.Instructions[sb.Block.End]
+    if last.Op == JUMP || endsBlock(last.Op, self.fork) && last.Op != JUMPI {
+        return
+    }
+    fall := self.fallthroughOf(sb)
+    switch {
+    case fall == nil:
+        asm.Op(STOP)
+    case self.next[sb] != fall:
+        asm.PushLabel(self.labels[fall])
+        asm.Op(JUMP)
+    }
+}
+
+// original returns the block's original instructions, with jump targets relocated.
+func (self *codegen) original(sb *SSABlock) *Assembler {
+    prog := self.ssa.Program
+    asm := NewAssembler(self.fork)
+    for pc := sb.Block.Start; pc <= sb.Block.End; {
+        inst := prog.Instructions[pc]
+        switch {
+        case inst.Op == JUMPDEST:
+        case inst.Op.IsPush() && self.addresses[pc] != nil:
+            asm.PushLabel(self.labels[self.addresses[pc]])
+        case inst.Op == PC:
+            asm.Push(big.NewInt(int64(pc)))
+        default:
+            asm.Instruction(inst.Op, inst.Arg)
+        }
+        pc += inst.Op.OperandSize() + 1
+    }
+    self.finish(asm, sb)
+    return asm
+}
+
+func valueKey(v *SSAValue) interface{} {
+    if v.Const != nil {
+        return v.Const.String()
+    }
+    return v
+}
+
+// blockScheduler tracks the stack while generating code for one block.
+type blockScheduler struct {
+    *codegen
+    asm *Assembler
+    stack []*SSAValue                       // Bottom first
+    uses map[*SSAValue]int                  // Remaining uses, including by the exit stack
+    spilled map[*SSAValue]*big.Int          // Memory offsets of spilled values
+}
+
+// schedule generates new code for sb, or returns nil if it cannot.
+func (self *codegen) schedule(sb *SSABlock) *Assembler {
+    s := &blockScheduler{
+        codegen: self,
+        asm: NewAssembler(self.fork),
+        uses: make(map[*SSAValue]int),
+        spilled: make(map[*SSAValue]*big.Int),
+    }
+    for i := len(sb.Entry) - 1; i >= 0; i-- {
+        s.stack = append(s.stack, sb.Entry[i])
+    }
+
+    term := sb.Terminator()
+    for _, v := range sb.Values {
+        for i, arg := range v.Args {
+            if v == term && (v.Op == JUMP || v.Op == JUMPI) && i == 0 && self.staticTarget(sb, v) != nil {
+                continue
+            }
+            s.uses[arg] += 1
+        }
+    }
+    if len(sb.Succs) > 0 {
+        for _, v := range sb.Exit {
+            s.uses[v] += 1
+        }
+    }
+
+    if err := s.run(sb, term); err != nil {
+        return nil
+    }
+    self.finish(s.asm, sb)
+    return s.asm
+}
+
+// staticTarget returns the block a jump goes to if its destination is a constant.
+func (self *codegen) staticTarget(sb *SSABlock, jump *SSAValue) *SSABlock {
+    if jump.Args[0].Const == nil || !jump.Args[0].Const.IsInt64() {
+        return nil
+    }
+    target := self.blockAt[int(jump.Args[0].Const.Int64())]
+    for _, succ := range sb.Succs {
+        if succ == target {
+            return target
+        }
+    }
+    return nil
+}
+
+func (self *blockScheduler) run(sb *SSABlock, term *SSAValue) error {
+    self.popDead()
+    for _, v := range sb.Values {
+        if v == term && (v.Op == JUMP || v.Op == JUMPI) && len(sb.Succs) > 0 {
+            break
+        }
+        if v.Op == PC {
+            // PC is a constant once its location is known
+            self.grow()
+            self.asm.Push(big.NewInt(int64(v.PC)))
+            self.stack = append(self.stack, v)
+            self.popDead()
+            continue
+        }
+        for i := len(v.Args) - 1; i >= 0; i-- {
+            if err := self.bring(v.Args[i], i == len(v.Args) - 1); err != nil {
+                return err
+            }
+        }
+        if len(v.Args) == 0 && v.HasResult() {
+            self.grow()
+        }
+        self.asm.Op(v.Op)
+        self.stack = self.stack[:len(self.stack) - len(v.Args)]
+        if v.HasResult() {
+            self.stack = append(self.stack, v)
+        }
+        self.popDead()
+    }
+
+    if len(sb.Succs) == 0 {
+        return nil
+    }
+
+    // Arrange the exit stack, with the operands of any terminating jump on top
+    var target []*SSAValue
+    for i := len(sb.Exit) - 1; i >= 0; i-- {
+        target = append(target, sb.Exit[i])
+    }
+    if term == nil || (term.Op != JUMP && term.Op != JUMPI) {
+        return self.shuffle(target)
+    }
+    static := self.staticTarget(sb, term)
+    if term.Op == JUMPI {
+        target = append(target, term.Args[1])
+    }
+    if static == nil {
+        target = append(target, term.Args[0])
+    }
+    if err := self.shuffle(target); err != nil {
+        return err
+    }
+    if static != nil {
+        self.asm.PushLabel(self.labels[static])
+    }
+    self.asm.Op(term.Op)
+    return nil
+}
+
+func (self *blockScheduler) depth(i int) int {
+    return len(self.stack) - 1 - i
+}
+
+// find returns the index of the topmost copy of v on the stack, or -1.
+func (self *blockScheduler) find(v *SSAValue) int {
+    for i := len(self.stack) - 1; i >= 0; i-- {
+        if self.stack[i] == v {
+            return i
+        }
+    }
+    return -1
+}
+
+func (self *blockScheduler) count(v *SSAValue) int {
+    n := 0
+    for _, s := range self.stack {
+        if s == v {
+            n += 1
+        }
+    }
+    return n
+}
+
+func (self *blockScheduler) swap(i int) error {
+    d := self.depth(i)
+    if d == 0 {
+        return nil
+    }
+    if d > maxSwapDepth {
+        return fmt.Errorf("stack too deep")
+    }
+    self.asm.Op(SWAP1 + OpCode(d - 1))
+    top := len(self.stack) - 1
+    self.stack[i], self.stack[top] = self.stack[top], self.stack[i]
+    return nil
+}
+
+// grow is called before anything is pushed, and spills the value about to fall out of
+// reach of DUP16 if it will be needed again and spilling is allowed.
+func (self *blockScheduler) grow() {
+    i := len(self.stack) - 1 - maxDupDepth
+    if i < 0 || self.spillBase == nil {
+        return
+    }
+    v := self.stack[i]
+    if v.Const != nil || v.Undef || self.uses[v] == 0 || self.spilled[v] != nil || self.find(v) != i {
+        return
+    }
+    slot := new(big.Int).Add(self.spillBase, big.NewInt(int64(32 * self.spills)))
+    self.spills += 1
+    self.spilled[v] = slot
+    self.asm.Op(DUP16)
+    self.asm.Push(slot)
+    self.asm.Op(MSTORE)
+}
+
+// produce pushes a fresh copy of v.
+func (self *blockScheduler) produce(v *SSAValue) error {
+    i := self.find(v)
+    switch {
+    case v.Const != nil:
+        self.grow()
+        self.pushConstant(self.asm, v)
+    case i != -1 && self.depth(i) <= maxDupDepth:
+        self.grow()
+        self.asm.Op(DUP1 + OpCode(self.depth(i)))
+    case self.spilled[v] != nil:
+        self.grow()
+        self.asm.Push(self.spilled[v])
+        self.asm.Op(MLOAD)
+    case v.Undef:
+        self.grow()
+        self.asm.Push(new(big.Int))
+    default:
+        return fmt.Errorf("value %v out of reach", v)
+    }
+    self.stack = append(self.stack, v)
+    return nil
+}
+
+// bring puts v on top of the stack as an operand. The first operand brought for an
+// instruction may be moved rather than copied if it is not needed again.
+func (self *blockScheduler) bring(v *SSAValue, first bool) error {
+    self.uses[v] -= 1
+    if v.Const == nil && first {
+        i := self.find(v)
+        if i != -1 && (self.uses[v] == 0 || self.count(v) > 1) && self.depth(i) <= maxSwapDepth {
+            return self.swap(i)
+        }
+    }
+    return self.produce(v)
+}
+
+// popDead removes values that are not needed again from the top of the stack.
+func (self *blockScheduler) popDead() {
+    for len(self.stack) > 0 {
+        top := self.stack[len(self.stack) - 1]
+        if self.uses[top] > 0 && self.count(top) <= self.uses[top] {
+            return
+        }
+        self.asm.Op(POP)
+        self.stack = self.stack[:len(self.stack) - 1]
+    }
+}
+
+// shuffle rearranges the stack to exactly match target, bottom first.
+func (self *blockScheduler) shuffle(target []*SSAValue) error {
+    p := 0
+    for p < len(self.stack) && p < len(target) && sameValue(self.stack[p], target[p]) {
+        p += 1
+    }
+
+    need := make(map[interface{}]int)
+    for _, v := range target[p:] {
+        need[valueKey(v)] += 1
+    }
+
+    // Remove surplus values
+    for {
+        have := make(map[interface{}]int)
+        for _, v := range self.stack[p:] {
+            have[valueKey(v)] += 1
+        }
+        surplus := -1
+        for i := len(self.stack) - 1; i >= p; i-- {
+            if have[valueKey(self.stack[i])] > need[valueKey(self.stack[i])] {
+                surplus = i
+                break
+            }
+        }
+        if surplus == -1 {
+            break
+        }
+        if err := self.swap(surplus); err != nil {
+            return err
+        }
+        self.asm.Op(POP)
+        self.stack = self.stack[:len(self.stack) - 1]
+    }
+
+    // Add missing values
+    have := make(map[interface{}]int)
+    for _, v := range self.stack[p:] {
+        have[valueKey(v)] += 1
+    }
+    for _, v := range target[p:] {
+        if have[valueKey(v)] < need[valueKey(v)] {
+            have[valueKey(v)] += 1
+            if err := self.produce(v); err != nil {
+                return err
+            }
+        }
+    }
+
+    // Permute into place, fixing one position at a time from the bottom
+    for i := p; i < len(target); i++ {
+        if sameValue(self.stack[i], target[i]) {
+            continue
+        }
+        j := -1
+        for k := len(self.stack) - 1; k > i; k-- {
+            if sameValue(self.stack[k], target[i]) {
+                if j == -1 || !sameValue(self.stack[k], target[k]) {
+                    j = k
+                }
+            }
+        }
+        if j == -1 {
+            return fmt.Errorf("value %v missing from stack", target[i])
+        }
+        if err := self.swap(j); err != nil {
+            return err
+        }
+        if err := self.swap(i); err != nil {
+            return err
+        }
+    }
+    return nil
+}
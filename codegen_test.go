@@ -0,0 +1,87 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// TestGenerateRoundTrip regenerates each contract in testdata/contracts that can be
+// relocated and checks that the new code behaves as the original does.
+func TestGenerateRoundTrip(t *testing.T) {
+    paths, err := filepath.Glob("testdata/contracts/*.hex")
+    if err != nil {
+        t.Fatal(err)
+    }
+    generated := 0
+    for _, path := range paths {
+        name := strings.TrimSuffix(filepath.Base(path), ".hex")
+        code := loadContract(t, name)
+        out, err := NewProgram(code).LowerSSA().Generate()
+        switch err {
+        case nil:
+            generated += 1
+        case ErrCodeDependent, ErrUnrelocatable, ErrUnlinked:
+            continue
+        default:
+            t.Errorf("%v: %v", name, err)
+            continue
+        }
+        // Little gas, so that inputs that loop until it runs out finish quickly
+        CheckEquivalent(t, code, out, &DiffOptions{Runs: 64, Gas: 100000})
+    }
+    if generated == 0 {
+        t.Fatal("no contract could be regenerated")
+    }
+}
+
+// spillCode returns code that loads 17 words of calldata, wastes gas the scheduler can
+// save, then stores the words from the last loaded to the first, followed by extra. The
+// first word is out of reach of DUP16 once the last is loaded.
+func spillCode(t *testing.T, extra string) []byte {
+    var code strings.Builder
+    for i := 0; i < 17; i++ {
+        fmt.Fprintf(&code, "61%04x35", i * 32)         // CALLDATALOAD(i * 32)
+    }
+    code.WriteString(strings.Repeat("600050", 10))      // PUSH1 0 POP
+    for i := 16; i >= 0; i-- {
+        fmt.Fprintf(&code, "60%02x55", i)               // SSTORE(i, word i)
+    }
+    code.WriteString(extra)
+    code.WriteString("00")
+    return mustDecodeHex(t, code.String())
+}
+
+func TestGenerateSpill(t *testing.T) {
+    tests := []struct {
+        name string
+        code []byte
+        spillBase *big.Int
+        spilled bool
+    }{
+        {"spill", spillCode(t, ""), big.NewInt(0x20), true},
+        {"spilling disabled", spillCode(t, ""), nil, false},
+        // SSTORE(17, MSIZE)
+        {"reads msize", spillCode(t, "59601155"), big.NewInt(0x20), false},
+    }
+
+    for _, tt := range tests {
+        ssa := NewProgram(tt.code).LowerSSA()
+        ssa.SpillBase = tt.spillBase
+        out, err := ssa.Generate()
+        if err != nil {
+            t.Errorf("%v: %v", tt.name, err)
+            continue
+        }
+        spilled := false
+        for _, inst := range NewProgram(out).Instructions {
+            spilled = spilled || inst.Op == MSTORE
+        }
+        if spilled != tt.spilled {
+            t.Errorf("%v: got spilled=%v, want %v in %x", tt.name, spilled, tt.spilled, out)
+        }
+        CheckEquivalent(t, tt.code, out, &DiffOptions{Runs: 64})
+    }
+}
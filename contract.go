@@ -1,6 +1,7 @@
 package evmopt
 
 import (
+	"context"
 	"fmt"
     "math/big"
 )
@@ -10,22 +11,48 @@ type Instruction struct {
     Arg *big.Int
     Reaches map[int]bool 		// List of program addresses that rely on the output of this instruction
     ReachedBy []map[int]bool 	// List of program addresses that may provide the value for each operand
+    Source *SourceRange 		// Span of source code the instruction was generated from, if a source map was set
+    Symbol string 			// Name of a PUSH operand not known until linking or deployment, which Arg does not hold
 }
 
 func (self Instruction) String() string {
-	if self.Arg != nil {
+	if self.Symbol != "" {
+		return fmt.Sprintf("%v %s", self.Op, self.Symbol)
+	} else if self.Arg != nil && self.Op.OperandSize() > 0 {
 		return fmt.Sprintf("%v 0x%x", self.Op, self.Arg)
 	} else {
 		return self.Op.String()
 	}
 }
 
+type Diagnostic struct {
+	PC int
+	Message string
+}
+
+func (self Diagnostic) String() string {
+	return fmt.Sprintf("0x%X: %s", self.PC, self.Message)
+}
+
 type Program struct {
+	Code []byte
 	Instructions map[int]*Instruction
+	Blocks []*BasicBlock
+	Fork Fork
+	Status Status
+	Diagnostics []Diagnostic
+	SourceFiles []string	// Names of the source files, by the index used in the source map
+	contexts map[int][]*ContextReaching	// Per-context reaching definitions, if Options.ContextDepth was set
 }
 
 func NewProgram(bytecode []byte) *Program {
+	program, _ := NewProgramWithOptions(context.Background(), bytecode, nil)
+	return program
+}
+
+func decodeProgram(bytecode []byte) *Program {
 	program := &Program{
+		Code: bytecode,
 		Instructions: make(map[int]*Instruction),
 	}
 
@@ -33,7 +60,9 @@ func NewProgram(bytecode []byte) *Program {
 		op := OpCode(bytecode[i])
 		size := op.OperandSize()
 		var arg *big.Int
-		if size > 0 {
+		if op == PUSH0 {
+			arg = big.NewInt(0)
+		} else if size > 0 {
 			arg = big.NewInt(0)
 			for j := 1; j <= size; j++ {
 				arg.Lsh(arg, 8)
@@ -51,7 +80,39 @@ func NewProgram(bytecode []byte) *Program {
 		i += size
 	}
 
-	program.buildReachings()
-
 	return program
 }
+
+// OperandSource returns the PC of the only instruction that can supply operand i of
+// the instruction at pc, or false if there is not exactly one.
+func (self *Program) OperandSource(pc, i int) (int, bool) {
+	inst, ok := self.Instructions[pc]
+	if !ok || i >= len(inst.ReachedBy) || len(inst.ReachedBy[i]) != 1 {
+		return 0, false
+	}
+	for source := range inst.ReachedBy[i] {
+		return source, true
+	}
+	return 0, false
+}
+
+// OperandValue returns the value of operand i of the instruction at pc if it is a
+// constant known statically, or nil otherwise.
+func (self *Program) OperandValue(pc, i int) *big.Int {
+	inst, ok := self.Instructions[pc]
+	if !ok || i >= len(inst.ReachedBy) || len(inst.ReachedBy[i]) == 0 {
+		return nil
+	}
+	var value *big.Int
+	for source := range inst.ReachedBy[i] {
+		producer := self.Instructions[source]
+		if !producer.Op.IsPush() || producer.Arg == nil || producer.Symbol != "" {
+			return nil
+		}
+		if value != nil && value.Cmp(producer.Arg) != 0 {
+			return nil
+		}
+		value = producer.Arg
+	}
+	return value
+}
@@ -0,0 +1,1084 @@
+package evmopt
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Ops whose result depends on mutable state, and so cannot be moved past a side effect
+func readsState(op OpCode) bool {
+    switch op {
+    case SLOAD, TLOAD, MLOAD, SHA3, MSIZE, GAS, BALANCE, SELFBALANCE, EXTCODESIZE, EXTCODEHASH, RETURNDATASIZE:
+        return true
+    }
+    return hasSideEffects(op)
+}
+
+func hasSideEffects(op OpCode) bool {
+    switch op {
+    case SSTORE, TSTORE, MSTORE, MSTORE8, MCOPY, CALLDATACOPY, CODECOPY, EXTCODECOPY, RETURNDATACOPY,
+        LOG0, LOG1, LOG2, LOG3, LOG4, CREATE, CREATE2, CALL, CALLCODE, DELEGATECALL, STATICCALL, SELFDESTRUCT:
+        return true
+    }
+    return false
+}
+
+// Ops that are cheap to read and whose value cannot change during a call; these are never
+// assigned to variables.
+func isInvariant(op OpCode) bool {
+    switch op {
+    case ADDRESS, ORIGIN, CALLER, CALLVALUE, CALLDATASIZE, CODESIZE, GASPRICE, COINBASE, TIMESTAMP,
+        NUMBER, DIFFICULTY, GASLIMIT, CHAINID, BASEFEE, BLOBBASEFEE, PC:
+        return true
+    }
+    return op.IsPush()
+}
+
+var infixOps = map[OpCode]string{
+    ADD: "+",
+    MUL: "*",
+    SUB: "-",
+    DIV: "/",
+    MOD: "%",
+    EXP: "**",
+    LT:  "<",
+    GT:  ">",
+    EQ:  "==",
+    AND: "&",
+    OR:  "|",
+    XOR: "^",
+}
+
+func negate(expr string) string {
+    if strings.HasPrefix(expr, "!") {
+        return expr[1:]
+    }
+    return "!" + expr
+}
+
+// unparen strips the outer parentheses from an expression, if they enclose all of it.
+func unparen(expr string) string {
+    if !strings.HasPrefix(expr, "(") || !strings.HasSuffix(expr, ")") {
+        return expr
+    }
+    depth := 0
+    for i, c := range expr {
+        switch c {
+        case '(':
+            depth += 1
+        case ')':
+            depth -= 1
+            if depth == 0 && i != len(expr) - 1 {
+                return expr
+            }
+        }
+    }
+    return expr[1:len(expr) - 1]
+}
+
+func memoryRange(offset, size string) string {
+    if offset == "0x0" {
+        return fmt.Sprintf("memory[0x0:%s]", size)
+    }
+    return fmt.Sprintf("memory[%s:%s + %s]", offset, offset, size)
+}
+
+func functionName(fn *ExternalFunction) string {
+    return fmt.Sprintf("func_%08x", fn.Selector)
+}
+
+type decompiler struct {
+    prog *Program
+    exprs *expressionBuilder
+    pcs []int
+    index map[int]int                           // PC to position in pcs
+    functions map[int][]*ExternalFunction       // Functions by entry PC, ordered by selector
+    internals map[int]*InternalFunction         // Internal functions by entry PC
+    calls map[int]*CallSite                     // Calls to internal functions, by the PC of their JUMP
+    returns map[int]bool                        // JUMPs that return from internal functions
+    uses map[int]int                            // Number of times each value is rendered
+    usedAt map[int]int                          // Location of the last use of each value
+    merged map[int]bool                         // Values that reach an operand along with others
+    decided map[int]bool
+    inlined map[int]bool
+    stateDep map[int]bool
+    current *functionDecompiler                 // Function being emitted
+    rendering map[int]bool                      // Values being rendered, to stop at cycles
+}
+
+func newDecompiler(prog *Program) *decompiler {
+    self := &decompiler{
+        prog: prog,
+        exprs: newExpressionBuilder(prog),
+        pcs: prog.PCs(),
+        index: make(map[int]int),
+        functions: make(map[int][]*ExternalFunction),
+        internals: make(map[int]*InternalFunction),
+        calls: make(map[int]*CallSite),
+        returns: make(map[int]bool),
+        uses: make(map[int]int),
+        usedAt: make(map[int]int),
+        merged: make(map[int]bool),
+        decided: make(map[int]bool),
+        inlined: make(map[int]bool),
+        stateDep: make(map[int]bool),
+        rendering: make(map[int]bool),
+    }
+    for i, pc := range self.pcs {
+        self.index[pc] = i
+    }
+    for _, fn := range prog.ExternalFunctions() {
+        self.functions[fn.Entry] = append(self.functions[fn.Entry], fn)
+    }
+    for _, fn := range prog.InternalFunctions() {
+        self.internals[fn.Entry] = fn
+        for _, call := range fn.CallSites {
+            self.calls[call.Jump] = call
+        }
+        for _, pc := range fn.Exits {
+            self.returns[pc] = true
+        }
+    }
+
+    // Count uses. Values that reach an operand along with others are copied into a
+    // variable where control flow joins, so each keeps a variable of its own.
+    for _, pc := range self.pcs {
+        inst := prog.Instructions[pc]
+        if inst.Op.IsDup() || inst.Op.IsSwap() || inst.Op == POP {
+            continue
+        }
+        for i := range inst.ReachedBy {
+            if (inst.Op == JUMP || inst.Op == JUMPI) && i == 0 {
+                continue
+            }
+            expr := self.exprs.operand(pc, i)
+            sources := []*Expression{expr}
+            if expr.IsMerge() {
+                sources = expr.Alternatives
+            }
+            for _, source := range sources {
+                if source.PC == -1 {
+                    continue
+                }
+                self.uses[source.PC] += 1
+                self.usedAt[source.PC] = pc
+                self.merged[source.PC] = self.merged[source.PC] || expr.IsMerge()
+            }
+        }
+    }
+    for _, pc := range self.pcs {
+        self.decide(pc)
+    }
+    return self
+}
+
+// sideEffectsBetween returns true if any instruction strictly between from and to has side effects.
+func (self *decompiler) sideEffectsBetween(from, to int) bool {
+    for i := self.index[from] + 1; i < len(self.pcs) && self.pcs[i] < to; i++ {
+        if hasSideEffects(self.prog.Instructions[self.pcs[i]].Op) {
+            return true
+        }
+    }
+    return false
+}
+
+// decide determines whether the value produced at pc is rendered inline at its use, or
+// assigned to a variable where it is computed.
+func (self *decompiler) decide(pc int) {
+    if self.decided[pc] {
+        return
+    }
+    self.decided[pc] = true
+
+    inst := self.prog.Instructions[pc]
+    op := inst.Op
+    if op.StackWrites() != 1 || op.IsDup() || op.IsSwap() {
+        return
+    }
+    if self.merged[pc] && !isInvariant(op) {
+        // Copied where control flow joins, which may be far from where it is computed
+        return
+    }
+
+    dependent := readsState(op)
+    for i := range inst.ReachedBy {
+        for source := range inst.ReachedBy[i] {
+            if len(inst.ReachedBy[i]) > 1 {
+                // Variables can be reassigned
+                dependent = true
+                continue
+            }
+            self.decide(source)
+            if self.inlined[source] && self.stateDep[source] {
+                dependent = true
+            }
+        }
+    }
+    self.stateDep[pc] = dependent
+
+    switch {
+    case isInvariant(op):
+        self.inlined[pc] = true
+    case self.uses[pc] != 1:
+        self.inlined[pc] = false
+    case !dependent:
+        self.inlined[pc] = true
+    default:
+        use := self.usedAt[pc]
+        same := self.prog.BlockAt(pc) == self.prog.BlockAt(use)
+        self.inlined[pc] = same && use > pc && !self.sideEffectsBetween(pc, use)
+    }
+}
+
+// name returns the variable holding the value produced at pc, if it is not inlined.
+func (self *decompiler) name(pc int) (string, bool) {
+    if !self.inlined[pc] {
+        return fmt.Sprintf("var_%X", pc), true
+    }
+    return "", false
+}
+
+// operand renders operand i of the instruction at pc, from the SSA form of the function
+// being emitted where it has one, and otherwise from the operand's sources.
+func (self *decompiler) operand(pc, i int) string {
+    if fn := self.current; fn != nil {
+        if args, ok := fn.operands[pc]; ok && i < len(args) {
+            if text, ok := fn.renderSSA(args[i]); ok {
+                return text
+            }
+        }
+    }
+    return self.renderOperand(self.exprs.operand(pc, i))
+}
+
+func (self *decompiler) operands(pc int) []string {
+    ret := make([]string, len(self.prog.Instructions[pc].ReachedBy))
+    for i := range ret {
+        ret[i] = self.operand(pc, i)
+    }
+    return ret
+}
+
+func (self *decompiler) call(name string, args []string) string {
+    return fmt.Sprintf("%s(%s)", strings.ToLower(name), strings.Join(args, ", "))
+}
+
+// renderOperand returns the variable holding expr, or expr itself if it is inlined. A
+// merge the function's SSA form does not resolve has no single value to show.
+func (self *decompiler) renderOperand(expr *Expression) string {
+    if expr.IsUnknown() || expr.IsMerge() {
+        return "?"
+    }
+    return self.renderValue(expr.PC)
+}
+
+// renderValue returns the variable holding the value produced at pc, or the computation
+// itself if it is inlined.
+func (self *decompiler) renderValue(pc int) string {
+    if name, ok := self.name(pc); ok {
+        return name
+    }
+    if self.rendering[pc] {
+        return fmt.Sprintf("var_%X", pc)
+    }
+    self.rendering[pc] = true
+    defer delete(self.rendering, pc)
+    return self.render(pc)
+}
+
+// render returns the source for the computation at pc.
+func (self *decompiler) render(pc int) string {
+    expr := self.exprs.value(pc)
+    if expr.IsConstant() {
+        return fmt.Sprintf("0x%x", expr.Value)
+    }
+    if expr.Symbol != "" {
+        return expr.Symbol
+    }
+
+    args := self.operands(pc)
+    op := expr.Op
+    if symbol, ok := infixOps[op]; ok {
+        return fmt.Sprintf("(%s %s %s)", args[0], symbol, args[1])
+    }
+    switch op {
+    case ISZERO:
+        return negate(args[0])
+    case NOT:
+        return "~" + args[0]
+    case SHL:
+        return fmt.Sprintf("(%s << %s)", args[1], args[0])
+    case SHR:
+        return fmt.Sprintf("(%s >> %s)", args[1], args[0])
+    case SLOAD:
+        return fmt.Sprintf("storage[%s]", args[0])
+    case TLOAD:
+        return fmt.Sprintf("transient[%s]", args[0])
+    case MLOAD:
+        return fmt.Sprintf("memory[%s]", args[0])
+    case CALLDATALOAD:
+        return fmt.Sprintf("calldata[%s]", args[0])
+    case SHA3:
+        return fmt.Sprintf("keccak256(%s)", memoryRange(args[0], args[1]))
+    }
+    return self.call(op.String(), args)
+}
+
+// statement returns the source line for the instruction at pc, if it has one.
+func (self *decompiler) statement(pc int) (string, bool) {
+    inst := self.prog.Instructions[pc]
+    op := inst.Op
+
+    if !op.ValidIn(self.prog.Fork) {
+        return "invalid();", true
+    }
+    if op.StackWrites() == 1 && !op.IsDup() && !op.IsSwap() {
+        used := self.uses[pc] > 0 || (self.current != nil && self.current.used(pc))
+        if !self.inlined[pc] && used {
+            name, _ := self.name(pc)
+            return fmt.Sprintf("%s = %s;", name, self.render(pc)), true
+        }
+        if !used && hasSideEffects(op) {
+            return self.render(pc) + ";", true
+        }
+        return "", false
+    }
+
+    switch op {
+    case JUMPDEST, JUMP, JUMPI, POP:
+        return "", false
+    case STOP:
+        return "return;", true
+    }
+    if op.IsDup() || op.IsSwap() {
+        return "", false
+    }
+
+    args := self.operands(pc)
+    switch op {
+    case SSTORE:
+        return fmt.Sprintf("storage[%s] = %s;", args[0], args[1]), true
+    case TSTORE:
+        return fmt.Sprintf("transient[%s] = %s;", args[0], args[1]), true
+    case MSTORE:
+        return fmt.Sprintf("memory[%s] = %s;", args[0], args[1]), true
+    case RETURN:
+        return fmt.Sprintf("return %s;", memoryRange(args[0], args[1])), true
+    case REVERT:
+        return fmt.Sprintf("revert(%s);", memoryRange(args[0], args[1])), true
+    case LOG0, LOG1, LOG2, LOG3, LOG4:
+        return self.call(op.String(), append([]string{memoryRange(args[0], args[1])}, args[2:]...)) + ";", true
+    }
+    return self.call(op.String(), args) + ";", true
+}
+
+// statements returns the source lines for the instructions in a block.
+func (self *decompiler) statements(block *BasicBlock) (ret []string) {
+    for i := self.index[block.Start]; i < len(self.pcs) && self.pcs[i] <= block.End; i++ {
+        if line, ok := self.statement(self.pcs[i]); ok {
+            ret = append(ret, line)
+        }
+    }
+    return ret
+}
+
+type decompiledLine struct {
+    indent int
+    text string
+    label *BasicBlock                           // If set, this line is a label and only printed if used
+}
+
+type loopContext struct {
+    header *BasicBlock
+    exit *BasicBlock
+}
+
+// How many stack items a function is given on entry; those below an internal function's
+// arguments, and all of an external function's, are left to its callers
+const decompileEntrySlots = 32
+
+// functionDecompiler structures the code reachable from a single entry point.
+type functionDecompiler struct {
+    *decompiler
+    entry *BasicBlock
+    internal *InternalFunction                  // The internal function emitted, if any
+    exit *BasicBlock                            // Virtual exit node, for post-dominators
+    blocks map[*BasicBlock]bool
+    predecessors map[*BasicBlock][]*BasicBlock
+    postdom *DominatorTree
+    loops map[*BasicBlock]*Loop                 // Natural loops, by header
+    emitted map[*BasicBlock]bool
+    gotos map[*BasicBlock]bool
+    lines []decompiledLine
+
+    // The function in SSA form, with calls to internal functions returning to their callers
+    ssa map[*BasicBlock]*SSABlock
+    operands map[int][]*SSAValue                // Operands of each instruction, top of stack first
+    values map[int]*SSAValue                    // Value produced by each instruction
+    results map[int][]*SSAValue                 // Values returned by each internal call, by the PC of its JUMP
+    names map[*SSAValue]string                  // Phis, arguments and call results
+    live map[*SSAValue]bool
+}
+
+func (self *decompiler) newFunction(entry *BasicBlock, internal *InternalFunction) *functionDecompiler {
+    fn := &functionDecompiler{
+        decompiler: self,
+        entry: entry,
+        internal: internal,
+        exit: &BasicBlock{ID: -1},
+        predecessors: make(map[*BasicBlock][]*BasicBlock),
+        loops: make(map[*BasicBlock]*Loop),
+        emitted: make(map[*BasicBlock]bool),
+        gotos: make(map[*BasicBlock]bool),
+    }
+
+    fn.blocks = reachableFrom(entry, fn.succs)
+    for _, block := range self.prog.Blocks {
+        if fn.blocks[block] {
+            for _, succ := range fn.succs(block) {
+                fn.predecessors[succ] = append(fn.predecessors[succ], block)
+            }
+        }
+    }
+    fn.postdom = newDominatorTree(fn.exit, fn.postSuccs, fn.postPreds)
+    for _, loop := range findLoops(entry, fn.succs, fn.preds) {
+        if !loop.Irreducible {
+            fn.loops[loop.Header] = loop
+        }
+    }
+    fn.lower()
+    return fn
+}
+
+// lower converts the function to SSA form as LowerSSA does, but following its own edges,
+// so values on the stack across a call to an internal function stay those of the caller.
+// Phis become variables assigned on the edges into the blocks that define them.
+func (self *functionDecompiler) lower() {
+    self.ssa = make(map[*BasicBlock]*SSABlock)
+    self.operands = make(map[int][]*SSAValue)
+    self.values = make(map[int]*SSAValue)
+    self.results = make(map[int][]*SSAValue)
+    self.names = make(map[*SSAValue]string)
+    self.live = make(map[*SSAValue]bool)
+    undef := func() *SSAValue { return &SSAValue{PC: -1, Undef: true} }
+
+    // A block before the entry holds the stack the function is called with
+    initial := make([]*SSAValue, decompileEntrySlots)
+    for k := range initial {
+        initial[k] = undef()
+    }
+    if self.internal != nil {
+        for k := 0; k < self.internal.Args && k < len(initial); k++ {
+            initial[k] = &SSAValue{PC: -1}
+            // The first argument is pushed first, so is deepest
+            self.names[initial[k]] = fmt.Sprintf("arg%d", self.internal.Args - 1 - k)
+        }
+    }
+    pre := &SSABlock{ID: -1, Exit: initial}
+    blocks := []*SSABlock{pre}
+    for i, block := range reversePostorderBy(self.entry, self.succs) {
+        self.ssa[block] = &SSABlock{ID: i, Block: block}
+        blocks = append(blocks, self.ssa[block])
+    }
+    self.ssa[self.entry].Preds = []*SSABlock{pre}
+    for _, sb := range blocks[1:] {
+        for _, succ := range self.succs(sb.Block) {
+            sb.Succs = append(sb.Succs, self.ssa[succ])
+            self.ssa[succ].Preds = append(self.ssa[succ].Preds, sb)
+        }
+    }
+
+    for _, sb := range blocks[1:] {
+        height := -1
+        for _, pred := range sb.Preds {
+            if pred.ID < sb.ID && (height == -1 || len(pred.Exit) < height) {
+                height = len(pred.Exit)
+            }
+        }
+        if height == -1 {
+            height = 0
+        }
+        sb.Entry = make([]*SSAValue, height)
+        for k := range sb.Entry {
+            if len(sb.Preds) == 1 && sb.Preds[0].ID < sb.ID {
+                sb.Entry[k] = sb.Preds[0].Exit[k]
+            } else {
+                phi := &SSAValue{PC: -1, Phi: true, Block: sb}
+                sb.Phis = append(sb.Phis, phi)
+                sb.Entry[k] = phi
+            }
+        }
+        need, _ := self.prog.stackNeeds(sb.Block)
+        for len(sb.Entry) < need {
+            sb.Entry = append(sb.Entry, undef())
+        }
+
+        self.prog.lowerBlock(sb)
+        if call, ok := self.calls[sb.Block.End]; ok {
+            self.lowerCall(sb, call)
+        }
+    }
+
+    for _, sb := range blocks[1:] {
+        for k, phi := range sb.Phis {
+            for _, pred := range sb.Preds {
+                if k < len(pred.Exit) {
+                    phi.Args = append(phi.Args, pred.Exit[k])
+                } else {
+                    phi.Args = append(phi.Args, undef())
+                }
+            }
+        }
+    }
+    (&SSAProgram{Blocks: blocks}).simplifyPhis()
+
+    var worklist []*SSAValue
+    use := func(v *SSAValue) {
+        if !self.live[v] {
+            self.live[v] = true
+            worklist = append(worklist, v)
+        }
+    }
+    for _, sb := range blocks[1:] {
+        for k, v := range sb.Entry {
+            if v.Phi && v.Block == sb && self.names[v] == "" {
+                self.names[v] = fmt.Sprintf("phi_%X_%d", sb.Block.Start, k)
+            }
+        }
+        for _, v := range sb.Values {
+            args := v.Args
+            if _, ok := self.calls[v.PC]; !ok && (v.Op == JUMP || v.Op == JUMPI) && len(args) > 0 {
+                // Jump targets are not rendered
+                args = args[1:]
+            }
+            for _, arg := range args {
+                use(arg)
+            }
+            self.operands[v.PC] = v.Args
+            if v.Op.StackWrites() == 1 {
+                self.values[v.PC] = v
+            }
+        }
+        if self.internal != nil && self.returns[sb.Block.End] {
+            for _, v := range self.returned(sb) {
+                use(v)
+            }
+        }
+    }
+    for len(worklist) > 0 {
+        v := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        if v.Phi {
+            for _, arg := range v.Args {
+                use(arg)
+            }
+        }
+    }
+}
+
+// lowerCall replaces the operands of the JUMP ending sb, a call, with the arguments of
+// the function called, and its exit stack with the one the call returns with.
+func (self *functionDecompiler) lowerCall(sb *SSABlock, call *CallSite) {
+    callee := self.internals[call.Callee]
+    value := func(k int) *SSAValue {
+        if k < len(sb.Exit) {
+            return sb.Exit[k]
+        }
+        return &SSAValue{PC: -1, Undef: true}
+    }
+
+    args := make([]*SSAValue, callee.Args)
+    for j := range args {
+        args[j] = value(callee.Args - 1 - j)
+    }
+    if jump := sb.Terminator(); jump != nil {
+        jump.Args = args
+    }
+
+    results := make([]*SSAValue, callee.Returns)
+    exit := make([]*SSAValue, 0, len(sb.Exit))
+    for j := range results {
+        results[j] = &SSAValue{PC: -1}
+        if len(results) == 1 {
+            self.names[results[j]] = fmt.Sprintf("ret_%X", call.Jump)
+        } else {
+            self.names[results[j]] = fmt.Sprintf("ret_%X_%d", call.Jump, j)
+        }
+    }
+    for j := len(results) - 1; j >= 0; j-- {
+        exit = append(exit, results[j])
+    }
+    if len(sb.Exit) > callee.Args + 1 {
+        exit = append(exit, sb.Exit[callee.Args + 1:]...)
+    }
+    self.results[call.Jump] = results
+    sb.Exit = exit
+}
+
+// returned returns the values an exit of the internal function returns, in order.
+func (self *functionDecompiler) returned(sb *SSABlock) []*SSAValue {
+    ret := make([]*SSAValue, self.internal.Returns)
+    for j := range ret {
+        if k := self.internal.Returns - 1 - j; k < len(sb.Exit) {
+            ret[j] = sb.Exit[k]
+        } else {
+            ret[j] = &SSAValue{PC: -1, Undef: true}
+        }
+    }
+    return ret
+}
+
+// used returns true if the value produced at pc is an operand in the function's SSA form.
+func (self *functionDecompiler) used(pc int) bool {
+    v, ok := self.values[pc]
+    return ok && self.live[v]
+}
+
+// renderSSA renders an SSA value, or returns false if it comes from outside the function.
+func (self *functionDecompiler) renderSSA(v *SSAValue) (string, bool) {
+    switch {
+    case v.Const != nil:
+        return fmt.Sprintf("0x%x", v.Const), true
+    case v.Symbol != "":
+        return v.Symbol, true
+    }
+    if name, ok := self.names[v]; ok {
+        return name, true
+    }
+    if v.PC < 0 {
+        return "", false
+    }
+    return self.renderValue(v.PC), true
+}
+
+func (self *functionDecompiler) renderValues(values []*SSAValue) string {
+    strs := make([]string, len(values))
+    for i, v := range values {
+        if text, ok := self.renderSSA(v); ok {
+            strs[i] = text
+        } else {
+            strs[i] = "?"
+        }
+    }
+    return strings.Join(strs, ", ")
+}
+
+// copies returns the assignments to the phis of to made on the edge from from.
+func (self *functionDecompiler) copies(from, to *BasicBlock) (ret []string) {
+    source, target := self.ssa[from], self.ssa[to]
+    if from == nil || source == nil || target == nil {
+        return nil
+    }
+    pred := -1
+    for j, p := range target.Preds {
+        if p == source {
+            pred = j
+            break
+        }
+    }
+    if pred == -1 {
+        return nil
+    }
+
+    type assignment struct {
+        phi, arg *SSAValue
+    }
+    var copies []assignment
+    assigned := make(map[*SSAValue]bool)
+    for _, phi := range target.Phis {
+        if arg := phi.Args[pred]; self.live[phi] && arg != phi {
+            copies = append(copies, assignment{phi, arg})
+            assigned[phi] = true
+        }
+    }
+    // The copies happen at once, so phis they read are saved before any is assigned
+    saved := make(map[*SSAValue]string)
+    for _, c := range copies {
+        if assigned[c.arg] && saved[c.arg] == "" {
+            saved[c.arg] = "old_" + self.names[c.arg]
+            ret = append(ret, fmt.Sprintf("%s = %s;", saved[c.arg], self.names[c.arg]))
+        }
+    }
+    for _, c := range copies {
+        value, ok := saved[c.arg], saved[c.arg] != ""
+        if !ok {
+            if value, ok = self.renderSSA(c.arg); !ok {
+                value = "?"
+            }
+        }
+        ret = append(ret, fmt.Sprintf("%s = %s;", self.names[c.phi], value))
+    }
+    return ret
+}
+
+func (self *functionDecompiler) emitCopies(from, to *BasicBlock, indent int) {
+    for _, line := range self.copies(from, to) {
+        self.emit(indent, "%s", line)
+    }
+}
+
+// Successors within the function; other functions' entry points are treated as calls,
+// and calls to internal functions continue at their return address.
+func (self *functionDecompiler) succs(block *BasicBlock) (ret []*BasicBlock) {
+    if block == self.exit || self.returns[block.End] {
+        return nil
+    }
+    if call, ok := self.calls[block.End]; ok {
+        if ret := self.prog.BlockAt(call.Return); ret != nil {
+            return []*BasicBlock{ret}
+        }
+        return nil
+    }
+    for _, succ := range block.Successors {
+        if _, ok := self.functions[succ.Start]; ok && succ != self.entry {
+            continue
+        }
+        ret = append(ret, succ)
+    }
+    return ret
+}
+
+func (self *functionDecompiler) preds(block *BasicBlock) (ret []*BasicBlock) {
+    if block == self.exit {
+        for b := range self.blocks {
+            if len(self.succs(b)) == 0 {
+                ret = append(ret, b)
+            }
+        }
+        sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+        return ret
+    }
+    if _, ok := self.functions[block.Start]; ok && block != self.entry {
+        return nil
+    }
+    return self.predecessors[block]
+}
+
+// Edges of the reversed graph, used to compute post-dominators.
+func (self *functionDecompiler) postSuccs(block *BasicBlock) []*BasicBlock {
+    return self.preds(block)
+}
+
+func (self *functionDecompiler) postPreds(block *BasicBlock) []*BasicBlock {
+    if block == self.exit {
+        return nil
+    }
+    succs := self.succs(block)
+    if len(succs) == 0 {
+        return []*BasicBlock{self.exit}
+    }
+    return succs
+}
+
+func (self *functionDecompiler) emit(indent int, format string, args ...interface{}) {
+    self.lines = append(self.lines, decompiledLine{indent, fmt.Sprintf(format, args...), nil})
+}
+
+func (self *functionDecompiler) emitStatements(block *BasicBlock, indent int) {
+    for _, line := range self.statements(block) {
+        self.emit(indent, "%s", line)
+    }
+}
+
+// size returns the number of blocks reachable from block within the function.
+func (self *functionDecompiler) size(block *BasicBlock) int {
+    seen := map[*BasicBlock]bool{block: true}
+    worklist := []*BasicBlock{block}
+    for len(worklist) > 0 {
+        b := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        for _, succ := range self.succs(b) {
+            if !seen[succ] {
+                seen[succ] = true
+                worklist = append(worklist, succ)
+            }
+        }
+    }
+    return len(seen)
+}
+
+// structure emits the code from block, entered from from, until it reaches stop.
+func (self *functionDecompiler) structure(block, from, stop *BasicBlock, loop *loopContext, indent int) {
+    for block != nil && block != stop {
+        block, from = self.emitBlock(block, from, stop, loop, indent)
+    }
+    if block != nil {
+        self.emitCopies(from, block, indent)
+    }
+}
+
+// emitBlock emits block, entered from from, and any structure it heads, returning the
+// block that follows and the block it is entered from, or nil if the copies into it
+// have already been made.
+func (self *functionDecompiler) emitBlock(block, from, stop *BasicBlock, loop *loopContext, indent int) (*BasicBlock, *BasicBlock) {
+    self.emitCopies(from, block, indent)
+    if loop != nil && block == loop.header {
+        self.emit(indent, "continue;")
+        return nil, nil
+    }
+    if loop != nil && block == loop.exit {
+        self.emit(indent, "break;")
+        return nil, nil
+    }
+    if fns, ok := self.functions[block.Start]; ok && block != self.entry {
+        self.emit(indent, "%s();", functionName(fns[0]))
+        return nil, nil
+    }
+    if self.emitted[block] {
+        if len(block.Successors) == 0 {
+            // Terminal blocks are repeated rather than jumped to
+            self.emitStatements(block, indent)
+            return nil, nil
+        }
+        self.gotos[block] = true
+        self.emit(indent, "goto label_%X;", block.Start)
+        return nil, nil
+    }
+
+    self.emitted[block] = true
+    self.lines = append(self.lines, decompiledLine{indent, fmt.Sprintf("label_%X:", block.Start), block})
+    if loop := self.loops[block]; loop != nil {
+        return self.emitLoop(loop, indent), nil
+    }
+    self.emitStatements(block, indent)
+    return self.emitFlow(block, stop, loop, indent)
+}
+
+// branches returns the blocks a JUMPI at the end of block goes to when its condition is
+// true and false; either may be nil if it cannot be determined.
+func (self *functionDecompiler) branches(block *BasicBlock) (taken, fallthru *BasicBlock) {
+    target := self.prog.OperandValue(block.End, 0)
+    for _, succ := range block.Successors {
+        if succ.Start == block.End + 1 {
+            fallthru = succ
+        } else if target != nil && target.IsInt64() && int64(succ.Start) == target.Int64() {
+            taken = succ
+        }
+    }
+    return taken, fallthru
+}
+
+// emitFlow emits the control flow at the end of block, returning the block that follows
+// and the block it is entered from, as emitBlock does.
+func (self *functionDecompiler) emitFlow(block, stop *BasicBlock, loop *loopContext, indent int) (*BasicBlock, *BasicBlock) {
+    last := self.prog.Instructions[block.End]
+    switch last.Op {
+    case JUMPI:
+        cond := self.operand(block.End, 1)
+        taken, fallthru := self.branches(block)
+        if taken == nil {
+            self.emit(indent, "if (%s) {", unparen(cond))
+            self.emit(indent + 1, "jump(%s);", self.operand(block.End, 0))
+            self.emit(indent, "}")
+            return fallthru, block
+        }
+        return self.emitIf(block, cond, taken, fallthru, loop, indent)
+    case JUMP:
+        if call, ok := self.calls[block.End]; ok {
+            self.emitCall(call, indent)
+            return self.prog.BlockAt(call.Return), block
+        }
+        if self.returns[block.End] {
+            if self.internal == nil || self.internal.Returns == 0 {
+                self.emit(indent, "return;")
+            } else if self.internal.Returns == 1 {
+                self.emit(indent, "return %s;", self.renderValues(self.returned(self.ssa[block])))
+            } else {
+                self.emit(indent, "return (%s);", self.renderValues(self.returned(self.ssa[block])))
+            }
+            return nil, nil
+        }
+        if len(block.Successors) == 1 {
+            return block.Successors[0], block
+        }
+        self.emit(indent, "jump(%s);", self.operand(block.End, 0))
+        return nil, nil
+    }
+    if len(block.Successors) == 1 {
+        return block.Successors[0], block
+    }
+    return nil, nil
+}
+
+// emitCall emits a call to an internal function, assigning the values it returns.
+func (self *functionDecompiler) emitCall(call *CallSite, indent int) {
+    text := fmt.Sprintf("internal_%X(%s)", call.Callee, self.renderValues(self.operands[call.Jump]))
+    switch results := self.results[call.Jump]; len(results) {
+    case 0:
+        self.emit(indent, "%s;", text)
+    case 1:
+        self.emit(indent, "%s = %s;", self.renderValues(results), text)
+    default:
+        self.emit(indent, "(%s) = %s;", self.renderValues(results), text)
+    }
+}
+
+// emitBranch emits an if statement with body as its body, entered from block, or the
+// copies on the edge into body if it is join.
+func (self *functionDecompiler) emitBranch(block, body, join *BasicBlock, loop *loopContext, indent int) {
+    if body == join {
+        self.emitCopies(block, join, indent)
+        return
+    }
+    self.structure(body, block, join, loop, indent)
+}
+
+func (self *functionDecompiler) emitIf(block *BasicBlock, cond string, taken, fallthru *BasicBlock, loop *loopContext, indent int) (*BasicBlock, *BasicBlock) {
+    join := self.postdom.Idom(block)
+    if join == self.exit {
+        join = nil
+    }
+
+    switch {
+    case fallthru == nil:
+        self.emit(indent, "if (%s) {", unparen(cond))
+        self.structure(taken, block, join, loop, indent + 1)
+        self.emit(indent, "}")
+    case join == nil:
+        // At least one branch never rejoins the other; nest the smaller one and carry on with the other
+        if self.size(taken) <= self.size(fallthru) {
+            self.emit(indent, "if (%s) {", unparen(cond))
+            self.structure(taken, block, nil, loop, indent + 1)
+            self.emit(indent, "}")
+            return fallthru, block
+        }
+        self.emit(indent, "if (%s) {", unparen(negate(cond)))
+        self.structure(fallthru, block, nil, loop, indent + 1)
+        self.emit(indent, "}")
+        return taken, block
+    case taken == join || fallthru == join:
+        body, other := fallthru, taken
+        if fallthru == join {
+            body, other, cond = taken, fallthru, negate(cond)
+        }
+        self.emit(indent, "if (%s) {", unparen(negate(cond)))
+        self.structure(body, block, join, loop, indent + 1)
+        if copies := self.copies(block, other); len(copies) > 0 {
+            self.emit(indent, "} else {")
+            self.emitBranch(block, other, join, loop, indent + 1)
+        }
+        self.emit(indent, "}")
+    default:
+        self.emit(indent, "if (%s) {", unparen(cond))
+        self.emitBranch(block, taken, join, loop, indent + 1)
+        self.emit(indent, "} else {")
+        self.emitBranch(block, fallthru, join, loop, indent + 1)
+        self.emit(indent, "}")
+    }
+    return join, nil
+}
+
+// emitLoop emits a natural loop, returning the block control leaves it for.
+func (self *functionDecompiler) emitLoop(loop *Loop, indent int) *BasicBlock {
+    header := loop.Header
+    var exit *BasicBlock
+    if len(loop.Exits) == 1 {
+        exit = loop.Exits[0]
+    }
+
+    last := self.prog.Instructions[header.End]
+    if last.Op == JUMPI {
+        taken, fallthru := self.branches(header)
+        if taken != nil && fallthru != nil && loop.Contains(taken) != loop.Contains(fallthru) {
+            // The header tests the loop condition
+            cond, next, out := self.operand(header.End, 1), taken, fallthru
+            if loop.Contains(fallthru) {
+                cond, next, out = negate(cond), fallthru, taken
+            }
+            inner := &loopContext{header, out}
+            lines := self.statements(header)
+            copies := self.copies(header, out)
+            if len(lines) == 0 && len(copies) == 0 {
+                self.emit(indent, "while (%s) {", unparen(cond))
+            } else {
+                self.emit(indent, "while (true) {")
+                for _, line := range lines {
+                    self.emit(indent + 1, "%s", line)
+                }
+                if len(copies) == 0 {
+                    self.emit(indent + 1, "if (%s) break;", unparen(negate(cond)))
+                } else {
+                    self.emit(indent + 1, "if (%s) {", unparen(negate(cond)))
+                    for _, line := range copies {
+                        self.emit(indent + 2, "%s", line)
+                    }
+                    self.emit(indent + 2, "break;")
+                    self.emit(indent + 1, "}")
+                }
+            }
+            self.structure(next, header, header, inner, indent + 1)
+            self.emit(indent, "}")
+            return out
+        }
+    }
+
+    inner := &loopContext{header, exit}
+    self.emit(indent, "while (true) {")
+    self.emitStatements(header, indent + 1)
+    next, from := self.emitFlow(header, header, inner, indent + 1)
+    self.structure(next, from, header, inner, indent + 1)
+    self.emit(indent, "}")
+    return exit
+}
+
+func (self *functionDecompiler) write(w *strings.Builder, indent int) {
+    for _, line := range self.lines {
+        if line.label != nil && !self.gotos[line.label] {
+            continue
+        }
+        fmt.Fprintf(w, "%s%s\n", strings.Repeat("    ", indent + line.indent), line.text)
+    }
+}
+
+// emitFunction structures and writes the body of fn.
+func (self *decompiler) emitFunction(w *strings.Builder, fn *functionDecompiler) {
+    self.current = fn
+    fn.structure(fn.entry, nil, nil, nil, 0)
+    fn.write(w, 2)
+    self.current = nil
+}
+
+// Decompile renders the program as Solidity-like pseudo-code, with one function for the
+// dispatcher, one for each external function entry point, named for the lowest selector
+// that reaches it, and one for each internal function it recovers.
+func (self *Program) Decompile() string {
+    d := newDecompiler(self)
+    var w strings.Builder
+
+    w.WriteString("// Decompiled by evmopt; this is pseudo-code and will not compile\n")
+    w.WriteString("contract Decompiled {\n")
+    if entry := self.BlockAt(0); entry != nil {
+        w.WriteString("    function __entry() {\n")
+        d.emitFunction(&w, d.newFunction(entry, nil))
+        w.WriteString("    }\n")
+    }
+    for _, ext := range self.ExternalFunctions() {
+        entry := self.BlockAt(ext.Entry)
+        if entry == nil || entry.Start != ext.Entry || d.functions[ext.Entry][0].Selector != ext.Selector {
+            continue
+        }
+        fmt.Fprintf(&w, "\n    function %s() { // 0x%X", functionName(ext), ext.Entry)
+        if shared := d.functions[ext.Entry][1:]; len(shared) > 0 {
+            selectors := make([]string, len(shared))
+            for i, fn := range shared {
+                selectors[i] = fmt.Sprintf("0x%08x", fn.Selector)
+            }
+            fmt.Fprintf(&w, ", also %s", strings.Join(selectors, ", "))
+        }
+        w.WriteString("\n")
+        d.emitFunction(&w, d.newFunction(entry, nil))
+        w.WriteString("    }\n")
+    }
+    for _, internal := range self.InternalFunctions() {
+        args := make([]string, internal.Args)
+        for i := range args {
+            args[i] = fmt.Sprintf("arg%d", i)
+        }
+        fmt.Fprintf(&w, "\n    function internal_%X(%s) { // %d arguments, %d return values\n", internal.Entry, strings.Join(args, ", "), internal.Args, internal.Returns)
+        d.emitFunction(&w, d.newFunction(self.BlockAt(internal.Entry), internal))
+        w.WriteString("    }\n")
+    }
+    w.WriteString("}\n")
+    return w.String()
+}
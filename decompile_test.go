@@ -0,0 +1,97 @@
+package evmopt
+
+import (
+    "regexp"
+    "strings"
+    "testing"
+)
+
+// decompiledFunction returns the body of the function named name in the output of Decompile.
+func decompiledFunction(t *testing.T, source, name string) string {
+    start := strings.Index(source, "    function " + name + "(")
+    if start == -1 {
+        t.Fatalf("no function %v in\n%s", name, source)
+    }
+    end := strings.Index(source[start:], "\n    }\n")
+    return source[start:start + end]
+}
+
+var assignment = regexp.MustCompile(`(?m)^\s*(\w+) = (.*);$`)
+
+// TestDecompileTransfer checks that the arguments of the two calls transfer makes to the
+// balance slot function stay apart: the sender's balance is read with caller(), the
+// recipient's with the address from calldata, which is also the one logged.
+func TestDecompileTransfer(t *testing.T) {
+    body := decompiledFunction(t, NewProgram(loadContract(t, "token")).Decompile(), "func_a9059cbb")
+
+    assigned := make(map[string]string)
+    for _, match := range assignment.FindAllStringSubmatch(body, -1) {
+        if previous, ok := assigned[match[1]]; ok {
+            t.Errorf("%v is assigned both %v and %v", match[1], previous, match[2])
+        }
+        assigned[match[1]] = match[2]
+    }
+
+    calls := regexp.MustCompile(`internal_73\(([^)]*\)?)\)`).FindAllStringSubmatch(body, -1)
+    if len(calls) != 2 {
+        t.Fatalf("expected two calls to internal_73 in\n%s", body)
+    }
+    sender, recipient := calls[0][1], calls[1][1]
+    if sender != "caller()" {
+        t.Errorf("sender's balance is read with %v, not caller()", sender)
+    }
+    if assigned[recipient] != "calldata[0x4]" {
+        t.Errorf("recipient's balance is read with %v = %v, not the address from calldata", recipient, assigned[recipient])
+    }
+    if !strings.Contains(body, ", caller(), " + recipient + ");") {
+        t.Errorf("Transfer is not logged from caller() to %v in\n%s", recipient, body)
+    }
+}
+
+// TestDecompileSharedEntry checks that selectors dispatched to the same entry point share
+// one function, which the dispatcher calls for each of them.
+func TestDecompileSharedEntry(t *testing.T) {
+    source := NewProgram(loadContract(t, "binary_dispatch")).Decompile()
+    tests := []struct {
+        selector string
+        function string
+    }{
+        {"0x2e64cec1", "func_2e64cec1"},
+        {"0x6d4ce63c", "func_2e64cec1"},
+        {"0x6057361d", "func_6057361d"},
+        {"0xa6f9dae1", "func_6057361d"},
+    }
+    for _, test := range tests {
+        if !strings.Contains(source, "if (" + test.selector + " == var_1B) {\n                    " + test.function + "();") {
+            t.Errorf("%v does not call %v", test.selector, test.function)
+        }
+        if n := strings.Count(source, "function " + test.function + "("); n != 1 {
+            t.Errorf("%v is defined %d times", test.function, n)
+        }
+    }
+    for _, unused := range []string{"func_6d4ce63c", "func_a6f9dae1"} {
+        if strings.Contains(source, unused) {
+            t.Errorf("%v is named, but shares its entry with a lower selector", unused)
+        }
+    }
+}
+
+// TestDecompileLoop checks that a loop counter is assigned on the edges into the loop
+// header, from its initial value before the loop and its increment at the end of the body.
+func TestDecompileLoop(t *testing.T) {
+    body := decompiledFunction(t, NewProgram(loadContract(t, "loops")).Decompile(), "__entry")
+    want := []string{
+        "phi_5_0 = 0x0;",
+        "while (phi_5_0 < var_2) {",
+        "var_27 = (0x1 + phi_5_0);",
+        "phi_5_0 = var_27;",
+    }
+    last := -1
+    for _, line := range want {
+        i := strings.Index(body, line)
+        if i <= last {
+            t.Fatalf("expected %q after the lines before it in\n%s", line, body)
+        }
+        last = i
+    }
+}
@@ -0,0 +1,444 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "sort"
+    "strings"
+)
+
+type Severity int
+
+const (
+    Info Severity = iota
+    Low
+    Medium
+    High
+)
+
+func (self Severity) String() string {
+    switch self {
+    case Info:
+        return "info"
+    case Low:
+        return "low"
+    case Medium:
+        return "medium"
+    case High:
+        return "high"
+    }
+    return "unknown"
+}
+
+// Finding is a potential problem reported by a Detector.
+type Finding struct {
+    Detector string                  // Name of the detector that reported it
+    PC int                          // Instruction the finding is about
+    Severity Severity
+    Message string
+}
+
+func (self Finding) String() string {
+    return fmt.Sprintf("0x%X: %v: %s [%s]", self.PC, self.Severity, self.Message, self.Detector)
+}
+
+// Detector is a check run over an analysed program.
+type Detector interface {
+    Name() string                   // Short identifier, such as "unprotected-selfdestruct"
+    Description() string            // One line summary of what the detector looks for
+    Detect(prog *Program) []Finding
+}
+
+// Detectors are the built in detectors, run by Detect when given none.
+var Detectors = []Detector{
+    UnprotectedSelfdestruct{},
+    ControlledDelegatecall{},
+    OriginComparison{},
+    UncheckedCall{},
+    WriteAfterCall{},
+}
+
+// Detect runs detectors, or the built in Detectors if nil, over the reachable code,
+// returning their findings ordered by PC.
+func (self *Program) Detect(detectors []Detector) []Finding {
+    if detectors == nil {
+        detectors = Detectors
+    }
+    var findings []Finding
+    for _, detector := range detectors {
+        findings = append(findings, detector.Detect(self)...)
+    }
+    sort.SliceStable(findings, func(i, j int) bool { return findings[i].PC < findings[j].PC })
+    return findings
+}
+
+// reachableOps returns the PCs of reachable instructions with one of ops, in order.
+func (self *Program) reachableOps(ops ...OpCode) (ret []int) {
+    for _, pc := range self.PCs() {
+        block := self.BlockAt(pc)
+        if block == nil || !block.Reachable {
+            continue
+        }
+        for _, op := range ops {
+            if self.Instructions[pc].Op == op {
+                ret = append(ret, pc)
+            }
+        }
+    }
+    return ret
+}
+
+// Operations whose result is not controlled by their operands, such as loads of state
+// the operands only locate.
+func isOpaque(op OpCode) bool {
+    switch op {
+    case SLOAD, MLOAD, TLOAD, SHA3, BALANCE, EXTCODESIZE, EXTCODEHASH, BLOCKHASH,
+        CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2:
+        return true
+    }
+    return false
+}
+
+// computedFrom returns the PCs of the instructions operand i of the instruction at pc
+// is computed from, directly or through the operands of the instructions that supply it.
+// If opaque is false the search does not continue through opaque operations.
+func (self *Program) computedFrom(pc, i int, opaque bool) map[int]bool {
+    seen := make(map[int]bool)
+    var worklist []int
+    for source := range self.Instructions[pc].ReachedBy[i] {
+        seen[source] = true
+        worklist = append(worklist, source)
+    }
+    for len(worklist) > 0 {
+        source := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        inst := self.Instructions[source]
+        if !opaque && isOpaque(inst.Op) {
+            continue
+        }
+        for j := range inst.ReachedBy {
+            for next := range inst.ReachedBy[j] {
+                if !seen[next] {
+                    seen[next] = true
+                    worklist = append(worklist, next)
+                }
+            }
+        }
+    }
+    return seen
+}
+
+// derivedFrom returns the lowest PC of an instruction with one of ops that operand i of
+// the instruction at pc is computed from.
+func (self *Program) derivedFrom(pc, i int, opaque bool, ops ...OpCode) (int, bool) {
+    for _, source := range sortedPCs(self.computedFrom(pc, i, opaque)) {
+        for _, op := range ops {
+            if self.Instructions[source].Op == op {
+                return source, true
+            }
+        }
+    }
+    return 0, false
+}
+
+// dependsOnCaller returns true if operand i of the instruction at pc is computed from
+// CALLER, including through a hash or load of memory written with it earlier in the same
+// block, as when looking up a mapping keyed by the caller.
+func (self *Program) dependsOnCaller(pc, i int) bool {
+    for source := range self.computedFrom(pc, i, true) {
+        switch self.Instructions[source].Op {
+        case CALLER:
+            return true
+        case SHA3, MLOAD:
+            block := self.BlockAt(source)
+            for p := block.Start; p < source; p += self.Instructions[p].Op.OperandSize() + 1 {
+                if op := self.Instructions[p].Op; op == MSTORE || op == MSTORE8 {
+                    if _, ok := self.derivedFrom(p, 1, true, CALLER); ok {
+                        return true
+                    }
+                }
+            }
+        }
+    }
+    return false
+}
+
+// callerChecked returns true if the instruction at pc is only reached through a
+// conditional jump on a value computed from the caller: one successor of the jump must
+// dominate pc, and the other must not reach it without passing the jump again.
+func (self *Program) callerChecked(dom *DominatorTree, pc int) bool {
+    target := self.BlockAt(pc)
+    for block := dom.Idom(target); block != nil; block = dom.Idom(block) {
+        if self.Instructions[block.End].Op != JUMPI || len(block.Successors) != 2 || !self.dependsOnCaller(block.End, 1) {
+            continue
+        }
+        for i, guarded := range block.Successors {
+            if dom.Dominates(guarded, target) && !reachesAvoiding(block.Successors[1 - i], target, block) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// reachesAvoiding returns true if there is a path from from to to that does not pass
+// through avoid.
+func reachesAvoiding(from, to, avoid *BasicBlock) bool {
+    seen := map[*BasicBlock]bool{avoid: true}
+    worklist := []*BasicBlock{from}
+    for len(worklist) > 0 {
+        b := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        if b == to {
+            return true
+        }
+        if seen[b] {
+            continue
+        }
+        seen[b] = true
+        worklist = append(worklist, b.Successors...)
+    }
+    return false
+}
+
+// UnprotectedSelfdestruct reports SELFDESTRUCTs that any caller can reach.
+type UnprotectedSelfdestruct struct{}
+
+func (UnprotectedSelfdestruct) Name() string { return "unprotected-selfdestruct" }
+func (UnprotectedSelfdestruct) Description() string {
+    return "SELFDESTRUCT reachable without a check on the caller"
+}
+
+func (self UnprotectedSelfdestruct) Detect(prog *Program) (findings []Finding) {
+    dom := prog.Dominators()
+    for _, pc := range prog.reachableOps(SELFDESTRUCT) {
+        if !prog.callerChecked(dom, pc) {
+            findings = append(findings, Finding{self.Name(), pc, High, "SELFDESTRUCT can be reached without checking the caller"})
+        }
+    }
+    return findings
+}
+
+// ControlledDelegatecall reports DELEGATECALLs to an address taken from calldata.
+type ControlledDelegatecall struct{}
+
+func (ControlledDelegatecall) Name() string { return "controlled-delegatecall" }
+func (ControlledDelegatecall) Description() string {
+    return "DELEGATECALL to an address supplied in calldata"
+}
+
+func (self ControlledDelegatecall) Detect(prog *Program) (findings []Finding) {
+    dom := prog.Dominators()
+    for _, pc := range prog.reachableOps(DELEGATECALL) {
+        source, ok := prog.derivedFrom(pc, 1, false, CALLDATALOAD)
+        if !ok {
+            continue
+        }
+        if prog.callerChecked(dom, pc) {
+            findings = append(findings, Finding{self.Name(), pc, Medium, fmt.Sprintf("DELEGATECALL to an address read from calldata at 0x%X, behind a check on the caller", source)})
+        } else {
+            findings = append(findings, Finding{self.Name(), pc, High, fmt.Sprintf("DELEGATECALL to an address read from calldata at 0x%X", source)})
+        }
+    }
+    return findings
+}
+
+// OriginComparison reports comparisons with tx.origin, which is unsafe for authorisation.
+type OriginComparison struct{}
+
+func (OriginComparison) Name() string { return "tx-origin" }
+func (OriginComparison) Description() string {
+    return "Comparison involving tx.origin"
+}
+
+func (self OriginComparison) Detect(prog *Program) (findings []Finding) {
+    for _, pc := range prog.reachableOps(EQ, LT, GT, SLT, SGT) {
+        for i := 0; i < 2; i++ {
+            origin, ok := prog.derivedFrom(pc, i, false, ORIGIN)
+            if !ok {
+                continue
+            }
+            if _, ok := prog.derivedFrom(pc, 1 - i, false, CALLER); ok {
+                findings = append(findings, Finding{self.Name(), pc, Low, fmt.Sprintf("tx.origin at 0x%X compared with the caller, which rejects calls from contracts", origin)})
+            } else {
+                findings = append(findings, Finding{self.Name(), pc, Medium, fmt.Sprintf("tx.origin at 0x%X used in a comparison", origin)})
+            }
+            break
+        }
+    }
+    return findings
+}
+
+// UncheckedCall reports calls whose success flag is discarded.
+type UncheckedCall struct{}
+
+func (UncheckedCall) Name() string { return "unchecked-call" }
+func (UncheckedCall) Description() string {
+    return "Return value of an external call is not checked"
+}
+
+func (self UncheckedCall) Detect(prog *Program) (findings []Finding) {
+    for _, pc := range prog.reachableOps(CALL, CALLCODE, DELEGATECALL, STATICCALL) {
+        if !prog.resultUsed(pc) {
+            findings = append(findings, Finding{self.Name(), pc, Medium, fmt.Sprintf("return value of %v is not checked", prog.Instructions[pc].Op)})
+        }
+    }
+    return findings
+}
+
+// resultUsed returns true if the value produced at pc, or any value computed from it,
+// decides a conditional jump or is written to memory or storage.
+func (self *Program) resultUsed(pc int) bool {
+    seen := map[int]bool{pc: true}
+    worklist := []int{pc}
+    for len(worklist) > 0 {
+        source := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        for _, target := range sortedPCs(self.Instructions[source].Reaches) {
+            inst := self.Instructions[target]
+            switch inst.Op {
+            case JUMPI, MSTORE, MSTORE8, SSTORE, TSTORE:
+                if inst.ReachedBy[1][source] {
+                    return true
+                }
+            }
+            if inst.Op.StackWrites() > 0 && !seen[target] {
+                seen[target] = true
+                worklist = append(worklist, target)
+            }
+        }
+    }
+    return false
+}
+
+func sortedPCs(pcs map[int]bool) []int {
+    ret := make([]int, 0, len(pcs))
+    for pc := range pcs {
+        ret = append(ret, pc)
+    }
+    sort.Ints(ret)
+    return ret
+}
+
+// WriteAfterCall reports calls that forward enough gas to reenter the contract and are
+// followed by writes to storage, the pattern exploited by reentrancy.
+type WriteAfterCall struct{}
+
+func (WriteAfterCall) Name() string { return "write-after-call" }
+func (WriteAfterCall) Description() string {
+    return "Storage written after an external call that can reenter the contract"
+}
+
+// Gas below which a call cannot make a state change in the callee
+const callStipend = 2300
+
+func (self WriteAfterCall) Detect(prog *Program) (findings []Finding) {
+    for _, pc := range prog.reachableOps(CALL) {
+        if gas := prog.gasLimit(pc); gas != nil && gas.IsInt64() && gas.Int64() <= callStipend {
+            continue
+        }
+        writes := prog.writesAfter(pc)
+        if len(writes) > 0 {
+            findings = append(findings, Finding{self.Name(), pc, Medium, fmt.Sprintf("storage written at %s after external call", formatPCList(writes))})
+        }
+    }
+    return findings
+}
+
+// gasLimit returns an upper bound on the gas operand of the call at pc, or nil if there
+// is none. Besides constants it recognises a constant multiplied by a boolean, as in the
+// iszero(value) * 2300 Solidity computes for transfer and send.
+func (self *Program) gasLimit(pc int) *big.Int {
+    if gas := self.OperandValue(pc, 0); gas != nil {
+        return gas
+    }
+    source, ok := self.OperandSource(pc, 0)
+    if !ok || self.Instructions[source].Op != MUL {
+        return nil
+    }
+    for i := 0; i < 2; i++ {
+        factor := self.OperandValue(source, i)
+        if factor == nil {
+            continue
+        }
+        if other, ok := self.OperandSource(source, 1 - i); ok {
+            switch self.Instructions[other].Op {
+            case ISZERO, EQ, LT, GT, SLT, SGT:
+                return factor
+            }
+        }
+    }
+    return nil
+}
+
+// writesAfter returns the PCs of the SSTOREs that can execute after the instruction at pc.
+// A call to an internal function continues at its own return address, so a function
+// shared by several callers only leads back to them when pc is inside it.
+func (self *Program) writesAfter(pc int) []int {
+    calls := make(map[int]*CallSite)
+    exits := make(map[int]*InternalFunction)
+    for _, fn := range self.InternalFunctions() {
+        for _, call := range fn.CallSites {
+            calls[call.Jump] = call
+        }
+        for _, exit := range fn.Exits {
+            exits[exit] = fn
+        }
+    }
+
+    // Blocks are visited once inside a called function, where exits return to a caller
+    // already on the worklist, and once outside, where they return to every caller
+    type visit struct {
+        block *BasicBlock
+        called bool
+    }
+    var worklist []visit
+    follow := func(v visit) {
+        if call, ok := calls[v.block.End]; ok {
+            worklist = append(worklist, visit{self.BlockAt(call.Callee), true}, visit{self.BlockAt(call.Return), v.called})
+        } else if fn, ok := exits[v.block.End]; ok {
+            if !v.called {
+                for _, call := range fn.CallSites {
+                    worklist = append(worklist, visit{self.BlockAt(call.Return), false})
+                }
+            }
+        } else {
+            for _, succ := range v.block.Successors {
+                worklist = append(worklist, visit{succ, v.called})
+            }
+        }
+    }
+
+    writes := make(map[int]bool)
+    block := self.BlockAt(pc)
+    for p := pc; p <= block.End; p += self.Instructions[p].Op.OperandSize() + 1 {
+        if self.Instructions[p].Op == SSTORE {
+            writes[p] = true
+        }
+    }
+    follow(visit{block, false})
+    seen := make(map[visit]bool)
+    for len(worklist) > 0 {
+        v := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        if v.block == nil || seen[v] {
+            continue
+        }
+        seen[v] = true
+        for p := v.block.Start; p <= v.block.End; p += self.Instructions[p].Op.OperandSize() + 1 {
+            if self.Instructions[p].Op == SSTORE {
+                writes[p] = true
+            }
+        }
+        follow(v)
+    }
+    return sortedPCs(writes)
+}
+
+func formatPCList(pcs []int) string {
+    strs := make([]string, len(pcs))
+    for i, pc := range pcs {
+        strs[i] = fmt.Sprintf("0x%X", pc)
+    }
+    return strings.Join(strs, ", ")
+}
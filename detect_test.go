@@ -0,0 +1,41 @@
+package evmopt
+
+import (
+    "encoding/hex"
+    "testing"
+)
+
+func TestDetectors(t *testing.T) {
+    tests := []struct {
+        name string
+        detector Detector
+        code string
+        findings []int                  // PCs reported
+    }{
+        // selfdestruct(msg.sender) after if (msg.sender == owner) { ... }
+        {"selfdestruct after conditional write", UnprotectedSelfdestruct{}, "600054331415600d57600180555b33ff", []int{0xF}},
+        // require(msg.sender == owner); selfdestruct(msg.sender)
+        {"selfdestruct after require", UnprotectedSelfdestruct{}, "6000543314600c57600080fd5b33ff", nil},
+        // msg.sender.call{gas: iszero(msg.value) * 2300, value: msg.value}(""), as transfer compiles
+        {"call with stipend", WriteAfterCall{}, "6000600060006000343361" + "08fc341502f150600160005500", nil},
+        {"call with constant gas", WriteAfterCall{}, "600060006000600034336108fcf150600160005500", nil},
+        // as above, but with gas msg.value * 2300
+        {"call with unbounded gas", WriteAfterCall{}, "6000600060006000343361" + "08fc3402f150600160005500", []int{0xF}},
+        // A call followed by a function whose other caller goes on to write storage
+        {"call before shared function", WriteAfterCall{}, "600035601b57" + "60006000600060006000335af150" + "6019602856" + "5b00" + "5b6021602856" + "5b600160005500" + "5b56", nil},
+    }
+
+    for _, tt := range tests {
+        code, err := hex.DecodeString(tt.code)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var pcs []int
+        for _, finding := range tt.detector.Detect(NewProgram(code)) {
+            pcs = append(pcs, finding.PC)
+        }
+        if formatPCs(pcs) != formatPCs(tt.findings) {
+            t.Errorf("%v: got findings at %v, want %v", tt.name, formatPCs(pcs), formatPCs(tt.findings))
+        }
+    }
+}
@@ -0,0 +1,271 @@
+package evmopt
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "math/big"
+    "math/rand"
+)
+
+// DiffOptions controls differential testing of two versions of a contract.
+type DiffOptions struct {
+    Fork Fork
+    World *WorldState               // State both versions start from; nil for an empty state
+    Block *BlockContext
+    Caller Address                  // Zero selects a fixed default address
+    To Address                      // Address both versions are installed at; zero selects a default
+    Gas uint64                      // Gas per call; zero for 10 million
+    Runs int                        // Number of generated inputs; zero for 256
+    Seed int64                      // Seed for input generation
+    Calldata [][]byte               // Inputs to try before the generated ones
+}
+
+var (
+    defaultDiffCaller = BigToAddress(big.NewInt(0xca11e7))
+    defaultDiffTo = BigToAddress(big.NewInt(0xc0de))
+)
+
+// Divergence is an input on which two versions of a contract behave differently.
+type Divergence struct {
+    Calldata []byte                 // Minimised input reproducing the divergence
+    Reason string
+    Original *ExecutionResult
+    Optimized *ExecutionResult
+}
+
+func (self *Divergence) String() string {
+    return fmt.Sprintf("%s with calldata 0x%x: original %v 0x%x, optimized %v 0x%x", self.Reason, self.Calldata,
+        self.Original.Halt, self.Original.ReturnData, self.Optimized.Halt, self.Optimized.ReturnData)
+}
+
+type DiffReport struct {
+    Runs int                        // Inputs executed against both versions
+    Skipped int                     // Inputs that called a precompile the interpreter does not support (pairing or point evaluation)
+    Divergence *Divergence          // The first divergence found, or nil
+}
+
+// Diff runs original and optimized on the same inputs, starting from the same state, and
+// reports the first input on which their return data, success, logs or resulting storage
+// differ. Inputs are a mix of random bytes and calls to the selectors recovered from
+// original with generated arguments. Gas usage is not compared.
+func Diff(original, optimized []byte, opts *DiffOptions) *DiffReport {
+    if opts == nil {
+        opts = &DiffOptions{}
+    }
+    d := &differ{opts: *opts, original: original, optimized: optimized}
+    if d.opts.World == nil {
+        d.opts.World = NewWorldState()
+    }
+    if d.opts.Caller == (Address{}) {
+        d.opts.Caller = defaultDiffCaller
+    }
+    if d.opts.To == (Address{}) {
+        d.opts.To = defaultDiffTo
+    }
+    if d.opts.Gas == 0 {
+        d.opts.Gas = 10000000
+    }
+    if d.opts.Runs == 0 {
+        d.opts.Runs = 256
+    }
+
+    gen := &calldataGenerator{rand: rand.New(rand.NewSource(d.opts.Seed)), selectors: recoverSelectors(original, d.opts.Fork)}
+    report := &DiffReport{}
+    inputs := d.opts.Calldata
+    for i := 0; i < len(inputs) + d.opts.Runs; i++ {
+        var calldata []byte
+        if i < len(inputs) {
+            calldata = inputs[i]
+        } else {
+            calldata = gen.next()
+        }
+
+        reason, a, b := d.run(calldata)
+        if a.Halt == HaltUnsupported || b.Halt == HaltUnsupported {
+            report.Skipped += 1
+            continue
+        }
+        report.Runs += 1
+        if reason == "" {
+            continue
+        }
+
+        calldata = minimiseCalldata(calldata, func(data []byte) bool {
+            reason, _, _ := d.run(data)
+            return reason != ""
+        })
+        reason, a, b = d.run(calldata)
+        report.Divergence = &Divergence{calldata, reason, a, b}
+        break
+    }
+    return report
+}
+
+// TestingT is the part of testing.TB used by CheckEquivalent.
+type TestingT interface {
+    Helper()
+    Fatalf(format string, args ...interface{})
+}
+
+// CheckEquivalent fails the test if Diff finds a divergence between original and optimized.
+func CheckEquivalent(t TestingT, original, optimized []byte, opts *DiffOptions) {
+    t.Helper()
+    if report := Diff(original, optimized, opts); report.Divergence != nil {
+        t.Fatalf("optimized code diverges after %d runs: %v", report.Runs, report.Divergence)
+    }
+}
+
+// recoverSelectors returns the selectors of the contract's external functions.
+func recoverSelectors(code []byte, fork Fork) (selectors []uint32) {
+    prog, _ := NewProgramWithOptions(context.Background(), code, &Options{Fork: fork, MaxStates: 1000000})
+    for _, fn := range prog.ExternalFunctions() {
+        selectors = append(selectors, fn.Selector)
+    }
+    return selectors
+}
+
+type differ struct {
+    opts DiffOptions
+    original []byte
+    optimized []byte
+}
+
+func (self *differ) execute(code, calldata []byte) (*ExecutionResult, *WorldState) {
+    world := self.opts.World.Copy()
+    interp := NewInterpreter(self.opts.Fork, world, self.opts.Block)
+    result := interp.ExecuteCode(code, &Message{
+        Caller: self.opts.Caller,
+        To: self.opts.To,
+        Data: calldata,
+        Gas: self.opts.Gas,
+    })
+    return result, world
+}
+
+// run executes both versions on calldata, returning why they diverge, or "" if they agree.
+func (self *differ) run(calldata []byte) (reason string, a, b *ExecutionResult) {
+    a, worldA := self.execute(self.original, calldata)
+    b, worldB := self.execute(self.optimized, calldata)
+    switch {
+    case a.Halt.Success() != b.Halt.Success() || (a.Halt == HaltRevert) != (b.Halt == HaltRevert):
+        return "halt reason differs", a, b
+    case !bytes.Equal(a.ReturnData, b.ReturnData):
+        return "return data differs", a, b
+    case !equalLogs(a.Logs, b.Logs):
+        return "logs differ", a, b
+    case !equalStorage(worldA, worldB):
+        return "storage differs", a, b
+    }
+    return "", a, b
+}
+
+func equalLogs(a, b []Log) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i].Address != b[i].Address || !bytes.Equal(a[i].Data, b[i].Data) || len(a[i].Topics) != len(b[i].Topics) {
+            return false
+        }
+        for j := range a[i].Topics {
+            if a[i].Topics[j] != b[i].Topics[j] {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+func equalStorage(a, b *WorldState) bool {
+    storage := func(world *WorldState, addr Address) map[Word]Word {
+        if account, ok := world.Accounts[addr]; ok {
+            return account.Storage
+        }
+        return nil
+    }
+    for _, pair := range [][2]*WorldState{{a, b}, {b, a}} {
+        for addr, account := range pair[0].Accounts {
+            other := storage(pair[1], addr)
+            for k, v := range account.Storage {
+                if other[k] != v {
+                    return false
+                }
+            }
+        }
+    }
+    return true
+}
+
+// minimiseCalldata shrinks an input for which diverges returns true, first by truncating
+// it and then by zeroing bytes, for as long as it keeps diverging.
+func minimiseCalldata(calldata []byte, diverges func([]byte) bool) []byte {
+    for changed := true; changed; {
+        changed = false
+        for _, n := range []int{32, 1} {
+            for len(calldata) >= n && diverges(calldata[:len(calldata) - n]) {
+                calldata = calldata[:len(calldata) - n]
+                changed = true
+            }
+        }
+        for i := range calldata {
+            if calldata[i] == 0 {
+                continue
+            }
+            candidate := append([]byte{}, calldata...)
+            candidate[i] = 0
+            if diverges(candidate) {
+                calldata = candidate
+                changed = true
+            }
+        }
+    }
+    return calldata
+}
+
+type calldataGenerator struct {
+    rand *rand.Rand
+    selectors []uint32
+}
+
+// next returns either random bytes or, more often if any selectors are known, a call to
+// one of them with arguments chosen from values likely to exercise edge cases.
+func (self *calldataGenerator) next() []byte {
+    r := self.rand
+    if len(self.selectors) == 0 || r.Intn(4) == 0 {
+        data := make([]byte, r.Intn(100))
+        r.Read(data)
+        return data
+    }
+
+    selector := self.selectors[r.Intn(len(self.selectors))]
+    data := []byte{byte(selector >> 24), byte(selector >> 16), byte(selector >> 8), byte(selector)}
+    for i := r.Intn(5); i > 0; i-- {
+        data = append(data, self.word()...)
+    }
+    return data
+}
+
+func (self *calldataGenerator) word() []byte {
+    r := self.rand
+    word := make([]byte, 32)
+    switch r.Intn(7) {
+    case 0:
+    case 1:
+        word[31] = 1
+    case 2:
+        word[31] = byte(r.Intn(256))
+    case 3:
+        // Offsets of dynamic arguments
+        word[31] = byte(32 * r.Intn(4))
+    case 4:
+        r.Read(word[12:])
+    case 5:
+        for i := range word {
+            word[i] = 0xff
+        }
+    default:
+        r.Read(word)
+    }
+    return word
+}
@@ -0,0 +1,72 @@
+package evmopt
+
+import (
+    "encoding/hex"
+    "fmt"
+    "regexp"
+    "testing"
+)
+
+// fatalRecorder is a TestingT that records the failure instead of stopping the test.
+type fatalRecorder struct {
+    failure string
+}
+
+func (self *fatalRecorder) Helper() {}
+func (self *fatalRecorder) Fatalf(format string, args ...interface{}) {
+    self.failure = fmt.Sprintf(format, args...)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+    data, err := hex.DecodeString(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return data
+}
+
+func TestDiff(t *testing.T) {
+    tests := []struct {
+        name string
+        original string
+        optimized string
+        calldata string             // Pattern the hex of the minimised input matches, or "" if the versions agree
+        reason string
+    }{
+        // return calldataload(0)
+        {"identical", "60003560005260206000f3", "60003560005260206000f3", "", ""},
+        // if (selector == 0x12345678) sstore(0, 1), rewritten to store 2
+        {"selector", "60003560e01c63123456781460105700" + "5b6001600055" + "00", "60003560e01c63123456781460105700" + "5b6002600055" + "00", "^12345678$", "storage differs"},
+        // return calldataload(0), rewritten to return only its low byte; one byte of calldata
+        // lands in the masked part of the word
+        {"masked", "60003560005260206000f3", "60003560ff1660005260206000f3", "^(0[1-9a-f]|[1-9a-f].)$", "return data differs"},
+    }
+
+    for _, tt := range tests {
+        original, optimized := mustDecodeHex(t, tt.original), mustDecodeHex(t, tt.optimized)
+        report := Diff(original, optimized, &DiffOptions{Runs: 64})
+        if report.Runs != 64 && tt.calldata == "" {
+            t.Errorf("%v: got %v runs, want 64", tt.name, report.Runs)
+        }
+        recorder := &fatalRecorder{}
+        CheckEquivalent(recorder, original, optimized, &DiffOptions{Runs: 64})
+
+        if tt.calldata == "" {
+            if report.Divergence != nil || recorder.failure != "" {
+                t.Errorf("%v: got divergence %v, want none", tt.name, report.Divergence)
+            }
+            continue
+        }
+        d := report.Divergence
+        if d == nil || recorder.failure == "" {
+            t.Errorf("%v: no divergence found", tt.name)
+            continue
+        }
+        if d.Reason != tt.reason {
+            t.Errorf("%v: got reason %q, want %q", tt.name, d.Reason, tt.reason)
+        }
+        if !regexp.MustCompile(tt.calldata).MatchString(hex.EncodeToString(d.Calldata)) {
+            t.Errorf("%v: got calldata 0x%x, want a match for %v", tt.name, d.Calldata, tt.calldata)
+        }
+    }
+}
@@ -0,0 +1,291 @@
+package evmopt
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math/big"
+    "os"
+    "sort"
+    "strings"
+)
+
+// Event is a LOG instruction and what is known of the event it emits.
+type Event struct {
+    PC int
+    Topic *big.Int                  // Constant first topic, the hash of the event's signature, if known
+    Topics int                      // Number of topics logged
+    DataSize int                    // Size of the logged data in bytes, or -1 if not constant
+    Functions []uint32              // Selectors of the external functions that can emit it
+}
+
+// Anonymous returns true if the event has no signature topic, as LOG0 does.
+func (self *Event) Anonymous() bool {
+    return self.Topics == 0
+}
+
+// Indexed returns the number of the event's arguments passed as topics.
+func (self *Event) Indexed() int {
+    if self.Anonymous() {
+        return 0
+    }
+    return self.Topics - 1
+}
+
+// NonIndexed returns the number of the event's arguments in its data, counting a word for
+// each, or -1 if the size of the data is not known.
+func (self *Event) NonIndexed() int {
+    if self.DataSize < 0 {
+        return -1
+    }
+    return (self.DataSize + 31) / 32
+}
+
+func (self *Event) String() string {
+    return self.Format(nil)
+}
+
+// Format describes the event, naming it from signatures if its topic is there.
+func (self *Event) Format(signatures EventSignatures) string {
+    var w strings.Builder
+    fmt.Fprintf(&w, "0x%X: LOG%d", self.PC, self.Topics)
+    if self.Topic != nil {
+        if name, ok := signatures.Name(self.Topic); ok {
+            fmt.Fprintf(&w, " %s", name)
+        } else {
+            fmt.Fprintf(&w, " 0x%064x", self.Topic)
+        }
+    }
+    fmt.Fprintf(&w, " indexed=%d", self.Indexed())
+    if data := self.NonIndexed(); data >= 0 {
+        fmt.Fprintf(&w, " data=%d", data)
+    } else {
+        w.WriteString(" data=?")
+    }
+    if len(self.Functions) > 0 {
+        selectors := make([]string, len(self.Functions))
+        for i, selector := range self.Functions {
+            selectors[i] = fmt.Sprintf("0x%08x", selector)
+        }
+        fmt.Fprintf(&w, " in %s", strings.Join(selectors, ","))
+    }
+    return w.String()
+}
+
+// Events returns the reachable LOG instructions in order, with the constant first topic
+// of each, the number of arguments it passes as topics and in its data, and the external
+// functions that can reach it, following internal calls by their return addresses.
+func (self *Program) Events() []*Event {
+    events := self.logEvents()
+    if len(events) == 0 {
+        return events
+    }
+    byPC := make(map[int]*Event)
+    for _, event := range events {
+        byPC[event.PC] = event
+    }
+    for _, fn := range self.ExternalFunctions() {
+        blocks, _ := self.functionBlocks(fn)
+        for block := range blocks {
+            for pc := block.Start; pc <= block.End; pc += self.Instructions[pc].Op.OperandSize() + 1 {
+                if event, ok := byPC[pc]; ok {
+                    event.Functions = append(event.Functions, fn.Selector)
+                }
+            }
+        }
+    }
+    for _, event := range events {
+        sort.Slice(event.Functions, func(i, j int) bool { return event.Functions[i] < event.Functions[j] })
+    }
+    return events
+}
+
+// logEvents returns the events of the reachable LOG instructions, without the functions
+// that emit them.
+func (self *Program) logEvents() []*Event {
+    var ret []*Event
+    for _, pc := range self.reachableOps(LOG0, LOG1, LOG2, LOG3, LOG4) {
+        event := &Event{PC: pc, Topics: int(self.Instructions[pc].Op - LOG0), DataSize: -1}
+        // Topics follow the offset and size of the data
+        if !event.Anonymous() {
+            event.Topic = self.OperandValue(pc, 2)
+        }
+        if size := self.OperandValue(pc, 1); size != nil && size.IsInt64() && size.Int64() <= maxMemoryExtent {
+            event.DataSize = int(size.Int64())
+        }
+        ret = append(ret, event)
+    }
+    return ret
+}
+
+// EventSignatures names events by their topic, as hex.
+type EventSignatures map[string]string
+
+// Name returns the signature of the event with topic, if known.
+func (self EventSignatures) Name(topic *big.Int) (string, bool) {
+    name, ok := self[fmt.Sprintf("0x%064x", topic)]
+    return name, ok
+}
+
+// Add records signature under its topic.
+func (self EventSignatures) Add(signature string) {
+    self[fmt.Sprintf("0x%064x", Topic(signature))] = signature
+}
+
+// StandardEvents returns the signatures of the events of the built in Interfaces.
+func StandardEvents() EventSignatures {
+    ret := make(EventSignatures)
+    for _, iface := range Interfaces {
+        for _, event := range iface.Events {
+            ret.Add(event.Signature)
+        }
+    }
+    return ret
+}
+
+// ParseEventSignatures reads event signatures, one a line, into signatures. A line may
+// give the topic before the signature, as in "0x<topic> Name(types)", for signatures
+// from a database; otherwise the topic is computed. Signatures may be written as they
+// are declared, as in "Transfer(address indexed from, address indexed to, uint256)";
+// parameter names and indexed are dropped. Blank lines and lines starting with # are
+// ignored.
+func ParseEventSignatures(r io.Reader, signatures EventSignatures) error {
+    scanner := bufio.NewScanner(r)
+    line := 0
+    for scanner.Scan() {
+        line += 1
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || strings.HasPrefix(text, "#") {
+            continue
+        }
+        var topic string
+        if fields := strings.Fields(text); len(fields[0]) == 66 && strings.HasPrefix(fields[0], "0x") {
+            topic = strings.ToLower(fields[0])
+            text = strings.TrimSpace(text[len(fields[0]):])
+        }
+        signature, err := normaliseSignature(text)
+        if err != nil {
+            return fmt.Errorf("line %d: %v", line, err)
+        }
+        if topic != "" {
+            signatures[topic] = signature
+        } else {
+            signatures.Add(signature)
+        }
+    }
+    return scanner.Err()
+}
+
+// normaliseSignature returns the canonical form of an event signature, with only the
+// name and the parameter types.
+func normaliseSignature(text string) (string, error) {
+    open := strings.Index(text, "(")
+    if open <= 0 || !strings.HasSuffix(text, ")") || strings.ContainsAny(text[:open], " \t") {
+        return "", fmt.Errorf("expected a signature, optionally after its topic")
+    }
+    name := text[:open]
+    types, err := normaliseParams(text[open + 1:len(text) - 1])
+    if err != nil {
+        return "", err
+    }
+    return name + "(" + types + ")", nil
+}
+
+// normaliseParams returns the types of a comma separated parameter list, dropping
+// names and the indexed keyword.
+func normaliseParams(params string) (string, error) {
+    if strings.TrimSpace(params) == "" {
+        return "", nil
+    }
+    var types []string
+    for _, param := range splitParams(params) {
+        param = strings.TrimSpace(param)
+        var typ string
+        if strings.HasPrefix(param, "(") {
+            // A tuple, followed by any array dimensions
+            end := matchingParen(param)
+            if end < 0 {
+                return "", fmt.Errorf("unbalanced parentheses in %q", param)
+            }
+            inner, err := normaliseParams(param[1:end])
+            if err != nil {
+                return "", err
+            }
+            rest := strings.Fields(param[end + 1:] + " ")
+            typ = "(" + inner + ")"
+            if len(rest) > 0 && strings.HasPrefix(rest[0], "[") {
+                typ += rest[0]
+            }
+        } else if fields := strings.Fields(param); len(fields) > 0 {
+            typ = fields[0]
+        } else {
+            return "", fmt.Errorf("empty parameter")
+        }
+        types = append(types, canonicalType(typ))
+    }
+    return strings.Join(types, ","), nil
+}
+
+// splitParams splits a parameter list at the commas outside any tuple.
+func splitParams(params string) []string {
+    var ret []string
+    depth, start := 0, 0
+    for i, c := range params {
+        switch c {
+        case '(':
+            depth += 1
+        case ')':
+            depth -= 1
+        case ',':
+            if depth == 0 {
+                ret = append(ret, params[start:i])
+                start = i + 1
+            }
+        }
+    }
+    return append(ret, params[start:])
+}
+
+// matchingParen returns the index of the parenthesis closing the one s starts with, or
+// -1 if there is none.
+func matchingParen(s string) int {
+    depth := 0
+    for i, c := range s {
+        switch c {
+        case '(':
+            depth += 1
+        case ')':
+            depth -= 1
+            if depth == 0 {
+                return i
+            }
+        }
+    }
+    return -1
+}
+
+// canonicalType expands the uint and int aliases, which are hashed as uint256 and int256.
+func canonicalType(typ string) string {
+    base := typ
+    if i := strings.Index(typ, "["); i >= 0 {
+        base = typ[:i]
+    }
+    if base == "uint" || base == "int" {
+        return base + "256" + typ[len(base):]
+    }
+    return typ
+}
+
+// LoadEventSignatures reads a file of event signatures in the format ParseEventSignatures
+// accepts into signatures.
+func LoadEventSignatures(path string, signatures EventSignatures) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    if err := ParseEventSignatures(f, signatures); err != nil {
+        return fmt.Errorf("%v: %v", path, err)
+    }
+    return nil
+}
@@ -0,0 +1,104 @@
+package evmopt
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestParseEventSignatures(t *testing.T) {
+    transfer := fmt.Sprintf("0x%064x", Topic("Transfer(address,address,uint256)"))
+    tests := []struct {
+        input string
+        topic string                    // Topic the signature is recorded under
+        want string                     // Recorded signature, or the error
+    }{
+        {"Transfer(address,address,uint256)", transfer, "Transfer(address,address,uint256)"},
+        {"Transfer(address indexed from, address indexed to, uint256 value)", transfer, "Transfer(address,address,uint256)"},
+        {"  Transfer( address indexed,address indexed , uint value )  ", transfer, "Transfer(address,address,uint256)"},
+        {"Ping()", fmt.Sprintf("0x%064x", Topic("Ping()")), "Ping()"},
+        {"Batch((uint a, address[] b)[2] indexed items, int[] values)", fmt.Sprintf("0x%064x", Topic("Batch((uint256,address[])[2],int256[])")), "Batch((uint256,address[])[2],int256[])"},
+        {"0x" + strings.ToUpper(transfer[2:]) + " Transfer(address indexed from, address indexed to, uint256)", transfer, "Transfer(address,address,uint256)"},
+        {"# Transfer(address,address,uint256)", "", ""},
+        {"Transfer", "", "line 1: expected a signature, optionally after its topic"},
+        {"Big Transfer(address)", "", "line 1: expected a signature, optionally after its topic"},
+        {"Transfer(address,,uint256)", "", "line 1: empty parameter"},
+        {"Batch((uint256,address)", "", "line 1: unbalanced parentheses in \"(uint256,address\""},
+    }
+
+    for _, tt := range tests {
+        signatures := make(EventSignatures)
+        err := ParseEventSignatures(strings.NewReader(tt.input), signatures)
+        switch {
+        case tt.topic == "" && tt.want != "":
+            if err == nil || err.Error() != tt.want {
+                t.Errorf("%q: got error %v, want %v", tt.input, err, tt.want)
+            }
+        case err != nil:
+            t.Errorf("%q: %v", tt.input, err)
+        case tt.topic == "" && len(signatures) != 0:
+            t.Errorf("%q: got %v, want nothing", tt.input, signatures)
+        case tt.topic != "" && (len(signatures) != 1 || signatures[tt.topic] != tt.want):
+            t.Errorf("%q: got %v, want %v under %v", tt.input, signatures, tt.want, tt.topic)
+        }
+    }
+}
+
+func TestLoadEventSignatures(t *testing.T) {
+    dir, err := ioutil.TempDir("", "events")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    path := filepath.Join(dir, "events.txt")
+    if err := ioutil.WriteFile(path, []byte("# ERC-20\nTransfer(address indexed from, address indexed to, uint256 value)\n\nDeposit(address,uint256)\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    signatures := StandardEvents()
+    standard := len(signatures)
+    if err := LoadEventSignatures(path, signatures); err != nil {
+        t.Fatal(err)
+    }
+    if name, ok := signatures.Name(Topic("Deposit(address,uint256)")); !ok || name != "Deposit(address,uint256)" || len(signatures) != standard + 1 {
+        t.Errorf("got %v signatures naming Deposit %q, want %v", len(signatures), name, standard + 1)
+    }
+
+    if err := ioutil.WriteFile(path, []byte("Deposit(address,uint256)\nDeposit\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := LoadEventSignatures(path, signatures); err == nil || !strings.HasPrefix(err.Error(), path + ": line 2:") {
+        t.Errorf("got error %v, want one for line 2 of %v", err, path)
+    }
+    if err := LoadEventSignatures(filepath.Join(dir, "missing.txt"), signatures); !os.IsNotExist(err) {
+        t.Errorf("got error %v for a missing file", err)
+    }
+}
+
+func TestEvents(t *testing.T) {
+    tests := []struct {
+        contract string
+        events []string
+    }{
+        {"erc721_partial", []string{
+            "0xF7: LOG4 Transfer(address,address,uint256) indexed=3 data=0 in 0x23b872dd",
+            "0x127: LOG3 ApprovalForAll(address,address,bool) indexed=2 data=1 in 0xa22cb465",
+        }},
+        {"token", []string{"0x10B: LOG3 Transfer(address,address,uint256) indexed=2 data=1 in 0xa9059cbb"}},
+        {"minimal_proxy", nil},
+    }
+
+    signatures := StandardEvents()
+    for _, tt := range tests {
+        var got []string
+        for _, event := range NewProgram(loadContract(t, tt.contract)).Events() {
+            got = append(got, event.Format(signatures))
+        }
+        if strings.Join(got, "\n") != strings.Join(tt.events, "\n") {
+            t.Errorf("%v: got events\n%v\nwant\n%v", tt.contract, strings.Join(got, "\n"), strings.Join(tt.events, "\n"))
+        }
+    }
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/arachnid/evmopt"
+)
+
+type batchRecord struct {
+    Address string `json:"address"`
+    Bytecode string `json:"bytecode"`
+}
+
+type batchInput struct {
+    address string
+    code *evmopt.Bytecode
+    err error
+}
+
+type batchResult struct {
+    Address string `json:"address"`
+    Hash string `json:"hash,omitempty"`             // SHA-256 of the bytecode, and of its link and immutable references if it has any
+    Size int `json:"size"`
+    Status string `json:"status"`
+    Error string `json:"error,omitempty"`
+    Duplicate bool `json:"duplicate,omitempty"`     // Analysis is in the earlier result with the same hash
+    Analysis *jsonOutput `json:"analysis,omitempty"`  // As output by -format json
+}
+
+// analysis holds the outcome of analysing one distinct bytecode, shared by all
+// contracts with the same hash. Only the first result carries the analysis itself.
+type analysis struct {
+    done chan struct{}
+    result batchResult
+}
+
+type batchJob struct {
+    input batchInput
+    hash string
+    analysis *analysis
+    duplicate bool                  // The analysis is being done for an earlier job
+}
+
+// decodeBytecode decodes hex code, which may hold unlinked library placeholders.
+func decodeBytecode(data []byte) (*evmopt.Bytecode, error) {
+    code, err := evmopt.ParseBytecode(string(data))
+    if code == nil && err == nil {
+        code = &evmopt.Bytecode{}
+    }
+    return code, err
+}
+
+// isHex returns true if data is hex, which may hold library placeholders.
+func isHex(data []byte) bool {
+    _, err := evmopt.ParseBytecode(string(data))
+    return err == nil
+}
+
+// readDirectory sends one input per regular file in dir. The address is the file name
+// without its extension; files that are entirely hex are decoded, others are taken as
+// raw bytecode.
+func readDirectory(dir string, inputs chan<- batchInput) error {
+    entries, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if !entry.Mode().IsRegular() {
+            continue
+        }
+        name := entry.Name()
+        input := batchInput{address: strings.TrimSuffix(name, filepath.Ext(name))}
+        data, err := ioutil.ReadFile(filepath.Join(dir, name))
+        if err != nil {
+            input.err = err
+        } else if isHex(data) {
+            input.code, input.err = decodeBytecode(data)
+        } else {
+            input.code = &evmopt.Bytecode{Code: data}
+        }
+        inputs <- input
+    }
+    return nil
+}
+
+// readJSONL sends one input per line of r, each a JSON object with address and bytecode fields.
+func readJSONL(r io.Reader, inputs chan<- batchInput) error {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 1024 * 1024), 64 * 1024 * 1024)
+    line := 0
+    for scanner.Scan() {
+        line += 1
+        if len(strings.TrimSpace(scanner.Text())) == 0 {
+            continue
+        }
+        var record batchRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            inputs <- batchInput{err: fmt.Errorf("line %d: %v", line, err)}
+            continue
+        }
+        input := batchInput{address: record.Address}
+        input.code, input.err = decodeBytecode([]byte(record.Bytecode))
+        if input.err != nil {
+            input.err = fmt.Errorf("line %d: %v", line, input.err)
+        }
+        inputs <- input
+    }
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("line %d: %v", line + 1, err)
+    }
+    return nil
+}
+
+func analyse(code *evmopt.Bytecode, opts *evmopt.Options) (result batchResult) {
+    defer func() {
+        if r := recover(); r != nil {
+            result.Status = "error"
+            result.Error = fmt.Sprintf("panic during analysis: %v", r)
+        }
+    }()
+
+    program, err := evmopt.NewProgramFromBytecode(context.Background(), code, opts)
+    result.Status = program.Status.String()
+    result.Analysis = buildJSON(program, len(code.Code))
+    if err != nil {
+        result.Error = err.Error()
+    }
+    return result
+}
+
+// bytecodeHash identifies code by its bytes and its library and immutable references,
+// which are analysed as symbols that differ between contracts with the same bytes.
+func bytecodeHash(code *evmopt.Bytecode) string {
+    h := sha256.New()
+    h.Write(code.Code)
+    for _, ref := range code.LinkReferences {
+        fmt.Fprintf(h, "\x00link %d %d %q %q %q", ref.Start, ref.Length, ref.Source, ref.Library, ref.Placeholder)
+    }
+    for _, ref := range code.ImmutableReferences {
+        fmt.Fprintf(h, "\x00immutable %d %d %d", ref.ID, ref.Start, ref.Length)
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// batchWorker analyses jobs until there are no more. A duplicate waits for the job it
+// duplicates, which an earlier worker has already taken, so its result follows that one.
+func batchWorker(jobs <-chan batchJob, results chan<- batchResult, opts *evmopt.Options, wg *sync.WaitGroup) {
+    defer wg.Done()
+    for job := range jobs {
+        if !job.duplicate {
+            job.analysis.result = analyse(job.input.code, opts)
+        }
+        results <- finishResult(job)
+        if !job.duplicate {
+            // Duplicates refer to the result just sent rather than keeping the analysis in memory
+            job.analysis.result.Analysis = nil
+            close(job.analysis.done)
+        }
+    }
+}
+
+func finishResult(job batchJob) batchResult {
+    if job.duplicate {
+        <-job.analysis.done
+    }
+    result := job.analysis.result
+    result.Address = job.input.address
+    result.Hash = job.hash
+    result.Size = len(job.input.code.Code)
+    result.Duplicate = job.duplicate
+    return result
+}
+
+// processBatch analyses the inputs read sends with a pool of the given number of
+// workers, sharing one analysis between identical bytecodes. Results are sent in the
+// order analyses finish. If read fails, the error is sent as a result of its own after
+// the inputs read so far.
+func processBatch(read func(chan<- batchInput) error, workers int, opts *evmopt.Options) <-chan batchResult {
+    inputs := make(chan batchInput)
+    go func() {
+        defer close(inputs)
+        if err := read(inputs); err != nil {
+            inputs <- batchInput{err: err}
+        }
+    }()
+
+    jobs := make(chan batchJob, workers)
+    results := make(chan batchResult, workers)
+    var workerGroup sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        workerGroup.Add(1)
+        go batchWorker(jobs, results, opts, &workerGroup)
+    }
+
+    go func() {
+        seen := make(map[string]*analysis)
+        for input := range inputs {
+            if input.err != nil {
+                results <- batchResult{Address: input.address, Status: "error", Error: input.err.Error()}
+                continue
+            }
+
+            hash := bytecodeHash(input.code)
+            a, duplicate := seen[hash]
+            if !duplicate {
+                a = &analysis{done: make(chan struct{})}
+                seen[hash] = a
+            }
+            jobs <- batchJob{input, hash, a, duplicate}
+        }
+        close(jobs)
+        workerGroup.Wait()
+        close(results)
+    }()
+    return results
+}
+
+func runBatch(args []string) {
+    flags := flag.NewFlagSet("batch", flag.ExitOnError)
+    workers := flags.Int("workers", runtime.NumCPU(), "number of contracts to analyse concurrently")
+    forkName := flags.String("fork", evmopt.LatestFork.String(), "fork whose semantics to analyse under")
+    maxStates := flags.Int("max-states", 0, "maximum analysis states per contract (0 for no limit)")
+    timeout := flags.Duration("timeout", 0, "maximum analysis time per contract (0 for no limit)")
+    contextDepth := flags.Int("context", 0, "number of enclosing calls to analyse separately by return address (0 merges all states at a PC)")
+    outPath := flags.String("o", "", "file to write results to (default stdout)")
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: evmdis batch [flags] <directory|file.jsonl>\n")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 1 || *workers < 1 {
+        flags.Usage()
+        os.Exit(2)
+    }
+
+    fork, err := evmopt.ParseFork(*forkName)
+    if err != nil {
+        log.Fatal(err)
+    }
+    opts := &evmopt.Options{Fork: fork, MaxStates: *maxStates, Timeout: *timeout, ContextDepth: *contextDepth}
+
+    out := os.Stdout
+    if *outPath != "" {
+        if out, err = os.Create(*outPath); err != nil {
+            log.Fatalf("Could not create output file: %v", err)
+        }
+        defer out.Close()
+    }
+
+    path := flags.Arg(0)
+    info, err := os.Stat(path)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    results := processBatch(func(inputs chan<- batchInput) error {
+        var err error
+        if info.IsDir() {
+            err = readDirectory(path, inputs)
+        } else {
+            var f *os.File
+            if f, err = os.Open(path); err == nil {
+                err = readJSONL(f, inputs)
+                f.Close()
+            }
+        }
+        if err != nil {
+            return fmt.Errorf("could not read %v: %v", path, err)
+        }
+        return nil
+    }, *workers, opts)
+
+    start := time.Now()
+    count := 0
+    w := bufio.NewWriter(out)
+    encoder := json.NewEncoder(w)
+    for result := range results {
+        if err := encoder.Encode(result); err != nil {
+            log.Fatalf("Could not write result: %v", err)
+        }
+        count += 1
+    }
+    if err := w.Flush(); err != nil {
+        log.Fatalf("Could not write results: %v", err)
+    }
+    log.Printf("Analysed %v contracts in %v", count, time.Since(start))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "reflect"
+    "runtime"
+    "sort"
+    "strings"
+    "testing"
+    "testing/iotest"
+
+    "github.com/arachnid/evmopt"
+)
+
+// collectBatch runs processBatch over the JSONL in r and returns the results of inputs
+// that could be read by address, and the errors of those that could not.
+func collectBatch(t *testing.T, r io.Reader) (map[string]batchResult, []string) {
+    results := make(map[string]batchResult)
+    var errs []string
+    for result := range processBatch(func(inputs chan<- batchInput) error {
+        return readJSONL(r, inputs)
+    }, 4, &evmopt.Options{}) {
+        if result.Address == "" {
+            errs = append(errs, result.Error)
+            continue
+        }
+        if _, ok := results[result.Address]; ok {
+            t.Fatalf("two results for %v", result.Address)
+        }
+        results[result.Address] = result
+    }
+    sort.Strings(errs)
+    return results, errs
+}
+
+func TestBatchDuplicates(t *testing.T) {
+    input := strings.Join([]string{
+        `{"address": "a", "bytecode": "0x6001600101"}`,
+        `{"address": "b", "bytecode": "0x6002600101"}`,
+        `{"address": "c", "bytecode": "6001600101"}`,
+    }, "\n")
+    results, errs := collectBatch(t, strings.NewReader(input))
+
+    if len(results) != 3 || len(errs) != 0 {
+        t.Fatalf("got %v results and errors %v, want 3 results", len(results), errs)
+    }
+    a, c := results["a"], results["c"]
+    if a.Hash != c.Hash || a.Hash == results["b"].Hash {
+        t.Errorf("hashes a=%v b=%v c=%v, want a and c equal to each other and not b", a.Hash, results["b"].Hash, c.Hash)
+    }
+    if a.Duplicate || results["b"].Duplicate || !c.Duplicate {
+        t.Errorf("duplicate a=%v b=%v c=%v, want only c", a.Duplicate, results["b"].Duplicate, c.Duplicate)
+    }
+    for _, result := range results {
+        if result.Status != "complete" || result.Size != 5 {
+            t.Errorf("%v: got status %v, size %v", result.Address, result.Status, result.Size)
+        }
+        if result.Duplicate != (result.Analysis == nil) {
+            t.Errorf("%v: duplicate %v with analysis %v, want the analysis only in the first result", result.Address, result.Duplicate, result.Analysis != nil)
+        }
+    }
+
+    // The first result carries the same analysis as the single contract JSON output
+    program, _ := evmopt.NewProgramFromBytecode(context.Background(), &evmopt.Bytecode{Code: []byte{0x60, 0x01, 0x60, 0x01, 0x01}}, &evmopt.Options{})
+    if !reflect.DeepEqual(a.Analysis, buildJSON(program, 5)) {
+        t.Errorf("a: got analysis %+v, want %+v", a.Analysis, buildJSON(program, 5))
+    }
+}
+
+// TestBatchLinkReferences checks that contracts whose code differs only in library
+// placeholders, which read as zero, are analysed separately.
+func TestBatchLinkReferences(t *testing.T) {
+    input := strings.Join([]string{
+        `{"address": "zero", "bytecode": "0x730000000000000000000000000000000000000000ff"}`,
+        `{"address": "math", "bytecode": "0x73__Math__________________________________ff"}`,
+        `{"address": "util", "bytecode": "0x73__Util__________________________________ff"}`,
+        `{"address": "util2", "bytecode": "0x73__Util__________________________________ff"}`,
+    }, "\n")
+    results, errs := collectBatch(t, strings.NewReader(input))
+    if len(results) != 4 || len(errs) != 0 {
+        t.Fatalf("got %v results and errors %v, want 4 results", len(results), errs)
+    }
+
+    hashes := make(map[string]bool)
+    for _, address := range []string{"zero", "math", "util"} {
+        if results[address].Duplicate {
+            t.Errorf("%v: shared an earlier result", address)
+        }
+        hashes[results[address].Hash] = true
+    }
+    if len(hashes) != 3 {
+        t.Errorf("got %v distinct hashes for three different contracts", len(hashes))
+    }
+    if !results["util2"].Duplicate || results["util2"].Hash != results["util"].Hash {
+        t.Errorf("util2: got duplicate %v, want it to share util's result", results["util2"].Duplicate)
+    }
+}
+
+// TestBatchWorkers checks that a large batch runs on the worker pool rather than a
+// goroutine for each contract waiting for its result to be read.
+func TestBatchWorkers(t *testing.T) {
+    const workers, contracts = 4, 1000
+    base := runtime.NumGoroutine()
+    results := processBatch(func(inputs chan<- batchInput) error {
+        for i := 0; i < contracts; i++ {
+            code, _ := decodeBytecode([]byte(fmt.Sprintf("61%04x00", i % 100)))
+            inputs <- batchInput{address: fmt.Sprint(i), code: code}
+        }
+        return nil
+    }, workers, &evmopt.Options{})
+
+    count, most := 0, 0
+    for range results {
+        if n := runtime.NumGoroutine() - base; n > most {
+            most = n
+        }
+        count += 1
+    }
+    if count != contracts {
+        t.Errorf("got %v results, want %v", count, contracts)
+    }
+    // The workers, the reader and the dispatcher
+    if most > workers + 2 {
+        t.Errorf("got %v goroutines, want at most %v", most, workers + 2)
+    }
+}
+
+func TestBatchErrors(t *testing.T) {
+    input := strings.Join([]string{
+        `{"address": "a", "bytecode": "0x00"}`,
+        `not json`,
+        `{"address": "b", "bytecode": "0xzz"}`,
+        `{"address": "c", "bytecode": "0x6000"}`,
+        ``,
+    }, "\n")
+    r := io.MultiReader(strings.NewReader(input), iotest.ErrReader(errors.New("connection reset")))
+    results, errs := collectBatch(t, r)
+
+    want := []string{
+        "line 2: invalid character 'o' in literal null (expecting 'u')",
+        "line 5: connection reset",
+    }
+    if strings.Join(errs, "\n") != strings.Join(want, "\n") {
+        t.Errorf("got errors\n%v\nwant\n%v", strings.Join(errs, "\n"), strings.Join(want, "\n"))
+    }
+    if b := results["b"]; b.Status != "error" || !strings.HasPrefix(b.Error, "line 3: ") {
+        t.Errorf("b: got status %v, error %q, want an error on line 3", b.Status, b.Error)
+    }
+    for _, address := range []string{"a", "c"} {
+        if results[address].Status != "complete" {
+            t.Errorf("%v: got status %v after the errors, want complete", address, results[address].Status)
+        }
+    }
+}
+
+func TestAnalysePanic(t *testing.T) {
+    result := analyse(nil, &evmopt.Options{})
+    if result.Status != "error" || !strings.HasPrefix(result.Error, "panic during analysis: ") {
+        t.Errorf("got status %v, error %q, want the panic reported as an error", result.Status, result.Error)
+    }
+}
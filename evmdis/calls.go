@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/arachnid/evmopt"
+)
+
+type jsonCall struct {
+    PC int `json:"pc"`
+    Opcode string `json:"opcode"`
+    Target jsonTarget `json:"target"`
+    Selector string `json:"selector,omitempty"`
+    Value string `json:"value,omitempty"`                  // Constant value as hex; omitted if not constant
+    Gas string `json:"gas,omitempty"`                      // Constant gas as decimal, or "all"
+    Functions []string `json:"functions"`
+}
+
+type jsonTarget struct {
+    Kind string `json:"kind"`
+    Address string `json:"address,omitempty"`
+    Library string `json:"library,omitempty"`
+    Slot string `json:"slot,omitempty"`
+}
+
+func selectorString(selector uint32) string {
+    return fmt.Sprintf("0x%08x", selector)
+}
+
+func buildCallsJSON(calls []*evmopt.ExternalCall) []jsonCall {
+    ret := make([]jsonCall, 0, len(calls))
+    for _, call := range calls {
+        record := jsonCall{
+            PC: call.PC,
+            Opcode: call.Op.String(),
+            Target: jsonTarget{Kind: call.Target.String(), Library: call.Symbol},
+            Functions: make([]string, len(call.Functions)),
+        }
+        if call.Address != nil {
+            record.Target.Address = call.Address.String()
+        }
+        if call.Slot != nil {
+            record.Target.Slot = fmt.Sprintf("0x%x", call.Slot)
+        }
+        if call.Selector != nil {
+            record.Selector = selectorString(*call.Selector)
+        }
+        if call.Value != nil {
+            record.Value = fmt.Sprintf("0x%x", call.Value)
+        }
+        if call.AllGas {
+            record.Gas = "all"
+        } else if call.Gas != nil {
+            record.Gas = call.Gas.String()
+        }
+        for i, selector := range call.Functions {
+            record.Functions[i] = selectorString(selector)
+        }
+        ret = append(ret, record)
+    }
+    return ret
+}
+
+func writeCallsJSON(w io.Writer, program *evmopt.Program) error {
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(struct {
+        Calls []jsonCall `json:"calls"`
+    }{buildCallsJSON(program.ExternalCalls())})
+}
+
+// writeCallsDOT writes the external calls as a Graphviz graph from the functions making
+// them, or the contract for calls outside any function, to their targets.
+func writeCallsDOT(w io.Writer, program *evmopt.Program) error {
+    var b strings.Builder
+    b.WriteString("digraph calls {\n")
+    b.WriteString("    node [shape=box];\n")
+    b.WriteString("    \"contract\";\n")
+    for _, fn := range program.ExternalFunctions() {
+        fmt.Fprintf(&b, "    %q -> %q [style=dotted];\n", "contract", selectorString(fn.Selector))
+    }
+
+    targets := make(map[string]bool)
+    for _, call := range program.ExternalCalls() {
+        target := call.TargetString()
+        if call.Target != evmopt.TargetConstant && call.Slot == nil {
+            // Targets other than addresses and storage slots are distinct for each call site
+            target = fmt.Sprintf("%s@0x%X", target, call.PC)
+        }
+        if !targets[target] {
+            targets[target] = true
+            fmt.Fprintf(&b, "    %q [shape=ellipse];\n", target)
+        }
+
+        label := call.Op.String()
+        if call.Selector != nil {
+            label += " " + selectorString(*call.Selector)
+        }
+        label += fmt.Sprintf(" @0x%X", call.PC)
+        sources := []string{"contract"}
+        if len(call.Functions) > 0 {
+            sources = sources[:0]
+            for _, selector := range call.Functions {
+                sources = append(sources, selectorString(selector))
+            }
+        }
+        for _, source := range sources {
+            fmt.Fprintf(&b, "    %q -> %q [label=%q];\n", source, target, label)
+        }
+    }
+    b.WriteString("}\n")
+    _, err := io.WriteString(w, b.String())
+    return err
+}
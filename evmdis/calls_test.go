@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "reflect"
+    "testing"
+
+    "github.com/arachnid/evmopt"
+)
+
+func TestCallsJSON(t *testing.T) {
+    program, _ := loadProgram(t, "external_calls")
+    var buf bytes.Buffer
+    if err := writeCallsJSON(&buf, program); err != nil {
+        t.Fatal(err)
+    }
+    var got struct {
+        Calls []jsonCall `json:"calls"`
+    }
+    if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+        t.Fatal(err)
+    }
+
+    want := []jsonCall{
+        {PC: 0x77, Opcode: "CALL", Target: jsonTarget{Kind: "constant", Address: "0x0000000000000000000000000000000000c0ffee"}, Selector: "0xa9059cbb", Value: "0x0", Gas: "50000", Functions: []string{"0x11111111"}},
+        {PC: 0xA8, Opcode: "STATICCALL", Target: jsonTarget{Kind: "storage", Slot: "0x3"}, Selector: "0x70a08231", Value: "0x0", Gas: "all", Functions: []string{"0x22222222"}},
+        {PC: 0xB2, Opcode: "CREATE2", Target: jsonTarget{Kind: "created"}, Functions: []string{"0x33333333"}},
+        {PC: 0xCD, Opcode: "CALL", Target: jsonTarget{Kind: "calldata"}, Gas: "all", Functions: []string{"0x44444444"}},
+    }
+    if len(got.Calls) != len(want) {
+        t.Fatalf("got %v calls, want %v:\n%s", len(got.Calls), len(want), buf.String())
+    }
+    for i := range want {
+        if !reflect.DeepEqual(got.Calls[i], want[i]) {
+            t.Errorf("call %d: got %+v, want %+v", i, got.Calls[i], want[i])
+        }
+    }
+}
+
+func TestCallsDOT(t *testing.T) {
+    program, _ := loadProgram(t, "external_calls")
+    var buf bytes.Buffer
+    if err := writeCallsDOT(&buf, program); err != nil {
+        t.Fatal(err)
+    }
+
+    want := `digraph calls {
+    node [shape=box];
+    "contract";
+    "contract" -> "0x11111111" [style=dotted];
+    "contract" -> "0x22222222" [style=dotted];
+    "contract" -> "0x33333333" [style=dotted];
+    "contract" -> "0x44444444" [style=dotted];
+    "0x0000000000000000000000000000000000c0ffee" [shape=ellipse];
+    "0x11111111" -> "0x0000000000000000000000000000000000c0ffee" [label="CALL 0xa9059cbb @0x77"];
+    "storage[0x3]" [shape=ellipse];
+    "0x22222222" -> "storage[0x3]" [label="STATICCALL 0x70a08231 @0xA8"];
+    "created@0xB2" [shape=ellipse];
+    "0x33333333" -> "created@0xB2" [label="CREATE2 @0xB2"];
+    "calldata@0xCD" [shape=ellipse];
+    "0x44444444" -> "calldata@0xCD" [label="CALL @0xCD"];
+}
+`
+    if buf.String() != want {
+        t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+    }
+
+    // Two calls outside any function to the address in the same slot
+    call := "60006000600460006000600354" + "5af1"
+    code, err := hex.DecodeString(call + "50" + call + "00")
+    if err != nil {
+        t.Fatal(err)
+    }
+    buf.Reset()
+    if err := writeCallsDOT(&buf, evmopt.NewProgram(code)); err != nil {
+        t.Fatal(err)
+    }
+    want = `digraph calls {
+    node [shape=box];
+    "contract";
+    "storage[0x3]" [shape=ellipse];
+    "contract" -> "storage[0x3]" [label="CALL @0xE"];
+    "contract" -> "storage[0x3]" [label="CALL @0x1E"];
+}
+`
+    if buf.String() != want {
+        t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+
+    "github.com/arachnid/evmopt"
+)
+
+// readBytecodeFile reads a file of hex or raw bytecode. Unlinked library addresses
+// in hex code are left as zero.
+func readBytecodeFile(path string) ([]byte, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    if isHex(data) {
+        code, err := decodeBytecode(data)
+        if err != nil {
+            return nil, err
+        }
+        return code.Code, nil
+    }
+    return data, nil
+}
+
+func runDiff(args []string) {
+    flags := flag.NewFlagSet("diff", flag.ExitOnError)
+    forkName := flags.String("fork", evmopt.LatestFork.String(), "fork whose semantics to execute under")
+    runs := flags.Int("runs", 256, "number of generated inputs to try")
+    seed := flags.Int64("seed", 0, "seed for input generation")
+    gas := flags.Uint64("gas", 10000000, "gas for each call")
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: evmdis diff [flags] <original> <optimized>\n")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    if flags.NArg() != 2 {
+        flags.Usage()
+        os.Exit(2)
+    }
+
+    fork, err := evmopt.ParseFork(*forkName)
+    if err != nil {
+        log.Fatal(err)
+    }
+    original, err := readBytecodeFile(flags.Arg(0))
+    if err != nil {
+        log.Fatalf("Could not read %v: %v", flags.Arg(0), err)
+    }
+    optimized, err := readBytecodeFile(flags.Arg(1))
+    if err != nil {
+        log.Fatalf("Could not read %v: %v", flags.Arg(1), err)
+    }
+
+    report := evmopt.Diff(original, optimized, &evmopt.DiffOptions{Fork: fork, Runs: *runs, Seed: *seed, Gas: *gas})
+    if report.Skipped > 0 {
+        log.Printf("Skipped %v inputs that call unsupported precompiles", report.Skipped)
+    }
+    if d := report.Divergence; d != nil {
+        fmt.Printf("Divergence after %v runs: %s\n", report.Runs, d.Reason)
+        fmt.Printf("calldata:  0x%x\n", d.Calldata)
+        fmt.Printf("original:  %v 0x%x\n", d.Original.Halt, d.Original.ReturnData)
+        fmt.Printf("optimized: %v 0x%x\n", d.Optimized.Halt, d.Optimized.ReturnData)
+        os.Exit(1)
+    }
+    fmt.Printf("No divergence in %v runs\n", report.Runs)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+
+    "github.com/arachnid/evmopt"
+)
+
+// Version of the JSON output schema. Bump this whenever a field is removed or its
+// meaning changes; adding fields does not require a new version.
+const jsonSchemaVersion = 1
+
+type jsonOutput struct {
+    Version int `json:"version"`
+    Summary jsonSummary `json:"summary"`
+    Proxy *jsonProxy `json:"proxy,omitempty"`
+    Blocks []jsonBlock `json:"blocks"`
+    Instructions []jsonInstruction `json:"instructions"`
+}
+
+type jsonSummary struct {
+    Fork string `json:"fork"`
+    Status string `json:"status"`
+    CodeSize int `json:"codeSize"`
+    Instructions int `json:"instructions"`
+    Blocks int `json:"blocks"`
+    ReachableBlocks int `json:"reachableBlocks"`
+    Diagnostics int `json:"diagnostics"`
+}
+
+type jsonProxy struct {
+    Kind string `json:"kind"`
+    PC int `json:"pc"`                                  // DELEGATECALL forwarding calls
+    Implementation string `json:"implementation,omitempty"`
+    Slot string `json:"slot,omitempty"`
+}
+
+type jsonBlock struct {
+    ID int `json:"id"`
+    Start int `json:"start"`
+    End int `json:"end"`
+    Reachable bool `json:"reachable"`
+    Successors []int `json:"successors"`
+    Predecessors []int `json:"predecessors"`
+}
+
+type jsonInstruction struct {
+    PC int `json:"pc"`
+    Opcode string `json:"opcode"`
+    Immediate string `json:"immediate,omitempty"`
+    Symbol string `json:"symbol,omitempty"`          // Library or immutable the immediate is a placeholder for
+    Reaches []int `json:"reaches"`
+    ReachedBy [][]int `json:"reachedBy"`
+    Block int `json:"block"`
+    Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+func sortedKeys(m map[int]bool) []int {
+    ret := intMapKeys(m)
+    sort.Ints(ret)
+    return ret
+}
+
+func blockIDs(blocks []*evmopt.BasicBlock) []int {
+    ret := make([]int, len(blocks))
+    for i, block := range blocks {
+        ret[i] = block.ID
+    }
+    return ret
+}
+
+func buildJSON(program *evmopt.Program, codeSize int) *jsonOutput {
+    out := &jsonOutput{
+        Version: jsonSchemaVersion,
+        Summary: jsonSummary{
+            Fork: program.Fork.String(),
+            Status: program.Status.String(),
+            CodeSize: codeSize,
+            Instructions: len(program.Instructions),
+            Blocks: len(program.Blocks),
+            Diagnostics: len(program.Diagnostics),
+        },
+        Blocks: make([]jsonBlock, 0, len(program.Blocks)),
+        Instructions: make([]jsonInstruction, 0, len(program.Instructions)),
+    }
+
+    if proxy := program.Proxy(); proxy != nil {
+        out.Proxy = &jsonProxy{Kind: proxy.Kind.String(), PC: proxy.PC}
+        if proxy.Implementation != nil {
+            out.Proxy.Implementation = proxy.Implementation.String()
+        }
+        if proxy.Slot != nil {
+            out.Proxy.Slot = fmt.Sprintf("0x%064x", proxy.Slot)
+        }
+    }
+
+    for _, block := range program.Blocks {
+        if block.Reachable {
+            out.Summary.ReachableBlocks += 1
+        }
+        out.Blocks = append(out.Blocks, jsonBlock{
+            ID: block.ID,
+            Start: block.Start,
+            End: block.End,
+            Reachable: block.Reachable,
+            Successors: blockIDs(block.Successors),
+            Predecessors: blockIDs(block.Predecessors),
+        })
+    }
+
+    diagnostics := make(map[int][]string)
+    for _, d := range program.Diagnostics {
+        diagnostics[d.PC] = append(diagnostics[d.PC], d.Message)
+    }
+
+    for _, pc := range program.PCs() {
+        inst := program.Instructions[pc]
+        record := jsonInstruction{
+            PC: pc,
+            Opcode: inst.Op.String(),
+            Reaches: sortedKeys(inst.Reaches),
+            ReachedBy: make([][]int, len(inst.ReachedBy)),
+            Block: program.BlockAt(pc).ID,
+            Symbol: inst.Symbol,
+            Diagnostics: diagnostics[pc],
+        }
+        if inst.Arg != nil && inst.Op.OperandSize() > 0 {
+            record.Immediate = fmt.Sprintf("0x%0*x", inst.Op.OperandSize() * 2, inst.Arg)
+        }
+        for i, sources := range inst.ReachedBy {
+            record.ReachedBy[i] = sortedKeys(sources)
+        }
+        out.Instructions = append(out.Instructions, record)
+    }
+
+    return out
+}
+
+func writeJSON(w io.Writer, program *evmopt.Program, codeSize int) error {
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(buildJSON(program, codeSize))
+}
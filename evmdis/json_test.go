@@ -0,0 +1,142 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "reflect"
+    "sort"
+    "testing"
+
+    "github.com/arachnid/evmopt"
+)
+
+// TestJSONSchema pins the names and layout of the fields in version 1 of the JSON
+// output. If it fails because a field was renamed or removed, bump jsonSchemaVersion.
+func TestJSONSchema(t *testing.T) {
+    // PUSH20 of an unlinked library, BALANCE, a jump to an invalid destination and an
+    // unreachable STOP
+    code, err := decodeBytecode([]byte("73__Math__________________________________3160ff5600"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    program, err := evmopt.NewProgramFromBytecode(context.Background(), code, &evmopt.Options{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var buf bytes.Buffer
+    if err := writeJSON(&buf, program, len(code.Code)); err != nil {
+        t.Fatal(err)
+    }
+
+    want := `{
+  "version": 1,
+  "summary": {
+    "fork": "Cancun",
+    "status": "complete",
+    "codeSize": 26,
+    "instructions": 5,
+    "blocks": 2,
+    "reachableBlocks": 1,
+    "diagnostics": 1
+  },
+  "blocks": [
+    {
+      "id": 0,
+      "start": 0,
+      "end": 24,
+      "reachable": true,
+      "successors": [],
+      "predecessors": []
+    },
+    {
+      "id": 1,
+      "start": 25,
+      "end": 25,
+      "reachable": false,
+      "successors": [],
+      "predecessors": []
+    }
+  ],
+  "instructions": [
+    {
+      "pc": 0,
+      "opcode": "PUSH20",
+      "immediate": "0x0000000000000000000000000000000000000000",
+      "symbol": "Math",
+      "reaches": [
+        21
+      ],
+      "reachedBy": [],
+      "block": 0
+    },
+    {
+      "pc": 21,
+      "opcode": "BALANCE",
+      "reaches": [],
+      "reachedBy": [
+        [
+          0
+        ]
+      ],
+      "block": 0
+    },
+    {
+      "pc": 22,
+      "opcode": "PUSH1",
+      "immediate": "0xff",
+      "reaches": [
+        24
+      ],
+      "reachedBy": [],
+      "block": 0
+    },
+    {
+      "pc": 24,
+      "opcode": "JUMP",
+      "reaches": [],
+      "reachedBy": [
+        [
+          22
+        ]
+      ],
+      "block": 0,
+      "diagnostics": [
+        "invalid jump destination 0xff"
+      ]
+    },
+    {
+      "pc": 25,
+      "opcode": "STOP",
+      "reaches": [],
+      "reachedBy": [],
+      "block": 1
+    }
+  ]
+}
+`
+    if buf.String() != want {
+        t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+    }
+
+    // The proxy object, present only for proxies
+    program, _ = loadProgram(t, "minimal_proxy")
+    data, err := json.Marshal(buildJSON(program, len(program.Code)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var fields struct {
+        Proxy map[string]interface{} `json:"proxy"`
+    }
+    if err := json.Unmarshal(data, &fields); err != nil {
+        t.Fatal(err)
+    }
+    var keys []string
+    for key := range fields.Proxy {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    if want := []string{"implementation", "kind", "pc"}; !reflect.DeepEqual(keys, want) {
+        t.Errorf("got proxy fields %v, want %v", keys, want)
+    }
+}
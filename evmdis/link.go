@@ -0,0 +1,57 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "math/big"
+    "strconv"
+    "strings"
+
+    "github.com/arachnid/evmopt"
+)
+
+// parseLinks parses a comma separated list of Name=0xaddress library addresses and one of
+// id=value immutable values, where value is decimal or 0x-prefixed hex.
+func parseLinks(libraries, immutables string) (map[string]evmopt.Address, map[int]*big.Int, error) {
+    addresses := make(map[string]evmopt.Address)
+    values := make(map[int]*big.Int)
+    for _, entry := range splitList(libraries) {
+        i := strings.LastIndex(entry, "=")
+        if i == -1 {
+            return nil, nil, fmt.Errorf("library %q is not of the form Name=0xaddress", entry)
+        }
+        data, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(entry[i + 1:], "0x"), "0X"))
+        if err != nil || len(data) != len(evmopt.Address{}) {
+            return nil, nil, fmt.Errorf("library %v: invalid address %q", entry[:i], entry[i + 1:])
+        }
+        var address evmopt.Address
+        copy(address[:], data)
+        addresses[entry[:i]] = address
+    }
+    for _, entry := range splitList(immutables) {
+        i := strings.Index(entry, "=")
+        if i == -1 {
+            return nil, nil, fmt.Errorf("immutable %q is not of the form id=value", entry)
+        }
+        id, err := strconv.Atoi(entry[:i])
+        if err != nil {
+            return nil, nil, fmt.Errorf("immutable %q: %v", entry, err)
+        }
+        value, ok := new(big.Int).SetString(entry[i + 1:], 0)
+        if !ok {
+            return nil, nil, fmt.Errorf("immutable %v: invalid value %q", id, entry[i + 1:])
+        }
+        values[id] = value
+    }
+    return addresses, values, nil
+}
+
+func splitList(list string) []string {
+    var ret []string
+    for _, entry := range strings.Split(list, ",") {
+        if entry = strings.TrimSpace(entry); entry != "" {
+            ret = append(ret, entry)
+        }
+    }
+    return ret
+}
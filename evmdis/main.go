@@ -1,6 +1,8 @@
 package main
 
 import (
+    "context"
+    "flag"
     "fmt"
     "io/ioutil"
     "log"
@@ -73,25 +75,223 @@ func intMapKeys(m map[int]bool) []int {
     return ret
 }
 
-func main() {
-    bytecode, err := ioutil.ReadAll(os.Stdin)
-    if err != nil {
-        log.Fatalf("Could not read from stdin: %v", err)
-    }
-
-    program := evmopt.NewProgram(bytecode)
-    //reachings := evmopt.Analyze(program)
-    //live := findLive(program, reachings)
+func printText(program *evmopt.Program, annotate bool, sources *sourceFiles) {
+    lastFile, lastLine := "", 0
     for idx := 0; ; idx += program.Instructions[idx].Op.OperandSize() + 1 {
         inst, ok := program.Instructions[idx]
         if !ok {
             break
         }
+        if line, text, ok := sources.line(inst.Source); ok {
+            if file := program.SourceFile(inst.Source); file != lastFile || line != lastLine {
+                fmt.Printf("\t// %s:%d: %s\n", file, line, text)
+                lastFile, lastLine = file, line
+            }
+        }
         operands := make([][]*evmopt.Instruction, len(inst.ReachedBy))
         for i, frame := range inst.ReachedBy {
             operands[i] = fetchInstructions(program, frame)
         }
-        fmt.Printf("0x%X\t%x\t%v\t%v\n", idx, intMapKeys(inst.Reaches), inst, operands)
+        if annotate && !inst.Op.IsPush() && !inst.Op.IsDup() && !inst.Op.IsSwap() && inst.Op != evmopt.POP && inst.Op != evmopt.JUMPDEST {
+            fmt.Printf("0x%X\t%x\t%v\t%v\t%v\n", idx, intMapKeys(inst.Reaches), inst, operands, program.Expression(idx))
+        } else {
+            fmt.Printf("0x%X\t%x\t%v\t%v\n", idx, intMapKeys(inst.Reaches), inst, operands)
+        }
         //fmt.Printf("0x%X\t%v\t%v\n", idx, live[idx], inst)
     }
 }
+
+// printGas prints each basic block with the gas it costs, followed by its instructions.
+func printGas(program *evmopt.Program) {
+    for _, block := range program.Blocks {
+        fmt.Printf("%v gas %v\n", block, program.BlockGas(block))
+        for pc := block.Start; pc <= block.End; {
+            inst, ok := program.Instructions[pc]
+            if !ok {
+                break
+            }
+            if dynamic := inst.Op.DynamicGas(program.Fork); dynamic != 0 {
+                fmt.Printf("  0x%X\t%d + %v\t%v\n", pc, inst.Op.StaticGas(program.Fork), dynamic, inst)
+            } else {
+                fmt.Printf("  0x%X\t%d\t%v\n", pc, inst.Op.StaticGas(program.Fork), inst)
+            }
+            pc += inst.Op.OperandSize() + 1
+        }
+    }
+}
+
+func main() {
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "batch":
+            runBatch(os.Args[2:])
+            return
+        case "diff":
+            runDiff(os.Args[2:])
+            return
+        }
+    }
+
+    format := flag.String("format", "text", "output format: text, json, decompile, ssa, gas (per basic block), bounds (worst-case gas per external function), sarif (detector findings), proxy (the proxy pattern the code follows, if any), interfaces (standard interfaces the contract conforms to), events (LOG instructions and the functions that emit them), calls, calls-json or calls-dot (external calls and contract creations) or bytecode (regenerated from SSA, as hex)")
+    forkName := flag.String("fork", evmopt.LatestFork.String(), "fork whose semantics to analyse under")
+    annotate := flag.Bool("annotate", false, "in text output, show the expression computed by each instruction")
+    detect := flag.Bool("detect", false, "run the security detectors and print their findings instead of the selected format")
+    srcmapPath := flag.String("srcmap", "", "file holding the solc source map of the code, to show source lines in text output and locate sarif findings")
+    sourceList := flag.String("sources", "", "comma separated source files, in the order of the source map's file indices")
+    artifactPath := flag.String("artifact", "", "read the code from a solc standard JSON output, Foundry or Hardhat artifact instead of stdin")
+    contractName := flag.String("contract", "", "name of the contract to analyse from the artifact, optionally as file.sol:Name")
+    creation := flag.Bool("creation", false, "analyse the contract's creation code from the artifact rather than its runtime code")
+    contextDepth := flag.Int("context", 0, "number of enclosing calls to analyse separately by return address (0 merges all states at a PC)")
+    libraries := flag.String("link", "", "comma separated Name=0xaddress library addresses to link unlinked code with; Name may be qualified as file.sol:Name")
+    hexInput := flag.Bool("hex", false, "read the code from stdin as hex, which may hold unlinked library placeholders, rather than raw bytes")
+    immutables := flag.String("immutable", "", "comma separated id=value values of immutables in artifact runtime code, by AST ID")
+    interfacesPath := flag.String("interfaces", "", "JSON file of interfaces, in the format of interfaces.json, to check for alongside the standard ones")
+    eventsPath := flag.String("events", "", "file of event signatures, one a line, to name events by alongside the standard ones")
+    flag.Parse()
+
+    fork, err := evmopt.ParseFork(*forkName)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    addresses, values, err := parseLinks(*libraries, *immutables)
+    if err != nil {
+        log.Fatal(err)
+    }
+    opts := &evmopt.Options{Fork: fork, ContextDepth: *contextDepth}
+    var program *evmopt.Program
+    var bytecode []byte
+    if *artifactPath != "" {
+        contracts, err := evmopt.LoadArtifact(*artifactPath)
+        if err != nil {
+            log.Fatalf("Could not load artifact: %v", err)
+        }
+        contract, err := evmopt.FindContract(contracts, *contractName)
+        if err != nil {
+            log.Fatal(err)
+        }
+        code, analyse := &contract.Runtime, contract.RuntimeProgram
+        if *creation {
+            code, analyse = &contract.Creation, contract.CreationProgram
+        }
+        if *code == nil {
+            log.Fatalf("Contract %v has no code", contract.Name)
+        }
+        if *code, err = (*code).Link(addresses, values); err != nil {
+            log.Fatalf("Could not link: %v", err)
+        }
+        bytecode = (*code).Code
+        if program, err = analyse(context.Background(), opts); err != nil {
+            log.Printf("Analysis incomplete: %v", err)
+        }
+    } else {
+        data, err := ioutil.ReadAll(os.Stdin)
+        if err != nil {
+            log.Fatalf("Could not read from stdin: %v", err)
+        }
+        // Hex input may hold library placeholders, which are analysed as unknown addresses
+        code := &evmopt.Bytecode{Code: data}
+        if *hexInput {
+            if code, err = decodeBytecode(data); err != nil {
+                log.Fatalf("Could not decode bytecode: %v", err)
+            }
+        }
+        if code, err = code.Link(addresses, values); err != nil {
+            log.Fatalf("Could not link: %v", err)
+        }
+        bytecode = code.Code
+        if program, err = evmopt.NewProgramFromBytecode(context.Background(), code, opts); err != nil {
+            log.Printf("Analysis incomplete: %v", err)
+        }
+    }
+    if *srcmapPath != "" {
+        if err := setSourceMap(program, *srcmapPath, *sourceList); err != nil {
+            log.Fatalf("Could not load source map: %v", err)
+        }
+    }
+    sources := loadSources(program)
+
+    if *detect {
+        for _, finding := range program.Detect(nil) {
+            fmt.Println(finding)
+        }
+        return
+    }
+    //reachings := evmopt.Analyze(program)
+    //live := findLive(program, reachings)
+    switch *format {
+    case "text":
+        printText(program, *annotate, sources)
+    case "json":
+        if err := writeJSON(os.Stdout, program, len(bytecode)); err != nil {
+            log.Fatalf("Could not write output: %v", err)
+        }
+    case "decompile":
+        fmt.Print(program.Decompile())
+    case "ssa":
+        fmt.Print(program.LowerSSA())
+    case "gas":
+        printGas(program)
+    case "bounds":
+        for _, bound := range program.FunctionGas() {
+            fmt.Println(bound)
+        }
+    case "sarif":
+        // Code read from stdin has no file to point to
+        artifact := *artifactPath
+        if artifact == "" {
+            artifact = "stdin"
+        }
+        if err := writeSARIF(os.Stdout, program, sources, artifact); err != nil {
+            log.Fatalf("Could not write output: %v", err)
+        }
+    case "proxy":
+        if proxy := program.Proxy(); proxy != nil {
+            fmt.Println(proxy)
+        } else {
+            fmt.Println("not a proxy")
+        }
+    case "interfaces":
+        interfaces := evmopt.Interfaces
+        if *interfacesPath != "" {
+            extra, err := evmopt.LoadInterfaces(*interfacesPath)
+            if err != nil {
+                log.Fatalf("Could not load interfaces: %v", err)
+            }
+            interfaces = append(append([]evmopt.Interface{}, interfaces...), extra...)
+        }
+        for _, conformance := range program.Conformance(interfaces) {
+            fmt.Println(conformance)
+        }
+    case "events":
+        signatures := evmopt.StandardEvents()
+        if *eventsPath != "" {
+            if err := evmopt.LoadEventSignatures(*eventsPath, signatures); err != nil {
+                log.Fatalf("Could not load event signatures: %v", err)
+            }
+        }
+        for _, event := range program.Events() {
+            fmt.Println(event.Format(signatures))
+        }
+    case "calls":
+        for _, call := range program.ExternalCalls() {
+            fmt.Println(call)
+        }
+    case "calls-json":
+        if err := writeCallsJSON(os.Stdout, program); err != nil {
+            log.Fatalf("Could not write output: %v", err)
+        }
+    case "calls-dot":
+        if err := writeCallsDOT(os.Stdout, program); err != nil {
+            log.Fatalf("Could not write output: %v", err)
+        }
+    case "bytecode":
+        code, err := program.LowerSSA().Generate()
+        if err != nil {
+            log.Fatalf("Could not generate code: %v", err)
+        }
+        fmt.Printf("%x\n", code)
+    default:
+        log.Fatalf("Unknown output format %q", *format)
+    }
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+
+    "github.com/arachnid/evmopt"
+)
+
+const (
+    sarifVersion = "2.1.0"
+    sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+    Version string `json:"version"`
+    Schema string `json:"$schema"`
+    Runs []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+    Tool sarifTool `json:"tool"`
+    Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+    Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+    Name string `json:"name"`
+    InformationURI string `json:"informationUri"`
+    Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+    ID string `json:"id"`
+    ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+    Text string `json:"text"`
+}
+
+type sarifResult struct {
+    RuleID string `json:"ruleId"`
+    RuleIndex int `json:"ruleIndex"`
+    Level string `json:"level"`
+    Message sarifMessage `json:"message"`
+    Locations []sarifLocation `json:"locations"`
+    Properties sarifProperties `json:"properties"`
+}
+
+type sarifProperties struct {
+    Severity string `json:"severity"`
+}
+
+type sarifLocation struct {
+    PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+    Address *sarifAddress `json:"address,omitempty"`
+    ArtifactLocation *sarifArtifactLocation `json:"artifactLocation,omitempty"`
+    Region *sarifRegion `json:"region,omitempty"`
+}
+
+type sarifAddress struct {
+    AbsoluteAddress int `json:"absoluteAddress"`
+    Kind string `json:"kind"`
+    Name string `json:"name"`
+}
+
+type sarifArtifactLocation struct {
+    URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+    StartLine int `json:"startLine,omitempty"`
+    StartColumn int `json:"startColumn,omitempty"`
+    EndLine int `json:"endLine,omitempty"`
+    EndColumn int `json:"endColumn,omitempty"`
+    CharOffset *int `json:"charOffset,omitempty"`
+    CharLength *int `json:"charLength,omitempty"`
+    ByteOffset *int `json:"byteOffset,omitempty"`
+    ByteLength *int `json:"byteLength,omitempty"`
+}
+
+// locate returns the locations of the instruction at pc: its address and bytes in the
+// code read from artifact and, if the source map covers it, its region in the source.
+func locate(program *evmopt.Program, sources *sourceFiles, artifact string, pc int) []sarifLocation {
+    inst := program.Instructions[pc]
+    offset, length := pc, inst.Op.OperandSize() + 1
+    locations := []sarifLocation{{sarifPhysicalLocation{
+        Address: &sarifAddress{pc, "instruction", inst.Op.String()},
+        ArtifactLocation: &sarifArtifactLocation{artifact},
+        Region: &sarifRegion{ByteOffset: &offset, ByteLength: &length},
+    }}}
+    r := inst.Source
+    name := program.SourceFile(r)
+    if name == "" {
+        return locations
+    }
+    location := sarifPhysicalLocation{ArtifactLocation: &sarifArtifactLocation{name}}
+    if data, ok := sources.content(r); ok {
+        region := &sarifRegion{}
+        region.StartLine, region.StartColumn = lineColumn(data, r.Start)
+        region.EndLine, region.EndColumn = lineColumn(data, r.Start + r.Length)
+        location.Region = region
+    } else {
+        location.Region = &sarifRegion{CharOffset: &r.Start, CharLength: &r.Length}
+    }
+    // Viewers show the first location, so the source goes before the code
+    return append([]sarifLocation{{location}}, locations...)
+}
+
+func sarifLevel(severity evmopt.Severity) string {
+    switch severity {
+    case evmopt.High:
+        return "error"
+    case evmopt.Medium:
+        return "warning"
+    }
+    return "note"
+}
+
+// buildSARIF runs detectors on program, which was read from the file artifact.
+func buildSARIF(program *evmopt.Program, detectors []evmopt.Detector, sources *sourceFiles, artifact string) *sarifLog {
+    driver := sarifDriver{
+        Name: "evmopt",
+        InformationURI: "https://github.com/arachnid/evmopt",
+        Rules: make([]sarifRule, len(detectors)),
+    }
+    ruleIndex := make(map[string]int)
+    for i, detector := range detectors {
+        driver.Rules[i] = sarifRule{detector.Name(), sarifMessage{detector.Description()}}
+        ruleIndex[detector.Name()] = i
+    }
+
+    run := sarifRun{Tool: sarifTool{driver}, Results: []sarifResult{}}
+    for _, finding := range program.Detect(detectors) {
+        run.Results = append(run.Results, sarifResult{
+            RuleID: finding.Detector,
+            RuleIndex: ruleIndex[finding.Detector],
+            Level: sarifLevel(finding.Severity),
+            Message: sarifMessage{fmt.Sprintf("%s (at PC 0x%X)", finding.Message, finding.PC)},
+            Locations: locate(program, sources, artifact, finding.PC),
+            Properties: sarifProperties{finding.Severity.String()},
+        })
+    }
+    return &sarifLog{sarifVersion, sarifSchema, []sarifRun{run}}
+}
+
+// writeSARIF runs the built in detectors and writes their findings as a SARIF log.
+// sources may be nil if the files in the program's source map were not read.
+func writeSARIF(w io.Writer, program *evmopt.Program, sources *sourceFiles, artifact string) error {
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(buildSARIF(program, evmopt.Detectors, sources, artifact))
+}
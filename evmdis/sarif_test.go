@@ -0,0 +1,97 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+
+    "github.com/arachnid/evmopt"
+)
+
+// opDetector reports every reachable instruction with a given opcode.
+type opDetector evmopt.OpCode
+
+func (self opDetector) Name() string { return "op-" + evmopt.OpCode(self).String() }
+func (self opDetector) Description() string { return "uses of " + evmopt.OpCode(self).String() }
+
+func (self opDetector) Detect(prog *evmopt.Program) (findings []evmopt.Finding) {
+    for pc, inst := range prog.Instructions {
+        if inst.Op == evmopt.OpCode(self) && prog.BlockAt(pc).Reachable {
+            findings = append(findings, evmopt.Finding{Detector: self.Name(), PC: pc, Severity: evmopt.Info, Message: "found"})
+        }
+    }
+    return findings
+}
+
+// loadProgram analyses testdata/contracts/name.hex.
+func loadProgram(t *testing.T, name string) (*evmopt.Program, string) {
+    path := filepath.Join("..", "testdata", "contracts", name + ".hex")
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    code, err := decodeBytecode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    program, err := evmopt.NewProgramFromBytecode(context.Background(), code, &evmopt.Options{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return program, path
+}
+
+func TestSARIFLocations(t *testing.T) {
+    program, path := loadProgram(t, "detectors")
+    run := buildSARIF(program, evmopt.Detectors, loadSources(program), path).Runs[0]
+    if len(run.Results) != 6 {
+        t.Fatalf("got %v results for detectors, want 6", len(run.Results))
+    }
+    for _, result := range run.Results {
+        if len(result.Locations) != 1 {
+            t.Errorf("%v: got %v locations, want only the code", result.Message.Text, len(result.Locations))
+            continue
+        }
+        location := result.Locations[0].PhysicalLocation
+        if location.ArtifactLocation == nil || location.ArtifactLocation.URI != path || location.Address == nil {
+            t.Errorf("%v: got artifact %+v, address %+v, want %v and an address", result.Message.Text, location.ArtifactLocation, location.Address, path)
+        } else if location.Region == nil || *location.Region.ByteOffset != location.Address.AbsoluteAddress || *location.Region.ByteLength != 1 {
+            t.Errorf("%v: got region %+v, want the byte at 0x%X", result.Message.Text, location.Region, location.Address.AbsoluteAddress)
+        }
+    }
+
+    program, path = loadProgram(t, "ternary_call")
+    source := filepath.Join("..", "testdata", "contracts", "ternary_call.sol")
+    if err := setSourceMap(program, filepath.Join("..", "testdata", "contracts", "ternary_call.srcmap"), source); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name string
+        sources *sourceFiles
+        region string
+    }{
+        {"with sources", loadSources(program), `{"startLine":7,"startColumn":9,"endLine":7,"endColumn":59}`},
+        {"without sources", nil, `{"charOffset":178,"charLength":50}`},
+    }
+
+    for _, tt := range tests {
+        run := buildSARIF(program, []evmopt.Detector{opDetector(evmopt.RETURN)}, tt.sources, path).Runs[0]
+        if len(run.Results) != 1 || len(run.Results[0].Locations) != 2 {
+            t.Errorf("%v: got %+v, want one result with two locations", tt.name, run.Results)
+            continue
+        }
+        src, code := run.Results[0].Locations[0].PhysicalLocation, run.Results[0].Locations[1].PhysicalLocation
+        if src.ArtifactLocation.URI != source || src.Address != nil || src.Region == nil {
+            t.Errorf("%v: got source location %+v, want %v without an address", tt.name, src, source)
+        } else if region, _ := json.Marshal(src.Region); string(region) != tt.region {
+            t.Errorf("%v: got region %s, want %s", tt.name, region, tt.region)
+        }
+        if code.ArtifactLocation.URI != path || code.Address == nil || code.Address.AbsoluteAddress != 0x20 {
+            t.Errorf("%v: got code location %+v, want %v at 0x20", tt.name, code, path)
+        } else if region, _ := json.Marshal(code.Region); string(region) != `{"byteOffset":32,"byteLength":1}` {
+            t.Errorf("%v: got code region %s, want the byte at 0x20", tt.name, region)
+        }
+    }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "strings"
+
+    "github.com/arachnid/evmopt"
+)
+
+// sourceFiles holds the contents of the source files named by a program's source map.
+type sourceFiles struct {
+    program *evmopt.Program
+    contents map[int][]byte         // Contents of the files that could be read, by index
+}
+
+// setSourceMap attaches the source map in srcmapPath to program. files is a comma
+// separated list of the paths of the source files it refers to, by index.
+func setSourceMap(program *evmopt.Program, srcmapPath, files string) error {
+    data, err := ioutil.ReadFile(srcmapPath)
+    if err != nil {
+        return err
+    }
+    var names []string
+    if files != "" {
+        names = strings.Split(files, ",")
+    }
+    return program.SetSourceMap(string(data), names)
+}
+
+// loadSources reads the source files named by program's source map.
+func loadSources(program *evmopt.Program) *sourceFiles {
+    sources := &sourceFiles{program, make(map[int][]byte)}
+    for i, name := range program.SourceFiles {
+        if data, err := ioutil.ReadFile(name); err == nil {
+            sources.contents[i] = data
+        }
+    }
+    return sources
+}
+
+// lineColumn returns the 1-based line and column of offset in data.
+func lineColumn(data []byte, offset int) (int, int) {
+    if offset > len(data) {
+        offset = len(data)
+    }
+    line := bytes.Count(data[:offset], []byte("\n")) + 1
+    return line, offset - (bytes.LastIndexByte(data[:offset], '\n') + 1) + 1
+}
+
+// content returns the contents of the file r is in, if r lies within it.
+func (self *sourceFiles) content(r *evmopt.SourceRange) ([]byte, bool) {
+    if self == nil || r == nil {
+        return nil, false
+    }
+    data, ok := self.contents[r.File]
+    if !ok || r.Start < 0 || r.Length < 0 || r.Start + r.Length > len(data) {
+        return nil, false
+    }
+    return data, true
+}
+
+// line returns the number and text of the line r starts on.
+func (self *sourceFiles) line(r *evmopt.SourceRange) (int, string, bool) {
+    data, ok := self.content(r)
+    if !ok {
+        return 0, "", false
+    }
+    line, column := lineColumn(data, r.Start)
+    text := data[r.Start - column + 1:]
+    if end := bytes.IndexByte(text, '\n'); end >= 0 {
+        text = text[:end]
+    }
+    return line, strings.TrimSpace(string(text)), true
+}
@@ -0,0 +1,156 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "sort"
+    "strings"
+)
+
+// Expression is the tree of computations that produce a value, rebuilt from the
+// def-use chains. DUP and SWAP never appear; they only move values around.
+type Expression struct {
+    PC int                          // Instruction that computes the value; -1 for merges and unknowns
+    Op OpCode
+    Value *big.Int                  // Value of constants
+    Symbol string                   // Name of an unlinked library address or immutable
+    Args []*Expression              // Operands, top of stack first
+    Alternatives []*Expression      // If the value can come from more than one source, one expression for each
+    Cycle bool                      // True if this is a reference back to an enclosing expression for PC
+}
+
+// IsMerge returns true if the value has more than one possible source.
+func (self *Expression) IsMerge() bool {
+    return len(self.Alternatives) > 0
+}
+
+// IsUnknown returns true if analysis found no source for the value.
+func (self *Expression) IsUnknown() bool {
+    return self.PC == -1 && !self.IsMerge()
+}
+
+// IsConstant returns true if the value is known statically.
+func (self *Expression) IsConstant() bool {
+    return self.Value != nil
+}
+
+// How deep String descends before eliding subexpressions
+const expressionPrintDepth = 8
+
+func (self *Expression) String() string {
+    return self.format(expressionPrintDepth)
+}
+
+func (self *Expression) format(depth int) string {
+    switch {
+    case self.IsUnknown():
+        return "?"
+    case self.IsConstant():
+        return fmt.Sprintf("0x%x", self.Value)
+    case self.Symbol != "":
+        return self.Symbol
+    case self.Cycle:
+        return fmt.Sprintf("%v@0x%X", self.Op, self.PC)
+    case depth == 0:
+        return "..."
+    }
+
+    var args []string
+    name := self.Op.String()
+    if self.IsMerge() {
+        name = "PHI"
+        for _, alt := range self.Alternatives {
+            args = append(args, alt.format(depth - 1))
+        }
+    } else if len(self.Args) == 0 {
+        return name
+    } else {
+        for _, arg := range self.Args {
+            args = append(args, arg.format(depth - 1))
+        }
+    }
+    return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+type expressionBuilder struct {
+    prog *Program
+    built map[int]*Expression
+    building map[int]bool
+}
+
+func newExpressionBuilder(prog *Program) *expressionBuilder {
+    return &expressionBuilder{prog, make(map[int]*Expression), make(map[int]bool)}
+}
+
+// value returns the expression for the instruction at pc applied to its operands.
+func (self *expressionBuilder) value(pc int) *Expression {
+    if expr, ok := self.built[pc]; ok {
+        return expr
+    }
+    inst := self.prog.Instructions[pc]
+    if self.building[pc] {
+        return &Expression{PC: pc, Op: inst.Op, Cycle: true}
+    }
+
+    expr := &Expression{PC: pc, Op: inst.Op}
+    switch {
+    case inst.Op.IsPush() && inst.Symbol != "":
+        expr.Symbol = inst.Symbol
+    case inst.Op.IsPush():
+        expr.Value = inst.Arg
+    case inst.Op == PC:
+        expr.Value = big.NewInt(int64(pc))
+    default:
+        self.building[pc] = true
+        expr.Args = make([]*Expression, len(inst.ReachedBy))
+        for i := range inst.ReachedBy {
+            expr.Args[i] = self.operand(pc, i)
+        }
+        delete(self.building, pc)
+    }
+    self.built[pc] = expr
+    return expr
+}
+
+// operand returns the expression for operand i of the instruction at pc.
+func (self *expressionBuilder) operand(pc, i int) *Expression {
+    sources := self.prog.Instructions[pc].ReachedBy[i]
+    switch len(sources) {
+    case 0:
+        return &Expression{PC: -1}
+    case 1:
+        for source := range sources {
+            return self.value(source)
+        }
+    }
+
+    pcs := make([]int, 0, len(sources))
+    for source := range sources {
+        pcs = append(pcs, source)
+    }
+    sort.Ints(pcs)
+    expr := &Expression{PC: -1, Alternatives: make([]*Expression, len(pcs))}
+    for j, source := range pcs {
+        expr.Alternatives[j] = self.value(source)
+    }
+    return expr
+}
+
+// Expression returns the expression computed by the instruction at pc, or nil if there
+// is no instruction there.
+func (self *Program) Expression(pc int) *Expression {
+    if _, ok := self.Instructions[pc]; !ok {
+        return nil
+    }
+    return newExpressionBuilder(self).value(pc)
+}
+
+// OperandExpression returns the expression for operand i of the instruction at pc, or
+// nil if there is no such operand.
+func (self *Program) OperandExpression(pc, i int) *Expression {
+    inst, ok := self.Instructions[pc]
+    if !ok || i < 0 || i >= len(inst.ReachedBy) {
+        return nil
+    }
+    return newExpressionBuilder(self).operand(pc, i)
+}
@@ -0,0 +1,46 @@
+package evmopt
+
+import (
+    "testing"
+)
+
+func TestExpression(t *testing.T) {
+    tests := []struct {
+        code string
+        pc int
+        operand int                 // Operand of the instruction at pc, or -1 for its own value
+        want string
+    }{
+        {"6002600301600055", 4, -1, "ADD(0x3, 0x2)"},
+        {"6002600301600055", 7, 0, "0x0"},
+        {"6002600301600055", 7, 1, "ADD(0x3, 0x2)"},
+        // SWAP and DUP only move values
+        {"600260039003" + "00", 5, -1, "SUB(0x2, 0x3)"},
+        {"60058002" + "00", 3, -1, "MUL(0x5, 0x5)"},
+        {"58600055", 3, 1, "0x0"},
+        // Each arm of a conditional pushes a different value for the SSTORE after the join
+        {"600035600b576001600e565b60025b60005500", 5, 1, "CALLDATALOAD(0x0)"},
+        {"600035600b576001600e565b60025b60005500", 17, 1, "PHI(0x1, 0x2)"},
+        // A loop counter refers back to its own increment
+        {"60005b60010180600a1060025700", 5, -1, "ADD(0x1, PHI(0x0, ADD@0x5))"},
+        {"60005b60010180600a1060025700", 9, -1, "LT(0xa, ADD(0x1, PHI(0x0, ADD@0x5)))"},
+    }
+
+    for _, tt := range tests {
+        prog := NewProgram(mustDecodeHex(t, tt.code))
+        expr := prog.Expression(tt.pc)
+        if tt.operand >= 0 {
+            expr = prog.OperandExpression(tt.pc, tt.operand)
+        }
+        if expr == nil {
+            t.Errorf("%v at 0x%X: no expression", tt.code, tt.pc)
+        } else if got := expr.String(); got != tt.want {
+            t.Errorf("%v at 0x%X: got %v, want %v", tt.code, tt.pc, got, tt.want)
+        }
+    }
+
+    prog := NewProgram(mustDecodeHex(t, "6001600055"))
+    if prog.Expression(1) != nil || prog.OperandExpression(4, 2) != nil {
+        t.Errorf("expected no expression inside a push or past the last operand")
+    }
+}
@@ -0,0 +1,108 @@
+package evmopt
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Fork identifies a set of EVM semantics introduced by a network upgrade.
+// The zero value selects LatestFork.
+type Fork int
+
+const (
+    Frontier Fork = iota + 1
+    Homestead
+    TangerineWhistle
+    SpuriousDragon
+    Byzantium
+    Constantinople
+    Petersburg
+    Istanbul
+    Berlin
+    London
+    Paris
+    Shanghai
+    Cancun
+
+    LatestFork = Cancun
+)
+
+var forkToString = map[Fork]string{
+    Frontier:         "Frontier",
+    Homestead:        "Homestead",
+    TangerineWhistle: "TangerineWhistle",
+    SpuriousDragon:   "SpuriousDragon",
+    Byzantium:        "Byzantium",
+    Constantinople:   "Constantinople",
+    Petersburg:       "Petersburg",
+    Istanbul:         "Istanbul",
+    Berlin:           "Berlin",
+    London:           "London",
+    Paris:            "Paris",
+    Shanghai:         "Shanghai",
+    Cancun:           "Cancun",
+}
+
+func (self Fork) String() string {
+    str := forkToString[self.resolve()]
+    if len(str) == 0 {
+        return fmt.Sprintf("Unknown fork %d", int(self))
+    }
+    return str
+}
+
+func (self Fork) resolve() Fork {
+    if self == 0 {
+        return LatestFork
+    }
+    return self
+}
+
+// ParseFork returns the fork with the given name, ignoring case.
+func ParseFork(name string) (Fork, error) {
+    for fork, str := range forkToString {
+        if strings.EqualFold(str, name) {
+            return fork, nil
+        }
+    }
+    return 0, fmt.Errorf("unknown fork %q", name)
+}
+
+// Opcodes not listed here have been available since Frontier.
+var opCodeToFork = map[OpCode]Fork{
+    DELEGATECALL:   Homestead,
+    RETURNDATASIZE: Byzantium,
+    RETURNDATACOPY: Byzantium,
+    STATICCALL:     Byzantium,
+    REVERT:         Byzantium,
+    SHL:            Constantinople,
+    SHR:            Constantinople,
+    SAR:            Constantinople,
+    EXTCODEHASH:    Constantinople,
+    CREATE2:        Constantinople,
+    CHAINID:        Istanbul,
+    SELFBALANCE:    Istanbul,
+    BASEFEE:        London,
+    PUSH0:          Shanghai,
+    TLOAD:          Cancun,
+    TSTORE:         Cancun,
+    MCOPY:          Cancun,
+    BLOBHASH:       Cancun,
+    BLOBBASEFEE:    Cancun,
+}
+
+// Fork returns the fork that introduced the opcode.
+func (o OpCode) Fork() Fork {
+    if fork, ok := opCodeToFork[o]; ok {
+        return fork
+    }
+    return Frontier
+}
+
+// ValidIn returns true if the opcode is defined and executable under the given fork.
+func (o OpCode) ValidIn(fork Fork) bool {
+    if _, ok := opCodeToString[o]; !ok || o == INVALID {
+        return false
+    }
+    return o.Fork() <= fork.resolve()
+}
@@ -0,0 +1,247 @@
+package evmopt
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Bounds analysis of fuzzed inputs so each runs quickly
+const fuzzMaxStates = 20000
+
+func addSeedContracts(f *testing.F) {
+    paths, err := filepath.Glob("testdata/contracts/*.hex")
+    if err != nil {
+        f.Fatal(err)
+    }
+    for _, path := range paths {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            f.Fatal(err)
+        }
+        code, err := hex.DecodeString(strings.TrimSpace(string(data)))
+        if err != nil {
+            f.Fatalf("%v: %v", path, err)
+        }
+        f.Add(code)
+    }
+    f.Add([]byte{})
+    f.Add([]byte{byte(PUSH2)})
+    f.Add([]byte{byte(ADD), byte(JUMP)})
+    f.Add([]byte{byte(PUSH1), 0x03, byte(JUMP), byte(JUMPDEST), byte(PUSH1), 0x10, byte(JUMP)})
+}
+
+// checkInvariants verifies the relationships between instructions and blocks that the rest
+// of the package relies on.
+func checkInvariants(t *testing.T, code []byte, prog *Program) {
+    pc := 0
+    for _, p := range prog.PCs() {
+        if p != pc {
+            t.Fatalf("instruction at 0x%X, expected 0x%X", p, pc)
+        }
+        pc += prog.Instructions[p].Op.OperandSize() + 1
+    }
+    if len(code) > 0 && pc < len(code) {
+        t.Fatalf("instructions end at 0x%X, code at 0x%X", pc, len(code))
+    }
+
+    for pc, inst := range prog.Instructions {
+        if len(inst.ReachedBy) != inst.Op.StackReads() {
+            t.Fatalf("0x%X: %v has %v operand sets", pc, inst, len(inst.ReachedBy))
+        }
+        for i, sources := range inst.ReachedBy {
+            for source := range sources {
+                producer, ok := prog.Instructions[source]
+                if !ok {
+                    t.Fatalf("0x%X: operand %v reached by missing instruction 0x%X", pc, i, source)
+                }
+                if !inst.Op.IsDup() && !inst.Op.IsSwap() && !producer.Reaches[pc] {
+                    t.Fatalf("0x%X: operand %v reached by 0x%X, which does not reach it", pc, i, source)
+                }
+            }
+        }
+        for target := range inst.Reaches {
+            consumer, ok := prog.Instructions[target]
+            if !ok {
+                t.Fatalf("0x%X: reaches missing instruction 0x%X", pc, target)
+            }
+            found := false
+            for _, sources := range consumer.ReachedBy {
+                found = found || sources[pc]
+            }
+            if !found {
+                t.Fatalf("0x%X: reaches 0x%X, which is not reached by it", pc, target)
+            }
+        }
+    }
+
+    for _, block := range prog.Blocks {
+        if block.Start > block.End || prog.Instructions[block.Start] == nil || prog.Instructions[block.End] == nil {
+            t.Fatalf("block %v does not span instructions", block)
+        }
+        for _, succ := range block.Successors {
+            if prog.Instructions[succ.Start] == nil {
+                t.Fatalf("block %v has successor %v outside the code", block, succ)
+            }
+            found := false
+            for _, pred := range succ.Predecessors {
+                found = found || pred == block
+            }
+            if !found {
+                t.Fatalf("block %v is not a predecessor of its successor %v", block, succ)
+            }
+        }
+    }
+}
+
+func FuzzNewProgram(f *testing.F) {
+    addSeedContracts(f)
+    f.Fuzz(func(t *testing.T, code []byte) {
+        prog, err := NewProgramWithOptions(context.Background(), code, &Options{MaxStates: fuzzMaxStates})
+        if err != nil && err != ErrBudgetExceeded {
+            t.Fatal(err)
+        }
+        checkInvariants(t, code, prog)
+    })
+}
+
+// FuzzContextSensitive checks that context sensitive analysis is consistent, and that the
+// sources it finds in each context are the same as those found without contexts.
+func FuzzContextSensitive(f *testing.F) {
+    addSeedContracts(f)
+    f.Fuzz(func(t *testing.T, code []byte) {
+        prog, err := NewProgramWithOptions(context.Background(), code, &Options{MaxStates: fuzzMaxStates, ContextDepth: 2})
+        if err != nil && err != ErrBudgetExceeded {
+            t.Fatal(err)
+        }
+        checkInvariants(t, code, prog)
+        for pc, inst := range prog.Instructions {
+            union := make([]map[int]bool, len(inst.ReachedBy))
+            for i := range union {
+                union[i] = make(map[int]bool)
+            }
+            for _, c := range prog.ReachingContexts(pc) {
+                for i, sources := range c.ReachedBy {
+                    for source := range sources {
+                        if !inst.ReachedBy[i][source] {
+                            t.Fatalf("0x%X: operand %v reached by 0x%X in context %v only", pc, i, source, c.Context)
+                        }
+                        union[i][source] = true
+                    }
+                }
+            }
+            for i, sources := range inst.ReachedBy {
+                if len(sources) != len(union[i]) {
+                    t.Fatalf("0x%X: operand %v has sources outside every context", pc, i)
+                }
+            }
+        }
+    })
+}
+
+// FuzzAnalysis runs the analyses built on the reaching definitions, which must not panic on
+// any program.
+func FuzzAnalysis(f *testing.F) {
+    addSeedContracts(f)
+    f.Fuzz(func(t *testing.T, code []byte) {
+        prog, _ := NewProgramWithOptions(context.Background(), code, &Options{MaxStates: fuzzMaxStates})
+        prog.ExternalFunctions()
+        prog.Dominators()
+        prog.PostDominators()
+        prog.Loops()
+        prog.InternalFunctions()
+        prog.FunctionGas()
+        prog.Detect(nil)
+        prog.Events()
+        prog.ExternalCalls()
+        for pc := range prog.Instructions {
+            prog.Expression(pc)
+        }
+        prog.Decompile()
+        ssa := prog.LowerSSA()
+        _ = ssa.String()
+        ssa.Generate()
+    })
+}
+
+// FuzzGenerate checks that code regenerated from SSA behaves the same as the original.
+func FuzzGenerate(f *testing.F) {
+    addSeedContracts(f)
+    f.Fuzz(func(t *testing.T, code []byte) {
+        prog, _ := NewProgramWithOptions(context.Background(), code, &Options{MaxStates: fuzzMaxStates})
+        out, err := prog.LowerSSA().Generate()
+        if err != nil {
+            return
+        }
+        if report := Diff(code, out, &DiffOptions{Runs: 8, Gas: 100000}); report.Divergence != nil {
+            t.Fatalf("%x generated %x: %v", code, out, report.Divergence)
+        }
+    })
+}
+
+func FuzzAssemblerRoundTrip(f *testing.F) {
+    addSeedContracts(f)
+    f.Fuzz(func(t *testing.T, code []byte) {
+        prog := decodeProgram(code)
+        asm := NewAssembler(LatestFork)
+        for _, pc := range prog.PCs() {
+            inst := prog.Instructions[pc]
+            asm.Instruction(inst.Op, inst.Arg)
+        }
+        out, err := asm.Assemble()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(out) != asm.Size() {
+            t.Fatalf("assembled %v bytes, Size returned %v", len(out), asm.Size())
+        }
+        // A truncated push at the end of the code is read as if padded with zeros
+        expected := append(append([]byte{}, code...), make([]byte, len(out) - len(code))...)
+        if len(out) < len(code) || !bytes.Equal(out, expected) {
+            t.Fatalf("round trip of %x gave %x", code, out)
+        }
+    })
+}
+
+// FuzzParseSourceMap checks that source maps parse without panicking, and that every entry
+// is decoded.
+func FuzzParseSourceMap(f *testing.F) {
+    f.Add("1:2:1;:9;2:1:2;;")
+    f.Add("0:120:0:-:0;;;;8:10::i;:::o")
+    f.Add("")
+    f.Fuzz(func(t *testing.T, srcmap string) {
+        ranges, err := ParseSourceMap(srcmap)
+        if err != nil {
+            return
+        }
+        if trimmed := strings.TrimSpace(srcmap); trimmed != "" && len(ranges) != strings.Count(trimmed, ";") + 1 {
+            t.Fatalf("%q parsed to %d entries", srcmap, len(ranges))
+        }
+    })
+}
+
+// FuzzParseBytecode checks that hex with library placeholders parses without panicking,
+// and that every placeholder has a reference within the code.
+func FuzzParseBytecode(f *testing.F) {
+    f.Add("73__$0123456789abcdef0123456789abcdef01$__600055")
+    f.Add("0x73__Lib_____________________________________31")
+    f.Add("6001__")
+    f.Fuzz(func(t *testing.T, object string) {
+        code, err := ParseBytecode(object)
+        if err != nil || code == nil {
+            return
+        }
+        for _, ref := range code.LinkReferences {
+            if ref.Start + ref.Length > len(code.Code) {
+                t.Fatalf("%q has reference %+v past the end of the code", object, ref)
+            }
+        }
+        if _, err := NewProgramFromBytecode(context.Background(), code, &Options{MaxStates: fuzzMaxStates}); err != nil && err != ErrBudgetExceeded {
+            t.Fatal(err)
+        }
+    })
+}
@@ -0,0 +1,218 @@
+package evmopt
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Gas costs shared by every fork
+const (
+    gasZero uint64 = 0
+    gasBase uint64 = 2
+    gasVeryLow uint64 = 3
+    gasLow uint64 = 5
+    gasMid uint64 = 8
+    gasHigh uint64 = 10
+
+    gasMemory uint64 = 3                    // Per word of memory, plus a quadratic term
+    gasCopy uint64 = 3                      // Per word copied by the *COPY ops
+    gasSha3Word uint64 = 6
+    gasLogTopic uint64 = 375
+    gasLogData uint64 = 8
+    gasCreate uint64 = 32000
+    gasCodeDeposit uint64 = 200             // Per byte of deployed code
+    gasInitCodeWord uint64 = 2              // Per word of init code, from Shanghai
+    gasCallValue uint64 = 9000
+    gasCallStipend uint64 = 2300
+    gasNewAccount uint64 = 25000
+    gasSstoreSet uint64 = 20000
+    gasSstoreReset uint64 = 5000
+    gasColdAccount uint64 = 2600            // EIP-2929 cold account access, from Berlin
+    gasColdSload uint64 = 2100
+    gasWarmAccess uint64 = 100
+)
+
+// Limits on contract code, from SpuriousDragon and Shanghai respectively
+const (
+    maxCodeSize = 24576
+    maxInitCodeSize = 2 * maxCodeSize
+)
+
+// DynamicGas is a set of costs an instruction may incur on top of its static gas.
+type DynamicGas uint
+
+const (
+    GasMemory DynamicGas = 1 << iota        // Memory expansion
+    GasCopy                                 // Per word copied
+    GasHash                                 // Per word hashed
+    GasLogData                              // Per byte of log data
+    GasExpByte                              // Per byte of the exponent
+    GasStorage                              // SSTORE set, reset and refund rules
+    GasColdAccess                           // EIP-2929 cold account or slot surcharge
+    GasCall                                 // Value transfer, new accounts and gas forwarded to the callee
+    GasCreate                               // Init code, code deposit and gas forwarded to the constructor
+    GasNewAccount                           // SELFDESTRUCT to an account that does not exist
+)
+
+var dynamicGasNames = []string{"memory", "copy", "hash", "log-data", "exp-byte", "storage", "cold-access", "call", "create", "new-account"}
+
+func (self DynamicGas) String() string {
+    var names []string
+    for i, name := range dynamicGasNames {
+        if self & (1 << uint(i)) != 0 {
+            names = append(names, name)
+        }
+    }
+    return strings.Join(names, "|")
+}
+
+// DynamicGas returns the costs the opcode may incur under fork in addition to StaticGas.
+func (o OpCode) DynamicGas(fork Fork) (costs DynamicGas) {
+    fork = fork.resolve()
+    switch o {
+    case SHA3:
+        costs |= GasMemory | GasHash
+    case CALLDATACOPY, CODECOPY, RETURNDATACOPY, MCOPY:
+        costs |= GasMemory | GasCopy
+    case EXTCODECOPY:
+        costs |= GasMemory | GasCopy | GasColdAccess
+    case MLOAD, MSTORE, MSTORE8, RETURN, REVERT:
+        costs |= GasMemory
+    case LOG0, LOG1, LOG2, LOG3, LOG4:
+        costs |= GasMemory | GasLogData
+    case EXP:
+        costs |= GasExpByte
+    case SSTORE:
+        costs |= GasStorage | GasColdAccess
+    case BALANCE, EXTCODESIZE, EXTCODEHASH, SLOAD:
+        costs |= GasColdAccess
+    case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+        costs |= GasMemory | GasCall | GasColdAccess
+    case CREATE:
+        costs |= GasMemory | GasCreate
+    case CREATE2:
+        costs |= GasMemory | GasCreate | GasHash
+    case SELFDESTRUCT:
+        costs |= GasColdAccess
+        if fork >= TangerineWhistle {
+            costs |= GasNewAccount
+        }
+    }
+    if fork < Berlin {
+        costs &^= GasColdAccess
+    }
+    return costs
+}
+
+// BlockGas is the gas charged for executing a basic block once.
+type BlockGas struct {
+    Static uint64                           // Sum of the static gas of every instruction
+    Dynamic DynamicGas                      // Costs that depend on operands or state
+}
+
+func (self BlockGas) String() string {
+    if self.Dynamic == 0 {
+        return fmt.Sprintf("%d", self.Static)
+    }
+    return fmt.Sprintf("%d + %v", self.Static, self.Dynamic)
+}
+
+// BlockGas returns the gas charged for running block under the program's fork.
+func (self *Program) BlockGas(block *BasicBlock) (gas BlockGas) {
+    for pc := block.Start; pc <= block.End; {
+        inst, ok := self.Instructions[pc]
+        if !ok {
+            break
+        }
+        gas.Static += inst.Op.StaticGas(self.Fork)
+        gas.Dynamic |= inst.Op.DynamicGas(self.Fork)
+        pc += inst.Op.OperandSize() + 1
+    }
+    return gas
+}
+
+func toWords(size uint64) uint64 {
+    return (size + 31) / 32
+}
+
+// memoryGas returns the total cost of memory of the given size in words.
+func memoryGas(words uint64) uint64 {
+    return gasMemory * words + words * words / 512
+}
+
+func expByteGas(fork Fork) uint64 {
+    if fork.resolve() >= SpuriousDragon {
+        return 50
+    }
+    return 10
+}
+
+// sstoreClearRefund is the refund for clearing a storage slot.
+func sstoreClearRefund(fork Fork) int64 {
+    if fork.resolve() >= London {
+        return 4800
+    }
+    return 15000
+}
+
+// maxRefundQuotient is the divisor of gas used giving the largest refund a transaction can receive.
+func maxRefundQuotient(fork Fork) uint64 {
+    if fork.resolve() >= London {
+        return 5
+    }
+    return 2
+}
+
+// sstoreGas returns the cost of changing a slot from current to value, given the value
+// it had at the start of the transaction, and the change in the refund counter. Net gas
+// metering applies in Constantinople and from Istanbul on.
+func sstoreGas(fork Fork, original, current, value Word) (gas uint64, refund int64) {
+    fork = fork.resolve()
+    var zero Word
+    if fork < Constantinople || fork == Petersburg {
+        switch {
+        case current == zero && value != zero:
+            return gasSstoreSet, 0
+        case current != zero && value == zero:
+            return gasSstoreReset, sstoreClearRefund(fork)
+        }
+        return gasSstoreReset, 0
+    }
+
+    noop, reset := uint64(200), gasSstoreReset
+    switch {
+    case fork >= Berlin:
+        noop, reset = gasWarmAccess, gasSstoreReset - gasColdSload
+    case fork >= Istanbul:
+        noop = 800
+    }
+    clear := sstoreClearRefund(fork)
+
+    if current == value {
+        return noop, 0
+    }
+    if original == current {
+        if original == zero {
+            return gasSstoreSet, 0
+        }
+        if value == zero {
+            refund = clear
+        }
+        return reset, refund
+    }
+    if original != zero {
+        if current == zero {
+            refund -= clear
+        } else if value == zero {
+            refund += clear
+        }
+    }
+    if original == value {
+        if original == zero {
+            refund += int64(gasSstoreSet - noop)
+        } else {
+            refund += int64(reset - noop)
+        }
+    }
+    return noop, refund
+}
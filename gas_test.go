@@ -0,0 +1,129 @@
+package evmopt
+
+import (
+    "context"
+    "testing"
+)
+
+func TestStaticGas(t *testing.T) {
+    tests := []struct {
+        op OpCode
+        fork Fork
+        gas uint64
+    }{
+        {ADD, Frontier, 3},
+        {ADDMOD, Cancun, 8},
+        {EXP, Frontier, 10},
+        {SHA3, Frontier, 30},
+        {JUMPDEST, Frontier, 1},
+        {LOG2, Frontier, 1125},
+        {CREATE, Frontier, 32000},
+        {BALANCE, Frontier, 20},
+        {BALANCE, TangerineWhistle, 400},
+        {BALANCE, Istanbul, 700},
+        {BALANCE, Berlin, 100},
+        {EXTCODESIZE, Homestead, 20},
+        {EXTCODESIZE, TangerineWhistle, 700},
+        {EXTCODESIZE, Berlin, 100},
+        {EXTCODEHASH, Constantinople, 400},
+        {EXTCODEHASH, Istanbul, 700},
+        {EXTCODEHASH, London, 100},
+        {SLOAD, Frontier, 50},
+        {SLOAD, TangerineWhistle, 200},
+        {SLOAD, Istanbul, 800},
+        {SLOAD, Berlin, 100},
+        {SSTORE, Istanbul, 0},
+        {CALL, Frontier, 40},
+        {CALL, TangerineWhistle, 700},
+        {STATICCALL, Byzantium, 700},
+        {DELEGATECALL, Berlin, 100},
+        {SELFDESTRUCT, Homestead, 0},
+        {SELFDESTRUCT, TangerineWhistle, 5000},
+        {PUSH0, Shanghai, 2},
+        {TLOAD, Cancun, 100},
+        {MCOPY, Cancun, 3},
+        {SLOAD, 0, 100},                // The zero fork is the latest
+    }
+
+    for _, tt := range tests {
+        if got := tt.op.StaticGas(tt.fork); got != tt.gas {
+            t.Errorf("%v under %v: got %d, want %d", tt.op, tt.fork, got, tt.gas)
+        }
+    }
+}
+
+func TestDynamicGas(t *testing.T) {
+    tests := []struct {
+        op OpCode
+        fork Fork
+        costs DynamicGas
+    }{
+        {ADD, Cancun, 0},
+        {SHA3, Frontier, GasMemory | GasHash},
+        {EXP, Frontier, GasExpByte},
+        {CALLDATACOPY, Frontier, GasMemory | GasCopy},
+        {EXTCODECOPY, Istanbul, GasMemory | GasCopy},
+        {EXTCODECOPY, Berlin, GasMemory | GasCopy | GasColdAccess},
+        {LOG1, Frontier, GasMemory | GasLogData},
+        {SLOAD, Istanbul, 0},
+        {SLOAD, Berlin, GasColdAccess},
+        {SSTORE, Istanbul, GasStorage},
+        {SSTORE, Berlin, GasStorage | GasColdAccess},
+        {CALL, Istanbul, GasMemory | GasCall},
+        {CALL, Berlin, GasMemory | GasCall | GasColdAccess},
+        {CREATE2, Constantinople, GasMemory | GasCreate | GasHash},
+        {SELFDESTRUCT, Homestead, 0},
+        {SELFDESTRUCT, TangerineWhistle, GasNewAccount},
+        {SELFDESTRUCT, Berlin, GasColdAccess | GasNewAccount},
+    }
+
+    for _, tt := range tests {
+        if got := tt.op.DynamicGas(tt.fork); got != tt.costs {
+            t.Errorf("%v under %v: got %v, want %v", tt.op, tt.fork, got, tt.costs)
+        }
+    }
+}
+
+func TestBlockGas(t *testing.T) {
+    tests := []struct {
+        code string
+        fork Fork
+        gas string
+    }{
+        // PUSH1 0, SLOAD, PUSH1 1, ADD, PUSH1 0, SSTORE, STOP
+        {"60005460010160005500", Frontier, "62 + storage"},
+        {"60005460010160005500", TangerineWhistle, "212 + storage"},
+        {"60005460010160005500", Istanbul, "812 + storage"},
+        {"60005460010160005500", Berlin, "112 + storage|cold-access"},
+        // PUSH1 32, PUSH1 0, SHA3, PUSH1 2, EXP, STOP
+        {"60206000206002" + "0a00", Frontier, "49 + memory|hash|exp-byte"},
+    }
+
+    for _, tt := range tests {
+        prog, err := NewProgramWithOptions(context.Background(), mustDecodeHex(t, tt.code), &Options{Fork: tt.fork})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := prog.BlockGas(prog.BlockAt(0)).String(); got != tt.gas {
+            t.Errorf("%v under %v: got %v, want %v", tt.code, tt.fork, got, tt.gas)
+        }
+    }
+}
+
+func TestMemoryGas(t *testing.T) {
+    tests := []struct {
+        words uint64
+        gas uint64
+    }{
+        {0, 0},
+        {1, 3},
+        {32, 98},
+        {1024, 5120},
+    }
+
+    for _, tt := range tests {
+        if got := memoryGas(tt.words); got != tt.gas {
+            t.Errorf("%d words: got %d, want %d", tt.words, got, tt.gas)
+        }
+    }
+}
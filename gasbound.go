@@ -0,0 +1,534 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "strings"
+)
+
+// Limits on the search for the most expensive path through a function
+const (
+    maxGasBoundStates = 20000
+    maxGasBoundDepth = 4096
+)
+
+// Memory extents at or above this are treated as unknown; expanding that far runs out of gas
+const maxMemoryExtent = 1 << 32
+
+// FunctionGas is an upper bound on the gas one call to an external function can use,
+// covering the dispatcher and the function body but not the intrinsic cost of the
+// transaction or refunds.
+type FunctionGas struct {
+    Function *ExternalFunction
+    Bounded bool                    // False if a loop or recursion makes the gas unbounded
+    Gas uint64                      // Upper bound on execution gas, if Bounded
+    Memory uint64                   // Part of Gas spent on memory expansion
+    Excluded DynamicGas             // Costs left out of Gas because their operands are not constant
+    Loop *Loop                      // Innermost loop that makes the function unbounded, if any
+    Reason string                   // Why the function is unbounded
+}
+
+func (self *FunctionGas) String() string {
+    if !self.Bounded {
+        return fmt.Sprintf("%v unbounded: %s", self.Function, self.Reason)
+    }
+    str := fmt.Sprintf("%v gas <= %d (memory %d)", self.Function, self.Gas, self.Memory)
+    if self.Excluded != 0 {
+        str += fmt.Sprintf(" excluding %v", self.Excluded)
+    }
+    return str
+}
+
+// FunctionGas bounds the gas used by a call to each external function, taking the most
+// expensive path from the start of the code through the dispatcher into the function.
+// Every state access is assumed cold and every SSTORE to set a slot. Memory expansion is
+// bounded by the largest constant extent the function touches; costs that depend on
+// operands that are not constant are listed in Excluded. Internal calls are followed
+// using the return addresses on the stack, so a function called from several places is
+// not mistaken for a loop.
+func (self *Program) FunctionGas() []*FunctionGas {
+    var ret []*FunctionGas
+    for _, fn := range self.ExternalFunctions() {
+        ret = append(ret, self.functionGas(fn))
+    }
+    return ret
+}
+
+func (self *Program) functionGas(fn *ExternalFunction) *FunctionGas {
+    b := &gasBounder{
+        prog: self,
+        fn: fn,
+        costs: make(map[*BasicBlock]uint64),
+        memo: make(map[string]uint64),
+        onPath: make(map[string]bool),
+    }
+    result := &FunctionGas{Function: fn}
+    if self.Status != Complete {
+        result.Reason = fmt.Sprintf("analysis incomplete: %v", self.Status)
+        return result
+    }
+    entry := self.BlockAt(0)
+    if entry == nil {
+        result.Reason = "no code"
+        return result
+    }
+
+    gas, ok := b.visit(entry, nil, 0)
+    if !ok {
+        result.Reason = b.reason
+        if b.loop != nil {
+            for _, loop := range self.Loops() {
+                if loop.Contains(b.loop) {
+                    result.Loop = loop
+                }
+            }
+            if result.Loop != nil {
+                result.Reason = result.Loop.String()
+            }
+        }
+        return result
+    }
+    if b.extent > 0 {
+        result.Memory = memoryGas(toWords(b.extent))
+    }
+    result.Bounded = true
+    result.Gas = gas + result.Memory
+    result.Excluded = b.excluded
+    return result
+}
+
+// gasBounder searches for the most expensive path through a function. States are a block
+// and an abstract stack that tracks which entries are code addresses, identified by the
+// PC of the PUSH that produced them; other entries are -1.
+type gasBounder struct {
+    prog *Program
+    fn *ExternalFunction
+    costs map[*BasicBlock]uint64            // Worst-case cost of each block, excluding memory expansion
+    memo map[string]uint64                  // Most expensive path from each state explored so far
+    onPath map[string]bool
+    states int
+    extent uint64                           // Largest memory extent any instruction reaches
+    excluded DynamicGas
+    loop *BasicBlock                        // A block the most expensive path returns to
+    reason string
+}
+
+// gasBoundKey identifies a state by its block and the code addresses on its stack, so that
+// a loop which grows the stack is still seen to return to the same state.
+func gasBoundKey(block *BasicBlock, stack []int) string {
+    var w strings.Builder
+    fmt.Fprintf(&w, "%d", block.ID)
+    for i, v := range stack {
+        if v >= 0 {
+            fmt.Fprintf(&w, ",%d:%d", i, v)
+        }
+    }
+    return w.String()
+}
+
+// visit returns the cost of the most expensive path starting at block, or false if
+// there is no bound.
+func (self *gasBounder) visit(block *BasicBlock, stack []int, depth int) (uint64, bool) {
+    key := gasBoundKey(block, stack)
+    if gas, ok := self.memo[key]; ok {
+        return gas, true
+    }
+    if self.onPath[key] {
+        self.loop = block
+        self.reason = fmt.Sprintf("loop at %v", block)
+        return 0, false
+    }
+    self.states += 1
+    if self.states > maxGasBoundStates || depth > maxGasBoundDepth {
+        self.reason = fmt.Sprintf("too many paths, or unbounded recursion, at %v", block)
+        return 0, false
+    }
+
+    self.onPath[key] = true
+    defer delete(self.onPath, key)
+
+    cost := self.blockCost(block)
+    worst := uint64(0)
+    for _, next := range self.successors(block, stack) {
+        gas, ok := self.visit(next.block, next.stack, depth + 1)
+        if !ok {
+            return 0, false
+        }
+        if gas > worst {
+            worst = gas
+        }
+    }
+    self.memo[key] = cost + worst
+    return cost + worst, true
+}
+
+func (self *gasBounder) blockCost(block *BasicBlock) uint64 {
+    if cost, ok := self.costs[block]; ok {
+        return cost
+    }
+    cost := uint64(0)
+    for pc := block.Start; pc <= block.End; {
+        inst, ok := self.prog.Instructions[pc]
+        if !ok {
+            break
+        }
+        cost += inst.Op.StaticGas(self.prog.Fork) + self.dynamicBound(pc)
+        pc += inst.Op.OperandSize() + 1
+    }
+    self.costs[block] = cost
+    return cost
+}
+
+// constant returns operand i of the instruction at pc if it is a constant that fits in
+// a uint64.
+func (self *gasBounder) constant(pc, i int) (uint64, bool) {
+    value := self.prog.OperandValue(pc, i)
+    if value == nil || !value.IsUint64() {
+        return 0, false
+    }
+    return value.Uint64(), true
+}
+
+// touch records the memory range given by the offset and size operands of the
+// instruction at pc, returning the size if it is constant.
+func (self *gasBounder) touch(pc, offset, size int) (uint64, bool) {
+    n, ok := self.constant(pc, size)
+    if !ok {
+        self.excluded |= GasMemory
+        return 0, false
+    }
+    if n == 0 {
+        return 0, true
+    }
+    start, ok := self.constant(pc, offset)
+    if !ok || start >= maxMemoryExtent || n >= maxMemoryExtent {
+        self.excluded |= GasMemory
+        return n, true
+    }
+    if start + n > self.extent {
+        self.extent = start + n
+    }
+    return n, true
+}
+
+// perWord returns the cost of an operation charging gas for each word of the size
+// operand of the instruction at pc, recording it as excluded if the size is not constant.
+func (self *gasBounder) perWord(pc, offset, size int, gas uint64, cost DynamicGas) uint64 {
+    n, ok := self.touch(pc, offset, size)
+    if !ok || n >= maxMemoryExtent {
+        self.excluded |= cost
+        return 0
+    }
+    return gas * toWords(n)
+}
+
+// dynamicBound returns the most the instruction at pc can cost on top of its static gas,
+// other than memory expansion, which is accounted for by the extent of the function.
+func (self *gasBounder) dynamicBound(pc int) uint64 {
+    fork := self.prog.Fork.resolve()
+    op := self.prog.Instructions[pc].Op
+    cold := uint64(0)
+    if fork >= Berlin {
+        cold = gasColdAccount - gasWarmAccess
+    }
+
+    switch op {
+    case SHA3:
+        return self.perWord(pc, 0, 1, gasSha3Word, GasHash)
+    case CALLDATACOPY, CODECOPY, RETURNDATACOPY:
+        return self.perWord(pc, 0, 2, gasCopy, GasCopy)
+    case MCOPY:
+        self.touch(pc, 1, 2)
+        return self.perWord(pc, 0, 2, gasCopy, GasCopy)
+    case EXTCODECOPY:
+        return cold + self.perWord(pc, 1, 3, gasCopy, GasCopy)
+    case MLOAD, MSTORE:
+        self.touchWord(pc, 32)
+    case MSTORE8:
+        self.touchWord(pc, 1)
+    case RETURN, REVERT:
+        self.touch(pc, 0, 1)
+    case LOG0, LOG1, LOG2, LOG3, LOG4:
+        n, ok := self.touch(pc, 0, 1)
+        if !ok || n >= maxMemoryExtent {
+            self.excluded |= GasLogData
+            return 0
+        }
+        return gasLogData * n
+    case EXP:
+        bytes := uint64(32)
+        if exponent := self.prog.OperandValue(pc, 1); exponent != nil {
+            bytes = uint64((exponent.BitLen() + 7) / 8)
+        }
+        return expByteGas(fork) * bytes
+    case SSTORE:
+        if fork >= Berlin {
+            return gasColdSload + gasSstoreSet
+        }
+        return gasSstoreSet
+    case SLOAD:
+        if fork >= Berlin {
+            return gasColdSload - gasWarmAccess
+        }
+    case BALANCE, EXTCODESIZE, EXTCODEHASH:
+        return cold
+    case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+        return cold + self.callBound(pc, op, fork)
+    case CREATE, CREATE2:
+        self.excluded |= GasCreate
+        cost := uint64(0)
+        if fork >= Shanghai {
+            cost += self.perWord(pc, 1, 2, gasInitCodeWord, GasCreate)
+        } else {
+            self.touch(pc, 1, 2)
+        }
+        if op == CREATE2 {
+            cost += self.perWord(pc, 1, 2, gasSha3Word, GasHash)
+        }
+        return cost
+    case SELFDESTRUCT:
+        if fork >= TangerineWhistle {
+            return cold + gasNewAccount
+        }
+    }
+    return 0
+}
+
+func (self *gasBounder) touchWord(pc int, size uint64) {
+    start, ok := self.constant(pc, 0)
+    if !ok || start >= maxMemoryExtent {
+        self.excluded |= GasMemory
+        return
+    }
+    if start + size > self.extent {
+        self.extent = start + size
+    }
+}
+
+// callBound returns the most a call can cost other than cold access and memory: value
+// transfer, creating the recipient and the gas forwarded to it, which is bounded only if
+// the gas operand is constant.
+func (self *gasBounder) callBound(pc int, op OpCode, fork Fork) uint64 {
+    args := 2
+    cost := uint64(0)
+    if op == CALL || op == CALLCODE {
+        args = 3
+        value := self.prog.OperandValue(pc, 2)
+        transfers := value == nil || value.Sign() != 0
+        if transfers {
+            cost += gasCallValue
+        }
+        if op == CALL && (transfers || fork < SpuriousDragon) {
+            cost += gasNewAccount
+        }
+    }
+    self.touch(pc, args, args + 1)
+    self.touch(pc, args + 2, args + 3)
+
+    if gas, ok := self.constant(pc, 0); ok {
+        cost += gas
+    } else {
+        self.excluded |= GasCall
+    }
+    return cost
+}
+
+type gasBoundState struct {
+    block *BasicBlock
+    stack []int
+}
+
+// successors returns the states that can follow block when it is entered with stack.
+func (self *gasBounder) successors(block *BasicBlock, stack []int) []gasBoundState {
+    prog := self.prog
+    stack = append([]int{}, stack...)
+    pop := func() int {
+        if len(stack) == 0 {
+            return -1
+        }
+        v := stack[len(stack) - 1]
+        stack = stack[:len(stack) - 1]
+        return v
+    }
+    at := func(depth int) int {
+        if depth >= len(stack) {
+            return -1
+        }
+        return stack[len(stack) - 1 - depth]
+    }
+
+    var last *Instruction
+    var target, lastPC int
+    for pc := block.Start; pc <= block.End; {
+        inst := prog.Instructions[pc]
+        last, lastPC = inst, pc
+        switch {
+        case inst.Op.IsDup():
+            stack = append(stack, at(int(inst.Op - DUP1)))
+        case inst.Op.IsSwap():
+            n := int(inst.Op - SWAP1) + 1
+            for len(stack) <= n {
+                stack = append([]int{-1}, stack...)
+            }
+            top := len(stack) - 1
+            stack[top], stack[top - n] = stack[top - n], stack[top]
+        case inst.Op == JUMP || inst.Op == JUMPI:
+            target = pop()
+            if inst.Op == JUMPI {
+                pop()
+            }
+        default:
+            for i := 0; i < inst.Op.StackReads(); i++ {
+                pop()
+            }
+            for i := 0; i < inst.Op.StackWrites(); i++ {
+                if self.prog.isCodeAddress(pc) {
+                    stack = append(stack, pc)
+                } else {
+                    stack = append(stack, -1)
+                }
+            }
+        }
+        pc += inst.Op.OperandSize() + 1
+    }
+
+    var ret []gasBoundState
+    add := func(next *BasicBlock) {
+        ret = append(ret, gasBoundState{next, stack})
+    }
+    fallthru := lastPC + last.Op.OperandSize() + 1
+    taken, notTaken := true, true
+    if last.Op == JUMPI {
+        if cond, ok := self.condition(lastPC); ok {
+            taken, notTaken = cond, !cond
+        }
+    }
+    if last.Op == JUMP || last.Op == JUMPI && taken {
+        if dest, ok := self.jumpTarget(target); ok {
+            add(dest)
+        } else {
+            for _, succ := range block.Successors {
+                if last.Op == JUMP || succ.Start != fallthru {
+                    add(succ)
+                }
+            }
+        }
+    }
+    if last.Op != JUMP && !endsBlock(last.Op, prog.Fork) || last.Op == JUMPI && notTaken {
+        for _, succ := range block.Successors {
+            if succ.Start == fallthru {
+                add(succ)
+            }
+        }
+    }
+    return ret
+}
+
+// jumpTarget returns the block addressed by the PUSH at source, if it is known.
+func (self *gasBounder) jumpTarget(source int) (*BasicBlock, bool) {
+    if source < 0 {
+        return nil, false
+    }
+    dest, ok := self.prog.jumpDest(self.prog.Instructions[source].Arg)
+    if !ok {
+        return nil, false
+    }
+    block := self.prog.BlockAt(dest)
+    return block, block != nil && block.Start == dest
+}
+
+// condition evaluates the condition of the JUMPI at pc for a call to the function being
+// bounded, if it compares the selector or the calldata size against a constant.
+func (self *gasBounder) condition(pc int) (bool, bool) {
+    source, ok := self.prog.OperandSource(pc, 1)
+    if !ok {
+        return false, false
+    }
+    negate := false
+    for self.prog.Instructions[source].Op == ISZERO {
+        if source, ok = self.prog.OperandSource(source, 0); !ok {
+            return false, false
+        }
+        negate = !negate
+    }
+
+    op := self.prog.Instructions[source].Op
+    switch op {
+    case EQ, XOR, SUB, LT, GT:
+    default:
+        return false, false
+    }
+    var a, b *big.Int
+    size := -1
+    selector := big.NewInt(int64(self.fn.Selector))
+    for i, v := range []**big.Int{&a, &b} {
+        *v = self.prog.OperandValue(source, i)
+        if *v != nil {
+            continue
+        }
+        other, ok := self.prog.OperandSource(source, i)
+        if !ok {
+            return false, false
+        }
+        switch {
+        case self.prog.readsSelector(other, 0):
+            *v = selector
+        case self.prog.Instructions[other].Op == CALLDATASIZE && (op == LT || op == GT):
+            size = i
+        default:
+            return false, false
+        }
+    }
+    if size >= 0 {
+        // Calls to a function include the selector, so the size is below a bound of at
+        // most 4 never, and above one of less than 4 always
+        bound := []**big.Int{&a, &b}[1 - size]
+        below := op == LT && size == 0 || op == GT && size == 1
+        if *bound == nil || below && (*bound).Cmp(big.NewInt(4)) > 0 || !below && (*bound).Cmp(big.NewInt(4)) >= 0 {
+            return false, false
+        }
+        *[]**big.Int{&a, &b}[size] = big.NewInt(4)
+    }
+
+    var result bool
+    switch op {
+    case EQ:
+        result = a.Cmp(b) == 0
+    case XOR, SUB:
+        result = a.Cmp(b) != 0
+    case LT:
+        result = a.Cmp(b) < 0
+    case GT:
+        result = a.Cmp(b) > 0
+    }
+    return result != negate, true
+}
+
+// functionBlocks returns the blocks a call to fn can execute, following the paths
+// FunctionGas bounds. It returns false with the blocks found so far if there are too many
+// paths to search.
+func (self *Program) functionBlocks(fn *ExternalFunction) (map[*BasicBlock]bool, bool) {
+    b := &gasBounder{prog: self, fn: fn}
+    blocks := make(map[*BasicBlock]bool)
+    entry := self.BlockAt(0)
+    if entry == nil {
+        return blocks, true
+    }
+    seen := make(map[string]bool)
+    worklist := []gasBoundState{{entry, nil}}
+    for len(worklist) > 0 {
+        state := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        key := gasBoundKey(state.block, state.stack)
+        if seen[key] {
+            continue
+        }
+        if len(seen) >= maxGasBoundStates || len(state.stack) > maxGasBoundDepth {
+            return blocks, false
+        }
+        seen[key] = true
+        blocks[state.block] = true
+        worklist = append(worklist, b.successors(state.block, state.stack)...)
+    }
+    return blocks, true
+}
@@ -0,0 +1,42 @@
+package evmopt
+
+import (
+    "encoding/hex"
+    "testing"
+)
+
+func TestFunctionGasCalldataSize(t *testing.T) {
+    code := loadContract(t, "vyper_style")
+    // The same dispatcher with calldatasize > 3 written as 3 < calldatasize
+    swapped := append([]byte{}, code...)
+    copy(swapped, []byte{byte(CALLDATASIZE), byte(PUSH1), 0x03, byte(LT)})
+
+    tests := []struct {
+        name string
+        code []byte
+        selector uint32
+        gas uint64
+        memory uint64
+    }{
+        {"vyper_style", code, 0x3ccfd60b, 38841, 0},
+        {"vyper_style", code, 0x8da5cb5b, 2220, 9},
+        {"swapped", swapped, 0x3ccfd60b, 38841, 0},
+        {"swapped", swapped, 0x8da5cb5b, 2220, 9},
+    }
+
+    for _, tt := range tests {
+        var found *FunctionGas
+        for _, fg := range NewProgram(tt.code).FunctionGas() {
+            if fg.Function.Selector == tt.selector {
+                found = fg
+            }
+        }
+        if found == nil {
+            t.Errorf("%v: no function 0x%08x in %v", tt.name, tt.selector, hex.EncodeToString(tt.code))
+            continue
+        }
+        if !found.Bounded || found.Gas != tt.gas || found.Memory != tt.memory {
+            t.Errorf("%v: got %v, want gas <= %d (memory %d)", tt.name, found, tt.gas, tt.memory)
+        }
+    }
+}
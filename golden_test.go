@@ -0,0 +1,286 @@
+package evmopt
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files from the current output")
+
+func sortedKeys(m map[int]bool) []int {
+    keys := make([]int, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Ints(keys)
+    return keys
+}
+
+// loadContract reads the bytecode of testdata/contracts/NAME.hex.
+func loadContract(t testing.TB, name string) []byte {
+    data, err := ioutil.ReadFile(filepath.Join("testdata", "contracts", name + ".hex"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    code, err := hex.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return code
+}
+
+func formatPCs(pcs []int) string {
+    strs := make([]string, len(pcs))
+    for i, pc := range pcs {
+        strs[i] = fmt.Sprintf("0x%X", pc)
+    }
+    return "[" + strings.Join(strs, " ") + "]"
+}
+
+func blockIDs(blocks []*BasicBlock) []int {
+    ids := make([]int, len(blocks))
+    for i, block := range blocks {
+        ids[i] = block.ID
+    }
+    return ids
+}
+
+func formatSources(reachedBy []map[int]bool) string {
+    operands := make([]string, len(reachedBy))
+    for i, sources := range reachedBy {
+        operands[i] = formatPCs(sortedKeys(sources))
+    }
+    return strings.Join(operands, " ")
+}
+
+// goldenOutput renders everything the golden files pin down: the disassembly, the basic
+// blocks and their edges, the loops, the reaching definitions of every operand, those that
+// differ between calling contexts in ctxProg, the recovered external and internal functions,
+// the gas bounds and the detectors' findings.
+func goldenOutput(prog, ctxProg *Program) string {
+    var w strings.Builder
+    fmt.Fprintf(&w, "status: %v\n", prog.Status)
+    for _, d := range prog.Diagnostics {
+        fmt.Fprintf(&w, "diagnostic: %v\n", d)
+    }
+
+    w.WriteString("\n# Disassembly\n")
+    for _, pc := range prog.PCs() {
+        inst := prog.Instructions[pc]
+        if inst.Source != nil {
+            fmt.Fprintf(&w, "0x%X\t%v\t%s %v\n", pc, inst, prog.SourceFile(inst.Source), inst.Source)
+        } else {
+            fmt.Fprintf(&w, "0x%X\t%v\n", pc, inst)
+        }
+    }
+
+    w.WriteString("\n# Blocks\n")
+    for _, block := range prog.Blocks {
+        succs := make([]int, len(block.Successors))
+        for i, succ := range block.Successors {
+            succs[i] = succ.ID
+        }
+        fmt.Fprintf(&w, "%v reachable=%v successors=%v\n", block, block.Reachable, succs)
+    }
+
+    w.WriteString("\n# Loops\n")
+    for _, loop := range prog.Loops() {
+        fmt.Fprintf(&w, "%s%v entries=%v back=%v exits=%v\n", strings.Repeat("  ", loop.Depth - 1), loop, blockIDs(loop.Entries), loop.BackEdges, blockIDs(loop.Exits))
+    }
+
+    w.WriteString("\n# Reaching definitions\n")
+    for _, pc := range prog.PCs() {
+        inst := prog.Instructions[pc]
+        if len(inst.ReachedBy) == 0 {
+            continue
+        }
+        fmt.Fprintf(&w, "0x%X\t%v\t%s\n", pc, inst.Op, formatSources(inst.ReachedBy))
+    }
+
+    w.WriteString("\n# Reaching definitions by calling context\n")
+    for _, pc := range ctxProg.PCs() {
+        contexts := ctxProg.ReachingContexts(pc)
+        differ := false
+        for _, c := range contexts {
+            differ = differ || formatSources(c.ReachedBy) != formatSources(contexts[0].ReachedBy)
+        }
+        if !differ {
+            continue
+        }
+        for _, c := range contexts {
+            fmt.Fprintf(&w, "0x%X\t%v\t%s\t%s\n", pc, ctxProg.Instructions[pc].Op, formatPCs(c.Context), formatSources(c.ReachedBy))
+        }
+    }
+
+    w.WriteString("\n# External functions\n")
+    for _, fn := range prog.ExternalFunctions() {
+        fmt.Fprintf(&w, "0x%08x entry=0x%X dispatch=0x%X\n", fn.Selector, fn.Entry, fn.Dispatch)
+    }
+
+    w.WriteString("\n# Internal functions\n")
+    for _, fn := range prog.InternalFunctions() {
+        fmt.Fprintf(&w, "%v exits=%s\n", fn, formatPCs(fn.Exits))
+        for _, call := range fn.CallSites {
+            fmt.Fprintf(&w, "  call 0x%X push=0x%X return=0x%X", call.Jump, call.Push, call.Return)
+            if call.Caller >= 0 {
+                fmt.Fprintf(&w, " from 0x%X", call.Caller)
+            }
+            w.WriteString("\n")
+        }
+    }
+
+    w.WriteString("\n# Gas bounds\n")
+    for _, bound := range prog.FunctionGas() {
+        fmt.Fprintf(&w, "%v\n", bound)
+    }
+
+    w.WriteString("\n# Findings\n")
+    for _, finding := range prog.Detect(nil) {
+        fmt.Fprintf(&w, "%v\n", finding)
+    }
+
+    w.WriteString("\n# Events\n")
+    for _, event := range prog.Events() {
+        fmt.Fprintf(&w, "%v\n", event.Format(StandardEvents()))
+    }
+
+    w.WriteString("\n# External calls\n")
+    for _, call := range prog.ExternalCalls() {
+        fmt.Fprintf(&w, "%v\n", call)
+    }
+
+    w.WriteString("\n# Interfaces\n")
+    for _, conformance := range prog.Conformance(nil) {
+        fmt.Fprintf(&w, "%v\n", conformance)
+    }
+
+    w.WriteString("\n# Proxy\n")
+    if proxy := prog.Proxy(); proxy != nil {
+        fmt.Fprintf(&w, "%v\n", proxy)
+    }
+    return w.String()
+}
+
+// TestGolden compares the analysis of every contract in testdata/contracts with the
+// matching file in testdata/golden, applying the contract's source map if it has one.
+// Run with -update to regenerate them.
+func TestGolden(t *testing.T) {
+    paths, err := filepath.Glob("testdata/contracts/*.hex")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(paths) == 0 {
+        t.Fatal("no contracts in testdata/contracts")
+    }
+
+    for _, path := range paths {
+        name := strings.TrimSuffix(filepath.Base(path), ".hex")
+        t.Run(name, func(t *testing.T) {
+            data, err := ioutil.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            code, err := hex.DecodeString(strings.TrimSpace(string(data)))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            ctxProg, _ := NewProgramWithOptions(context.Background(), code, &Options{ContextDepth: 2})
+            prog := NewProgram(code)
+            if srcmap, err := ioutil.ReadFile(strings.TrimSuffix(path, ".hex") + ".srcmap"); err == nil {
+                if err := prog.SetSourceMap(string(srcmap), []string{name + ".sol"}); err != nil {
+                    t.Fatal(err)
+                }
+            } else if !os.IsNotExist(err) {
+                t.Fatal(err)
+            }
+            got := goldenOutput(prog, ctxProg)
+            golden := filepath.Join("testdata", "golden", name + ".txt")
+            if *update {
+                if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+                    t.Fatal(err)
+                }
+                return
+            }
+            want, err := ioutil.ReadFile(golden)
+            if err != nil {
+                t.Fatalf("%v; run with -update to create it", err)
+            }
+            if got != string(want) {
+                t.Errorf("output differs from %v; run with -update if the change is intended\n%s", golden, lineDiff(string(want), got))
+            }
+        })
+    }
+}
+
+// lineDiff returns the first few lines that differ between want and got.
+func lineDiff(want, got string) string {
+    a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+    var w strings.Builder
+    shown := 0
+    for i := 0; (i < len(a) || i < len(b)) && shown < 10; i++ {
+        var x, y string
+        if i < len(a) {
+            x = a[i]
+        }
+        if i < len(b) {
+            y = b[i]
+        }
+        if x != y {
+            fmt.Fprintf(&w, "line %d:\n- %s\n+ %s\n", i + 1, x, y)
+            shown += 1
+        }
+    }
+    return w.String()
+}
+
+// TestCompiledRuntime checks that the compiled runtime code is the part of the creation
+// code its constructor copies out, rather than the output of running it.
+func TestCompiledRuntime(t *testing.T) {
+    creation, runtime := loadContract(t, "ens_registry_init"), loadContract(t, "ens_registry")
+    if len(creation) != 0x62 + 0x503 || !bytes.Equal(creation[0x62:], runtime) {
+        t.Errorf("ens_registry is not the 0x503 bytes at 0x62 of ens_registry_init")
+    }
+}
+
+// TestCompiledSelectors checks that the external functions recovered from compiler
+// output are exactly those in the ABI published with it.
+func TestCompiledSelectors(t *testing.T) {
+    tests := []struct {
+        name string
+        functions []string
+    }{
+        {"ens_registry", []string{"resolver(bytes32)", "owner(bytes32)", "setSubnodeOwner(bytes32,bytes32,address)", "setTTL(bytes32,uint64)", "ttl(bytes32)", "setResolver(bytes32,address)", "setOwner(bytes32,address)"}},
+        {"ens_resolver", []string{"supportsInterface(bytes4)", "ABI(bytes32,uint256)", "setPubkey(bytes32,bytes32,bytes32)", "content(bytes32)", "addr(bytes32)", "setABI(bytes32,uint256,bytes)", "name(bytes32)", "setName(bytes32,string)", "setContent(bytes32,bytes32)", "pubkey(bytes32)", "setAddr(bytes32,address)",
+            "text(bytes32,string)", "setText(bytes32,string,string)"}},     // Missing from ethgo's Resolver.abi
+
+    }
+
+    for _, tt := range tests {
+        want := make(map[uint32]bool)
+        for _, signature := range tt.functions {
+            want[Selector(signature)] = true
+        }
+        got := make(map[uint32]bool)
+        for _, fn := range NewProgram(loadContract(t, tt.name)).ExternalFunctions() {
+            got[fn.Selector] = true
+            if !want[fn.Selector] {
+                t.Errorf("%v: unexpected function %08x", tt.name, fn.Selector)
+            }
+        }
+        for _, signature := range tt.functions {
+            if !got[Selector(signature)] {
+                t.Errorf("%v: missing %v", tt.name, signature)
+            }
+        }
+    }
+}
@@ -0,0 +1,263 @@
+package evmopt
+
+import (
+    _ "embed"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "math/big"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Interface is a standard interface a contract may implement, as described in
+// interfaces.json.
+type Interface struct {
+    Name string `json:"name"`
+    InterfaceID string `json:"interfaceId,omitempty"`    // ERC-165 identifier as hex, if it has one
+    Functions []string `json:"functions"`                // Signatures of the required functions
+    Optional []string `json:"optional,omitempty"`        // Signatures of functions it may leave out
+    Events []EventSignature `json:"events,omitempty"`
+    Requires []string `json:"requires,omitempty"`        // Names of interfaces it extends, whose members it requires too
+}
+
+// EventSignature is an event by its signature and the number of its arguments that are
+// indexed, and so passed as topics after the signature's hash.
+type EventSignature struct {
+    Signature string `json:"signature"`
+    Indexed int `json:"indexed"`
+}
+
+// Conformance is how closely a contract matches an interface.
+type Conformance struct {
+    Interface string
+    Confidence float64              // Fraction of the required functions and events found
+    Advertised bool                 // The interface's ERC-165 identifier appears as a constant
+    Missing []string                // Required functions and events not found
+    Optional []string               // Optional functions found
+}
+
+func (self Conformance) String() string {
+    ret := fmt.Sprintf("%v: %.0f%%", self.Interface, self.Confidence * 100)
+    if self.Advertised {
+        ret += ", advertised"
+    }
+    if len(self.Missing) > 0 {
+        ret += "; missing " + strings.Join(self.Missing, ", ")
+    }
+    if len(self.Optional) > 0 {
+        ret += "; optional " + strings.Join(self.Optional, ", ")
+    }
+    return ret
+}
+
+// Minimum confidence for an interface the contract does not advertise to be reported
+const minConfidence = 0.5
+
+//go:embed interfaces.json
+var interfacesJSON []byte
+
+// Interfaces holds the built in standard interfaces: ERC-165, ERC-20, ERC-721,
+// ERC-1155, ERC-4626 and ERC-2612.
+var Interfaces = mustParseInterfaces(interfacesJSON)
+
+func mustParseInterfaces(data []byte) []Interface {
+    interfaces, err := parseInterfaces(data, nil)
+    if err != nil {
+        panic(fmt.Sprintf("interfaces.json: %v", err))
+    }
+    return interfaces
+}
+
+// ParseInterfaces decodes a JSON list of interfaces in the format of interfaces.json.
+// They may extend the built in Interfaces as well as each other.
+func ParseInterfaces(data []byte) ([]Interface, error) {
+    return parseInterfaces(data, Interfaces)
+}
+
+func parseInterfaces(data []byte, builtin []Interface) ([]Interface, error) {
+    var interfaces []Interface
+    if err := json.Unmarshal(data, &interfaces); err != nil {
+        return nil, err
+    }
+    names := interfaceNames(builtin, interfaces)
+    for _, iface := range interfaces {
+        if iface.Name == "" {
+            return nil, fmt.Errorf("interface without a name")
+        }
+        if _, err := iface.id(); err != nil {
+            return nil, fmt.Errorf("%v: %v", iface.Name, err)
+        }
+        if len(iface.Functions) + len(iface.Events) == 0 {
+            return nil, fmt.Errorf("%v has no required functions or events", iface.Name)
+        }
+        for _, name := range iface.Requires {
+            if _, ok := names[name]; !ok {
+                return nil, fmt.Errorf("%v requires unknown interface %v", iface.Name, name)
+            }
+        }
+    }
+    return interfaces, nil
+}
+
+// LoadInterfaces reads a JSON list of interfaces in the format of interfaces.json.
+func LoadInterfaces(path string) ([]Interface, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    interfaces, err := ParseInterfaces(data)
+    if err != nil {
+        return nil, fmt.Errorf("%v: %v", path, err)
+    }
+    return interfaces, nil
+}
+
+// interfaceNames indexes builtin and then interfaces by name, so that interfaces can
+// extend both the built in ones and each other.
+func interfaceNames(builtin, interfaces []Interface) map[string]Interface {
+    ret := make(map[string]Interface)
+    for _, iface := range append(append([]Interface{}, builtin...), interfaces...) {
+        ret[iface.Name] = iface
+    }
+    return ret
+}
+
+// members returns the functions and events the interface requires, including those of
+// the interfaces it extends, and the optional functions that are not also required.
+func (self Interface) members(names map[string]Interface) (functions []string, events []EventSignature, optional []string) {
+    seen := make(map[string]bool)
+    required := make(map[string]bool)
+    var add func(iface Interface)
+    add = func(iface Interface) {
+        if seen[iface.Name] {
+            return
+        }
+        seen[iface.Name] = true
+        for _, signature := range iface.Functions {
+            if !required[signature] {
+                required[signature] = true
+                functions = append(functions, signature)
+            }
+        }
+        events = append(events, iface.Events...)
+        for _, name := range iface.Requires {
+            add(names[name])
+        }
+    }
+    add(self)
+    for _, signature := range self.Optional {
+        if !required[signature] {
+            optional = append(optional, signature)
+        }
+    }
+    return functions, events, optional
+}
+
+// id returns the interface's ERC-165 identifier, or nil if it has none.
+func (self Interface) id() (*big.Int, error) {
+    if self.InterfaceID == "" {
+        return nil, nil
+    }
+    id, err := strconv.ParseUint(strings.TrimPrefix(self.InterfaceID, "0x"), 16, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid interface ID %q", self.InterfaceID)
+    }
+    return new(big.Int).SetUint64(id), nil
+}
+
+// Selector returns the function selector of signature, the first four bytes of its hash.
+func Selector(signature string) uint32 {
+    hash := Keccak256([]byte(signature))
+    return binary.BigEndian.Uint32(hash[:4])
+}
+
+// Topic returns the topic of an event with signature, its hash.
+func Topic(signature string) *big.Int {
+    hash := Keccak256([]byte(signature))
+    return new(big.Int).SetBytes(hash[:])
+}
+
+// Conformance matches the contract's external functions and emitted events against
+// interfaces, or the built in Interfaces if nil. The members of the interfaces an
+// interface extends count as its own. It returns the interfaces the contract
+// advertises with ERC-165 or matches at least half of, best match first.
+func (self *Program) Conformance(interfaces []Interface) []Conformance {
+    if interfaces == nil {
+        interfaces = Interfaces
+    }
+    selectors := make(map[uint32]bool)
+    for _, fn := range self.ExternalFunctions() {
+        selectors[fn.Selector] = true
+    }
+    events := self.eventTopics()
+    constants := self.constants()
+
+    names := interfaceNames(Interfaces, interfaces)
+
+    var ret []Conformance
+    for _, iface := range interfaces {
+        result := Conformance{Interface: iface.Name}
+        functions, signatures, optional := iface.members(names)
+        for _, signature := range functions {
+            if !selectors[Selector(signature)] {
+                result.Missing = append(result.Missing, signature)
+            }
+        }
+        for _, event := range signatures {
+            if !events[eventKey{Topic(event.Signature).String(), event.Indexed + 1}] {
+                result.Missing = append(result.Missing, fmt.Sprintf("event %v", event.Signature))
+            }
+        }
+        for _, signature := range optional {
+            if selectors[Selector(signature)] {
+                result.Optional = append(result.Optional, signature)
+            }
+        }
+        required := len(functions) + len(signatures)
+        result.Confidence = float64(required - len(result.Missing)) / float64(required)
+
+        if id, _ := iface.id(); id != nil {
+            // Solidity compares bytes4 values aligned to the left of the word
+            result.Advertised = constants[id.String()] || constants[new(big.Int).Lsh(id, 224).String()]
+        }
+        if result.Advertised || result.Confidence >= minConfidence {
+            ret = append(ret, result)
+        }
+    }
+    sort.SliceStable(ret, func(i, j int) bool { return ret[i].Confidence > ret[j].Confidence })
+    return ret
+}
+
+// eventKey identifies an event emitted by its topic and the number of topics logged.
+type eventKey struct {
+    topic string
+    topics int
+}
+
+// eventTopics returns the events logged by reachable LOG instructions with a constant
+// first topic.
+func (self *Program) eventTopics() map[eventKey]bool {
+    ret := make(map[eventKey]bool)
+    for _, event := range self.logEvents() {
+        if event.Topic != nil {
+            ret[eventKey{event.Topic.String(), event.Topics}] = true
+        }
+    }
+    return ret
+}
+
+// constants returns the values of the reachable PUSH instructions.
+func (self *Program) constants() map[string]bool {
+    ret := make(map[string]bool)
+    for _, pc := range self.PCs() {
+        inst := self.Instructions[pc]
+        if block := self.BlockAt(pc); block == nil || !block.Reachable || !inst.Op.IsPush() || inst.Arg == nil || inst.Symbol != "" {
+            continue
+        }
+        ret[inst.Arg.String()] = true
+    }
+    return ret
+}
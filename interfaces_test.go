@@ -0,0 +1,51 @@
+package evmopt
+
+import (
+    "strings"
+    "testing"
+)
+
+// TestInterfaceIDs checks each ERC-165 identifier in interfaces.json against the XOR of
+// the selectors of the interface's required functions, as ERC-165 defines it.
+func TestInterfaceIDs(t *testing.T) {
+    for _, iface := range Interfaces {
+        id, err := iface.id()
+        if err != nil || id == nil {
+            continue
+        }
+        var want uint32
+        for _, signature := range iface.Functions {
+            want ^= Selector(signature)
+        }
+        if uint32(id.Uint64()) != want {
+            t.Errorf("%v has interface ID %v; its functions give 0x%08x", iface.Name, iface.InterfaceID, want)
+        }
+    }
+}
+
+func TestConformanceMembers(t *testing.T) {
+    interfaces, err := ParseInterfaces([]byte(`[
+        {"name": "Base", "functions": ["supportsInterface(bytes4)", "name()"]},
+        {"name": "Extended", "functions": ["ownerOf(uint256)"], "optional": ["balanceOf(address)", "name()", "symbol()"], "requires": ["Base"]},
+        {"name": "Builtin", "functions": ["ownerOf(uint256)", "tokenURI(uint256)"], "requires": ["ERC-165"]}
+    ]`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, conformance := range NewProgram(loadContract(t, "erc721_partial")).Conformance(interfaces) {
+        got = append(got, conformance.String())
+    }
+    want := []string{
+        "Extended: 67%; missing name(); optional balanceOf(address)",
+        "Builtin: 67%; missing tokenURI(uint256)",
+        "Base: 50%; missing name()",
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+
+    if _, err := ParseInterfaces([]byte(`[{"name": "A", "functions": ["f()"], "requires": ["B"]}]`)); err == nil {
+        t.Errorf("interface requiring an unknown interface was accepted")
+    }
+}
@@ -0,0 +1,304 @@
+package evmopt
+
+import (
+    "fmt"
+    "sort"
+)
+
+// InternalFunction is a subroutine reached by pushing a return address, then the
+// function's arguments, and jumping to its entry. It returns by jumping to the address,
+// with its results in place of the arguments.
+type InternalFunction struct {
+    Entry int                       // PC of the JUMPDEST that starts the function
+    Args int                        // Stack items above the return address on entry
+    Returns int                     // Stack items left in place of the arguments and return address
+    Exits []int                     // PCs of the JUMPs that return to the caller
+    CallSites []*CallSite
+}
+
+func (self *InternalFunction) String() string {
+    return fmt.Sprintf("internal_%X(%d) -> %d", self.Entry, self.Args, self.Returns)
+}
+
+// CallSite is a call to an internal function.
+type CallSite struct {
+    Callee int                      // Entry of the function called
+    Caller int                      // Entry of the internal function making the call, or -1 outside any
+    Push int                        // PC of the PUSH of the return address
+    Jump int                        // PC of the JUMP to the function
+    Return int                      // PC the function returns to
+}
+
+// Slots of the abstract stack used to find internal functions hold the PC of the PUSH
+// of a code address, unknownSlot, or entrySlot(n) for the item at depth n on entry.
+const unknownSlot = -1
+
+func entrySlot(n int) int {
+    return -2 - n
+}
+
+func isEntrySlot(slot int) (int, bool) {
+    return -2 - slot, slot <= -2
+}
+
+type abstractStack struct {
+    items []int                     // Bottom first
+    consumed int                    // Entry items brought into items so far
+}
+
+func (self *abstractStack) copy() *abstractStack {
+    return &abstractStack{append([]int{}, self.items...), self.consumed}
+}
+
+// ensure brings entry items into the stack until it holds at least n.
+func (self *abstractStack) ensure(n int) {
+    for len(self.items) < n {
+        self.items = append([]int{entrySlot(self.consumed)}, self.items...)
+        self.consumed += 1
+    }
+}
+
+func (self *abstractStack) pop() int {
+    self.ensure(1)
+    slot := self.items[len(self.items) - 1]
+    self.items = self.items[:len(self.items) - 1]
+    return slot
+}
+
+func (self *abstractStack) push(slot int) {
+    self.items = append(self.items, slot)
+}
+
+// height returns the size of the stack relative to its size on entry.
+func (self *abstractStack) height() int {
+    return len(self.items) - self.consumed
+}
+
+// functionFinder walks the code from each entry point with an abstract stack, treating
+// a jump made while a code address pushed by the same function is on the stack as a call
+// that returns to that address. If a source map gives the jump's type, only jumps into a
+// function are calls.
+type functionFinder struct {
+    prog *Program
+    functions map[int]*InternalFunction
+    failed map[int]bool                     // Entries that are not functions
+    active map[int]bool                     // Entries being walked, to reject recursion
+}
+
+// InternalFunctions recovers the internal functions called from code reachable from PC
+// 0, ordered by entry. Recursive functions, and those whose stack use is not the same on
+// every path, are not recognised.
+func (self *Program) InternalFunctions() []*InternalFunction {
+    finder := &functionFinder{
+        prog: self,
+        functions: make(map[int]*InternalFunction),
+        failed: make(map[int]bool),
+        active: make(map[int]bool),
+    }
+    if self.BlockAt(0) != nil {
+        finder.walk(0, -1)
+    }
+
+    var ret []*InternalFunction
+    for _, fn := range finder.functions {
+        if len(fn.CallSites) > 0 {
+            sort.Slice(fn.CallSites, func(i, j int) bool { return fn.CallSites[i].Jump < fn.CallSites[j].Jump })
+            ret = append(ret, fn)
+        }
+    }
+    sort.Slice(ret, func(i, j int) bool { return ret[i].Entry < ret[j].Entry })
+    return ret
+}
+
+// isCodeAddress returns true if the value pushed at pc is used as a jump destination.
+func (self *Program) isCodeAddress(pc int) bool {
+    inst := self.Instructions[pc]
+    if !inst.Op.IsPush() || inst.Symbol != "" {
+        return false
+    }
+    for target := range inst.Reaches {
+        jump := self.Instructions[target]
+        if (jump.Op == JUMP || jump.Op == JUMPI) && jump.ReachedBy[0][pc] {
+            return true
+        }
+    }
+    return false
+}
+
+// blockOf returns the block that starts at the address pushed at pc, if any.
+func (self *functionFinder) blockOf(slot int) *BasicBlock {
+    if slot < 0 {
+        return nil
+    }
+    dest, ok := self.prog.jumpDest(self.prog.Instructions[slot].Arg)
+    if !ok {
+        return nil
+    }
+    return self.prog.BlockAt(dest)
+}
+
+// summary returns the internal function starting at entry, walking it if it has not
+// been seen before, or nil if entry does not start a function.
+func (self *functionFinder) summary(entry int) *InternalFunction {
+    if fn, ok := self.functions[entry]; ok {
+        return fn
+    }
+    if self.failed[entry] || self.active[entry] {
+        return nil
+    }
+    self.active[entry] = true
+    fn := self.walk(entry, entry)
+    delete(self.active, entry)
+    if fn == nil {
+        self.failed[entry] = true
+        return nil
+    }
+    self.functions[entry] = fn
+    return fn
+}
+
+type walkState struct {
+    block *BasicBlock
+    stack *abstractStack
+}
+
+// walk follows the code from start. If start is the entry of a function it returns the
+// function, or nil if the code does not return consistently to an address that was on
+// the stack on entry. The calls made are recorded only if the walk succeeds, since
+// otherwise the code will be walked again as part of its caller.
+func (self *functionFinder) walk(start int, entry int) *InternalFunction {
+    prog := self.prog
+    var fn *InternalFunction
+    if entry >= 0 {
+        fn = &InternalFunction{Entry: entry, Args: -1}
+    }
+    var calls []*CallSite
+    consistent := true
+    // Stack height on entry to each block visited; a function that reaches a block again
+    // at another height does not use the stack consistently
+    heights := make(map[*BasicBlock]int)
+    worklist := []walkState{{prog.BlockAt(start), &abstractStack{}}}
+    for len(worklist) > 0 {
+        state := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        block, stack := state.block, state.stack
+        if block == nil {
+            continue
+        }
+        if height, ok := heights[block]; ok {
+            consistent = consistent && height == stack.height()
+            continue
+        }
+        heights[block] = stack.height()
+
+        for pc := block.Start; pc < block.End; pc += prog.Instructions[pc].Op.OperandSize() + 1 {
+            self.step(pc, stack)
+        }
+        last := prog.Instructions[block.End]
+        next := block.End + last.Op.OperandSize() + 1
+        switch last.Op {
+        case JUMP, JUMPI:
+            target := stack.pop()
+            if last.Op == JUMPI {
+                stack.pop()
+                worklist = append(worklist, walkState{prog.BlockAt(next), stack.copy()})
+            }
+            if n, ok := isEntrySlot(target); ok {
+                // Return to the caller
+                if fn == nil {
+                    continue
+                }
+                returns := stack.height() + n + 1
+                if fn.Args == -1 {
+                    fn.Args, fn.Returns = n, returns
+                }
+                consistent = consistent && fn.Args == n && fn.Returns == returns
+                fn.Exits = append(fn.Exits, block.End)
+                continue
+            }
+            dest := self.blockOf(target)
+            if dest == nil {
+                continue
+            }
+            if last.Op == JUMP && (last.Source == nil || last.Source.Jump != '-') {
+                if call, ret, ok := self.call(block, dest, stack, entry); ok {
+                    calls = append(calls, call)
+                    worklist = append(worklist, ret)
+                    continue
+                }
+            }
+            worklist = append(worklist, walkState{dest, stack.copy()})
+        default:
+            self.step(block.End, stack)
+            if !endsBlock(last.Op, prog.Fork) {
+                worklist = append(worklist, walkState{prog.BlockAt(next), stack})
+            }
+        }
+    }
+
+    if fn != nil && (fn.Args == -1 || !consistent) {
+        return nil
+    }
+    for _, call := range calls {
+        callee := self.functions[call.Callee]
+        callee.CallSites = append(callee.CallSites, call)
+    }
+    if fn != nil {
+        sort.Ints(fn.Exits)
+    }
+    return fn
+}
+
+// call checks whether the jump ending block is a call to dest, which it is if a code
+// address is on the stack at the depth dest expects its return address, returning the
+// state to continue from after the call if so.
+func (self *functionFinder) call(block, dest *BasicBlock, stack *abstractStack, caller int) (*CallSite, walkState, bool) {
+    push := -1
+    for i := len(stack.items) - 1; i >= 0; i-- {
+        if slot := stack.items[i]; slot >= 0 && self.blockOf(slot) != nil {
+            push = i
+            break
+        }
+    }
+    if push == -1 {
+        return nil, walkState{}, false
+    }
+    fn := self.summary(dest.Start)
+    if fn == nil || fn.Args != len(stack.items) - 1 - push {
+        return nil, walkState{}, false
+    }
+
+    slot := stack.items[push]
+    ret := self.blockOf(slot)
+    call := &CallSite{Callee: fn.Entry, Caller: caller, Push: slot, Jump: block.End, Return: ret.Start}
+    after := &abstractStack{append([]int{}, stack.items[:push]...), stack.consumed}
+    for i := 0; i < fn.Returns; i++ {
+        after.push(unknownSlot)
+    }
+    return call, walkState{ret, after}, true
+}
+
+// step applies the effect of the instruction at pc to stack.
+func (self *functionFinder) step(pc int, stack *abstractStack) {
+    op := self.prog.Instructions[pc].Op
+    switch {
+    case op.IsDup():
+        n := int(op - DUP1) + 1
+        stack.ensure(n)
+        stack.push(stack.items[len(stack.items) - n])
+    case op.IsSwap():
+        n := int(op - SWAP1) + 1
+        stack.ensure(n + 1)
+        top := len(stack.items) - 1
+        stack.items[top], stack.items[top - n] = stack.items[top - n], stack.items[top]
+    case op.IsPush() && self.prog.isCodeAddress(pc):
+        stack.push(pc)
+    default:
+        for i := 0; i < op.StackReads(); i++ {
+            stack.pop()
+        }
+        for i := 0; i < op.StackWrites(); i++ {
+            stack.push(unknownSlot)
+        }
+    }
+}
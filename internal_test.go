@@ -0,0 +1,30 @@
+package evmopt
+
+import (
+    "testing"
+)
+
+func TestInternalFunctionsStackHeight(t *testing.T) {
+    tests := []struct {
+        name string
+        code string
+        entries []int               // Entries of the functions recognised
+    }{
+        // f(calldatasize()) returning to 0x9; both paths through f reach the join at 0x11
+        // with the same stack
+        {"consistent", "600936600b56000000" + "5b00" + "5b601157" + "5b5b" + "5b56", []int{0xB}},
+        // as above, but the path that falls through pushes a value the other does not,
+        // so the blocks after the join see two stack heights
+        {"inconsistent", "600936600b56000000" + "5b00" + "5b601157" + "6000" + "5b56", nil},
+    }
+
+    for _, tt := range tests {
+        var entries []int
+        for _, fn := range NewProgram(mustDecodeHex(t, tt.code)).InternalFunctions() {
+            entries = append(entries, fn.Entry)
+        }
+        if formatPCs(entries) != formatPCs(tt.entries) {
+            t.Errorf("%v: got functions at %v, want %v", tt.name, formatPCs(entries), formatPCs(tt.entries))
+        }
+    }
+}
@@ -0,0 +1,1152 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+)
+
+// HaltReason records why execution of a call stopped.
+type HaltReason int
+
+const (
+    HaltStop HaltReason = iota
+    HaltReturn
+    HaltSelfDestruct
+    HaltRevert
+    HaltOutOfGas
+    HaltInvalidOpcode
+    HaltStackUnderflow
+    HaltStackOverflow
+    HaltInvalidJump
+    HaltWriteProtection             // State modification attempted inside STATICCALL
+    HaltReturnDataOutOfBounds
+    HaltInsufficientBalance         // The caller cannot afford the transaction's value
+    HaltCreateCollision             // CREATE or CREATE2 targeted an address already in use
+    HaltInvalidCode                 // Init code returned code that may not be deployed
+    HaltUnsupported                 // A precompile the interpreter does not implement was called
+    HaltPrecompileFailure           // A precompile rejected its input
+)
+
+var haltReasonToString = map[HaltReason]string{
+    HaltStop: "stop",
+    HaltReturn: "return",
+    HaltSelfDestruct: "selfdestruct",
+    HaltRevert: "revert",
+    HaltOutOfGas: "out of gas",
+    HaltInvalidOpcode: "invalid opcode",
+    HaltStackUnderflow: "stack underflow",
+    HaltStackOverflow: "stack overflow",
+    HaltInvalidJump: "invalid jump destination",
+    HaltWriteProtection: "write protection",
+    HaltReturnDataOutOfBounds: "return data out of bounds",
+    HaltInsufficientBalance: "insufficient balance",
+    HaltCreateCollision: "contract address collision",
+    HaltInvalidCode: "invalid contract code",
+    HaltUnsupported: "unsupported precompile",
+    HaltPrecompileFailure: "precompile failure",
+}
+
+func (self HaltReason) String() string {
+    if str, ok := haltReasonToString[self]; ok {
+        return str
+    }
+    return fmt.Sprintf("unknown halt reason %d", int(self))
+}
+
+// Success returns true if the call completed without reverting or failing.
+func (self HaltReason) Success() bool {
+    return self == HaltStop || self == HaltReturn || self == HaltSelfDestruct
+}
+
+// Message is the call a transaction makes into the program.
+type Message struct {
+    Caller Address
+    Origin Address                  // Zero to use Caller
+    To Address
+    Value *big.Int                  // Nil for no value
+    Data []byte
+    Gas uint64
+    GasPrice *big.Int
+}
+
+type Log struct {
+    Address Address
+    Topics []Word
+    Data []byte
+}
+
+type StorageWrite struct {
+    Address Address
+    Key Word
+    Value Word
+}
+
+type ExecutionResult struct {
+    Halt HaltReason
+    ReturnData []byte
+    Logs []Log
+    StorageWrites []StorageWrite    // Every SSTORE that was not reverted, in order
+    GasUsed uint64                  // Gas consumed by execution after refunds, excluding intrinsic costs
+    GasRefund uint64                // Refund already deducted from GasUsed
+    Unsupported Address             // The precompile that was called, if Halt is HaltUnsupported
+}
+
+var (
+    tt255 = new(big.Int).Lsh(big.NewInt(1), 255)
+    tt256 = new(big.Int).Lsh(big.NewInt(1), 256)
+    tt256m1 = new(big.Int).Sub(tt256, big.NewInt(1))
+)
+
+// Largest memory offset or size accepted before treating an access as out of gas
+const maxMemory = 1 << 32
+
+const maxCallDepth = 1024
+
+func u256(x *big.Int) *big.Int {
+    return x.And(x, tt256m1)
+}
+
+func s256(x *big.Int) *big.Int {
+    if x.Cmp(tt255) >= 0 {
+        return new(big.Int).Sub(x, tt256)
+    }
+    return x
+}
+
+func boolToBig(b bool) *big.Int {
+    if b {
+        return big.NewInt(1)
+    }
+    return new(big.Int)
+}
+
+// Interpreter executes programs against a world state with the semantics of a fork. The
+// state is modified by execution; use WorldState.Copy to keep the original.
+type Interpreter struct {
+    Fork Fork
+    World *WorldState
+    Block *BlockContext
+
+    // Transaction state
+    msg *Message
+    original *WorldState            // The world as the transaction started
+    transient map[Address]map[Word]Word
+    accessedAccounts map[Address]bool
+    accessedSlots map[Address]map[Word]bool
+    created map[Address]bool
+    destructed map[Address]bool
+    refund int64
+    logs []Log
+    writes []StorageWrite
+    unsupported Address             // Precompile that halted the transaction with HaltUnsupported
+
+    programs map[string]*Program
+}
+
+// NewInterpreter returns an interpreter for fork. Nil world or block select an empty state
+// and a zero block context.
+func NewInterpreter(fork Fork, world *WorldState, block *BlockContext) *Interpreter {
+    if world == nil {
+        world = NewWorldState()
+    }
+    if block == nil {
+        block = &BlockContext{}
+    }
+    return &Interpreter{
+        Fork: fork.resolve(),
+        World: world,
+        Block: block,
+        programs: make(map[string]*Program),
+    }
+}
+
+// Execute runs the program as a transaction against world under the program's fork.
+func (self *Program) Execute(world *WorldState, block *BlockContext, msg *Message) *ExecutionResult {
+    return NewInterpreter(self.Fork, world, block).Execute(self, msg)
+}
+
+// ExecuteCode is like Execute, but takes bytecode that need not have been analysed.
+func (self *Interpreter) ExecuteCode(code []byte, msg *Message) *ExecutionResult {
+    return self.Execute(self.program(code), msg)
+}
+
+// Execute installs the program's code at msg.To and runs msg against it as a transaction.
+// The alt_bn128 pairing check at 0x8 and the KZG point evaluation at 0xA are not
+// implemented: calling either halts the whole transaction with HaltUnsupported, and the
+// result records which was called in Unsupported.
+func (self *Interpreter) Execute(prog *Program, msg *Message) *ExecutionResult {
+    self.msg = msg
+    self.transient = make(map[Address]map[Word]Word)
+    self.accessedAccounts = make(map[Address]bool)
+    self.accessedSlots = make(map[Address]map[Word]bool)
+    self.created = make(map[Address]bool)
+    self.destructed = make(map[Address]bool)
+    self.refund = 0
+    self.logs = nil
+    self.writes = nil
+    self.unsupported = Address{}
+
+    self.World.Account(msg.To).Code = prog.Code
+    self.programs[string(prog.Code)] = prog
+    self.original = self.World.Copy()
+
+    origin := msg.Origin
+    if origin == (Address{}) {
+        origin = msg.Caller
+    }
+    self.accessedAccounts[origin] = true
+    self.accessedAccounts[msg.Caller] = true
+    self.accessedAccounts[msg.To] = true
+    for i := 1; i <= 10; i++ {
+        if addr := BigToAddress(big.NewInt(int64(i))); self.isPrecompile(addr) {
+            self.accessedAccounts[addr] = true
+        }
+    }
+    if self.Fork >= Shanghai {
+        self.accessedAccounts[self.Block.Coinbase] = true
+    }
+
+    value := msg.Value
+    if value == nil {
+        value = new(big.Int)
+    }
+    if self.balance(msg.Caller).Cmp(value) < 0 {
+        return &ExecutionResult{Halt: HaltInsufficientBalance}
+    }
+
+    ret, gasLeft, halt := self.call(CALL, msg.Caller, msg.To, msg.To, value, msg.Data, msg.Gas, false, 0)
+    result := &ExecutionResult{
+        Halt: halt,
+        ReturnData: ret,
+        GasUsed: msg.Gas - gasLeft,
+        Unsupported: self.unsupported,
+    }
+    if !halt.Success() {
+        return result
+    }
+
+    if self.refund > 0 {
+        result.GasRefund = uint64(self.refund)
+        if limit := result.GasUsed / maxRefundQuotient(self.Fork); result.GasRefund > limit {
+            result.GasRefund = limit
+        }
+        result.GasUsed -= result.GasRefund
+    }
+    for addr := range self.destructed {
+        if self.Fork < Cancun || self.created[addr] {
+            delete(self.World.Accounts, addr)
+        }
+    }
+    result.Logs = self.logs
+    result.StorageWrites = self.writes
+    return result
+}
+
+type snapshot struct {
+    world *WorldState
+    transient map[Address]map[Word]Word
+    accessedAccounts map[Address]bool
+    accessedSlots map[Address]map[Word]bool
+    created map[Address]bool
+    destructed map[Address]bool
+    refund int64
+    logs int
+    writes int
+}
+
+func copySlots(slots map[Address]map[Word]Word) map[Address]map[Word]Word {
+    ret := make(map[Address]map[Word]Word, len(slots))
+    for addr, m := range slots {
+        ret[addr] = make(map[Word]Word, len(m))
+        for k, v := range m {
+            ret[addr][k] = v
+        }
+    }
+    return ret
+}
+
+func copySet(set map[Address]bool) map[Address]bool {
+    ret := make(map[Address]bool, len(set))
+    for k := range set {
+        ret[k] = true
+    }
+    return ret
+}
+
+func (self *Interpreter) snapshot() *snapshot {
+    snap := &snapshot{
+        world: self.World.Copy(),
+        transient: copySlots(self.transient),
+        accessedAccounts: copySet(self.accessedAccounts),
+        accessedSlots: make(map[Address]map[Word]bool, len(self.accessedSlots)),
+        created: copySet(self.created),
+        destructed: copySet(self.destructed),
+        refund: self.refund,
+        logs: len(self.logs),
+        writes: len(self.writes),
+    }
+    for addr, m := range self.accessedSlots {
+        snap.accessedSlots[addr] = make(map[Word]bool, len(m))
+        for k := range m {
+            snap.accessedSlots[addr][k] = true
+        }
+    }
+    return snap
+}
+
+func (self *Interpreter) revert(snap *snapshot) {
+    self.World.Accounts = snap.world.Accounts
+    self.transient = snap.transient
+    self.accessedAccounts = snap.accessedAccounts
+    self.accessedSlots = snap.accessedSlots
+    self.created = snap.created
+    self.destructed = snap.destructed
+    self.refund = snap.refund
+    self.logs = self.logs[:snap.logs]
+    self.writes = self.writes[:snap.writes]
+}
+
+func (self *Interpreter) program(code []byte) *Program {
+    prog, ok := self.programs[string(code)]
+    if !ok {
+        prog = decodeProgram(code)
+        prog.Fork = self.Fork
+        self.programs[string(code)] = prog
+    }
+    return prog
+}
+
+func (self *Interpreter) balance(addr Address) *big.Int {
+    if account, ok := self.World.Accounts[addr]; ok {
+        return account.Balance
+    }
+    return new(big.Int)
+}
+
+func (self *Interpreter) code(addr Address) []byte {
+    if account, ok := self.World.Accounts[addr]; ok {
+        return account.Code
+    }
+    return nil
+}
+
+func (self *Interpreter) storage(addr Address, key Word) Word {
+    if account, ok := self.World.Accounts[addr]; ok {
+        return account.Storage[key]
+    }
+    return Word{}
+}
+
+func (self *Interpreter) originalStorage(addr Address, key Word) Word {
+    if account, ok := self.original.Accounts[addr]; ok {
+        return account.Storage[key]
+    }
+    return Word{}
+}
+
+// dead returns true if the account does not exist or, from SpuriousDragon, is empty.
+func (self *Interpreter) dead(addr Address) bool {
+    account, ok := self.World.Accounts[addr]
+    if !ok {
+        return true
+    }
+    return self.Fork >= SpuriousDragon && account.empty()
+}
+
+func (self *Interpreter) transfer(from, to Address, value *big.Int) {
+    if value.Sign() == 0 {
+        return
+    }
+    sender := self.World.Account(from)
+    sender.Balance = new(big.Int).Sub(sender.Balance, value)
+    recipient := self.World.Account(to)
+    recipient.Balance = new(big.Int).Add(recipient.Balance, value)
+}
+
+// accessAccount marks addr as accessed and returns the cold access surcharge, if any.
+func (self *Interpreter) accessAccount(addr Address) uint64 {
+    if self.Fork < Berlin || self.accessedAccounts[addr] {
+        return 0
+    }
+    self.accessedAccounts[addr] = true
+    return gasColdAccount - gasWarmAccess
+}
+
+// accessSlot marks a storage slot as accessed and returns true if it was cold.
+func (self *Interpreter) accessSlot(addr Address, key Word) bool {
+    if self.Fork < Berlin || self.accessedSlots[addr][key] {
+        return false
+    }
+    if self.accessedSlots[addr] == nil {
+        self.accessedSlots[addr] = make(map[Word]bool)
+    }
+    self.accessedSlots[addr][key] = true
+    return true
+}
+
+func (self *Interpreter) isPrecompile(addr Address) bool {
+    n := addr.Big()
+    if !n.IsInt64() || n.Sign() == 0 {
+        return false
+    }
+    switch i := n.Int64(); {
+    case i <= 4:
+        return true
+    case i <= 8:
+        return self.Fork >= Byzantium
+    case i == 9:
+        return self.Fork >= Istanbul
+    case i == 10:
+        return self.Fork >= Cancun
+    }
+    return false
+}
+
+// call performs a message call of the given kind, returning the output, unused gas and how
+// the callee halted. State changes are reverted unless it succeeded.
+func (self *Interpreter) call(kind OpCode, caller, addr, codeAddr Address, value *big.Int, input []byte, gas uint64, static bool, depth int) (ret []byte, gasLeft uint64, halt HaltReason) {
+    snap := self.snapshot()
+    if kind == CALL {
+        self.transfer(caller, addr, value)
+    }
+
+    if self.isPrecompile(codeAddr) {
+        ret, gasLeft, halt = self.runPrecompile(codeAddr, input, gas)
+        if halt == HaltUnsupported {
+            self.unsupported = codeAddr
+        }
+    } else if code := self.code(codeAddr); len(code) == 0 {
+        return nil, gas, HaltStop
+    } else {
+        f := &frame{
+            prog: self.program(code),
+            address: addr,
+            caller: caller,
+            value: value,
+            input: input,
+            gas: gas,
+            static: static,
+            depth: depth,
+        }
+        ret, halt = self.run(f)
+        gasLeft = f.gas
+    }
+
+    switch {
+    case halt.Success():
+    case halt == HaltRevert:
+        self.revert(snap)
+    default:
+        self.revert(snap)
+        ret, gasLeft = nil, 0
+    }
+    return ret, gasLeft, halt
+}
+
+func createAddress(sender Address, nonce uint64) Address {
+    var enc []byte
+    switch {
+    case nonce == 0:
+        enc = []byte{0x80}
+    case nonce < 0x80:
+        enc = []byte{byte(nonce)}
+    default:
+        n := new(big.Int).SetUint64(nonce).Bytes()
+        enc = append([]byte{0x80 + byte(len(n))}, n...)
+    }
+    rlp := append([]byte{0xc0 + byte(21 + len(enc)), 0x80 + 20}, sender[:]...)
+    hash := Keccak256(append(rlp, enc...))
+    return BigToAddress(new(big.Int).SetBytes(hash[12:]))
+}
+
+func create2Address(sender Address, salt Word, init []byte) Address {
+    initHash := Keccak256(init)
+    data := append([]byte{0xff}, sender[:]...)
+    data = append(data, salt[:]...)
+    hash := Keccak256(append(data, initHash[:]...))
+    return BigToAddress(new(big.Int).SetBytes(hash[12:]))
+}
+
+// create deploys a contract at addr by running init, returning the init code's output if
+// it reverted, the unused gas and how it halted.
+func (self *Interpreter) create(caller, addr Address, value *big.Int, init []byte, gas uint64, depth int) (ret []byte, gasLeft uint64, halt HaltReason) {
+    self.accessedAccounts[addr] = true
+    if account, ok := self.World.Accounts[addr]; ok && (account.Nonce != 0 || len(account.Code) != 0) {
+        return nil, 0, HaltCreateCollision
+    }
+
+    snap := self.snapshot()
+    account := self.World.Account(addr)
+    account.Storage = make(map[Word]Word)
+    if self.Fork >= SpuriousDragon {
+        account.Nonce = 1
+    }
+    self.transfer(caller, addr, value)
+    self.created[addr] = true
+
+    f := &frame{
+        prog: self.program(init),
+        address: addr,
+        caller: caller,
+        value: value,
+        gas: gas,
+        depth: depth,
+    }
+    ret, halt = self.run(f)
+    gasLeft = f.gas
+
+    if halt.Success() {
+        deposit := gasCodeDeposit * uint64(len(ret))
+        switch {
+        case self.Fork >= London && len(ret) > 0 && ret[0] == 0xef:
+            halt = HaltInvalidCode
+        case self.Fork >= SpuriousDragon && len(ret) > maxCodeSize:
+            halt = HaltInvalidCode
+        case deposit > gasLeft:
+            if self.Fork >= Homestead {
+                halt = HaltOutOfGas
+            } else {
+                ret = nil
+            }
+        default:
+            gasLeft -= deposit
+        }
+        if halt.Success() {
+            account.Code = ret
+            return nil, gasLeft, halt
+        }
+    }
+
+    self.revert(snap)
+    if halt == HaltRevert {
+        return ret, gasLeft, halt
+    }
+    return nil, 0, halt
+}
+
+type frame struct {
+    prog *Program
+    address Address
+    caller Address
+    value *big.Int
+    input []byte
+    gas uint64
+    static bool
+    depth int
+    stack []*big.Int
+    memory []byte
+    returnData []byte
+}
+
+func (self *frame) use(gas uint64) bool {
+    if gas > self.gas {
+        self.gas = 0
+        return false
+    }
+    self.gas -= gas
+    return true
+}
+
+func (self *frame) push(value *big.Int) {
+    self.stack = append(self.stack, value)
+}
+
+func (self *frame) pop() *big.Int {
+    value := self.stack[len(self.stack) - 1]
+    self.stack = self.stack[:len(self.stack) - 1]
+    return value
+}
+
+// expand charges for growing memory to cover size bytes at offset, returning them as
+// ints, or false if there is not enough gas.
+func (self *frame) expand(offset, size *big.Int) (int, int, bool) {
+    if size.Sign() == 0 {
+        return 0, 0, true
+    }
+    if !offset.IsUint64() || !size.IsUint64() || offset.Uint64() > maxMemory || size.Uint64() > maxMemory {
+        self.gas = 0
+        return 0, 0, false
+    }
+    words := toWords(offset.Uint64() + size.Uint64())
+    if current := uint64(len(self.memory)) / 32; words > current {
+        if !self.use(memoryGas(words) - memoryGas(current)) {
+            return 0, 0, false
+        }
+        self.memory = append(self.memory, make([]byte, int(words - current) * 32)...)
+    }
+    return int(offset.Uint64()), int(size.Uint64()), true
+}
+
+// slice returns size bytes of data from start, padded with zeros.
+func slice(data []byte, start *big.Int, size int) []byte {
+    ret := make([]byte, size)
+    if start.IsUint64() && start.Uint64() < uint64(len(data)) {
+        copy(ret, data[start.Uint64():])
+    }
+    return ret
+}
+
+// run executes a frame until it halts.
+func (self *Interpreter) run(f *frame) (ret []byte, halt HaltReason) {
+    fork := self.Fork
+    pc := 0
+    for {
+        inst, ok := f.prog.Instructions[pc]
+        if !ok {
+            // Running off the end of the code stops
+            return nil, HaltStop
+        }
+        op := inst.Op
+        if !op.ValidIn(fork) {
+            f.gas = 0
+            return nil, HaltInvalidOpcode
+        }
+        reads := op.StackReads()
+        if len(f.stack) < reads {
+            f.gas = 0
+            return nil, HaltStackUnderflow
+        }
+        if len(f.stack) + op.StackWrites() - reads > 1024 {
+            f.gas = 0
+            return nil, HaltStackOverflow
+        }
+        if !f.use(op.StaticGas(fork)) {
+            return nil, HaltOutOfGas
+        }
+
+        var x []*big.Int
+        if !op.IsDup() && !op.IsSwap() {
+            x = make([]*big.Int, reads)
+            for i := range x {
+                x[i] = f.pop()
+            }
+        }
+
+        next := pc + op.OperandSize() + 1
+        switch {
+        case op.IsPush():
+            f.push(inst.Arg)
+        case op.IsDup():
+            f.push(f.stack[len(f.stack) - reads])
+        case op.IsSwap():
+            top, other := len(f.stack) - 1, len(f.stack) - reads
+            f.stack[top], f.stack[other] = f.stack[other], f.stack[top]
+        }
+
+        switch op {
+        case ADD:
+            f.push(u256(new(big.Int).Add(x[0], x[1])))
+        case MUL:
+            f.push(u256(new(big.Int).Mul(x[0], x[1])))
+        case SUB:
+            f.push(u256(new(big.Int).Sub(x[0], x[1])))
+        case DIV:
+            if x[1].Sign() == 0 {
+                f.push(new(big.Int))
+            } else {
+                f.push(new(big.Int).Div(x[0], x[1]))
+            }
+        case SDIV:
+            if x[1].Sign() == 0 {
+                f.push(new(big.Int))
+            } else {
+                f.push(u256(new(big.Int).Quo(s256(x[0]), s256(x[1]))))
+            }
+        case MOD:
+            if x[1].Sign() == 0 {
+                f.push(new(big.Int))
+            } else {
+                f.push(new(big.Int).Mod(x[0], x[1]))
+            }
+        case SMOD:
+            if x[1].Sign() == 0 {
+                f.push(new(big.Int))
+            } else {
+                f.push(u256(new(big.Int).Rem(s256(x[0]), s256(x[1]))))
+            }
+        case ADDMOD, MULMOD:
+            if x[2].Sign() == 0 {
+                f.push(new(big.Int))
+                break
+            }
+            result := new(big.Int)
+            if op == ADDMOD {
+                result.Add(x[0], x[1])
+            } else {
+                result.Mul(x[0], x[1])
+            }
+            f.push(result.Mod(result, x[2]))
+        case EXP:
+            if !f.use(expByteGas(fork) * uint64((x[1].BitLen() + 7) / 8)) {
+                return nil, HaltOutOfGas
+            }
+            f.push(new(big.Int).Exp(x[0], x[1], tt256))
+        case SIGNEXTEND:
+            if x[0].Cmp(big.NewInt(31)) >= 0 {
+                f.push(x[1])
+                break
+            }
+            bit := uint(x[0].Uint64() * 8 + 7)
+            mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bit + 1), big.NewInt(1))
+            if x[1].Bit(int(bit)) == 1 {
+                f.push(u256(new(big.Int).Or(x[1], new(big.Int).Not(mask))))
+            } else {
+                f.push(new(big.Int).And(x[1], mask))
+            }
+
+        case LT:
+            f.push(boolToBig(x[0].Cmp(x[1]) < 0))
+        case GT:
+            f.push(boolToBig(x[0].Cmp(x[1]) > 0))
+        case SLT:
+            f.push(boolToBig(s256(x[0]).Cmp(s256(x[1])) < 0))
+        case SGT:
+            f.push(boolToBig(s256(x[0]).Cmp(s256(x[1])) > 0))
+        case EQ:
+            f.push(boolToBig(x[0].Cmp(x[1]) == 0))
+        case ISZERO:
+            f.push(boolToBig(x[0].Sign() == 0))
+        case AND:
+            f.push(new(big.Int).And(x[0], x[1]))
+        case OR:
+            f.push(new(big.Int).Or(x[0], x[1]))
+        case XOR:
+            f.push(new(big.Int).Xor(x[0], x[1]))
+        case NOT:
+            f.push(new(big.Int).Xor(x[0], tt256m1))
+        case BYTE:
+            if x[0].Cmp(big.NewInt(32)) >= 0 {
+                f.push(new(big.Int))
+            } else {
+                word := BigToWord(x[1])
+                f.push(big.NewInt(int64(word[x[0].Uint64()])))
+            }
+        case SHL, SHR, SAR:
+            shift := uint(256)
+            if x[0].IsUint64() && x[0].Uint64() < 256 {
+                shift = uint(x[0].Uint64())
+            }
+            switch op {
+            case SHL:
+                f.push(u256(new(big.Int).Lsh(x[1], shift)))
+            case SHR:
+                f.push(new(big.Int).Rsh(x[1], shift))
+            case SAR:
+                f.push(u256(new(big.Int).Rsh(s256(x[1]), shift)))
+            }
+        case SHA3:
+            offset, size, ok := f.expand(x[0], x[1])
+            if !ok || !f.use(gasSha3Word * toWords(uint64(size))) {
+                return nil, HaltOutOfGas
+            }
+            hash := Keccak256(f.memory[offset:offset + size])
+            f.push(new(big.Int).SetBytes(hash[:]))
+
+        case ADDRESS:
+            f.push(f.address.Big())
+        case BALANCE:
+            addr := BigToAddress(x[0])
+            if !f.use(self.accessAccount(addr)) {
+                return nil, HaltOutOfGas
+            }
+            f.push(self.balance(addr))
+        case ORIGIN:
+            origin := self.msg.Origin
+            if origin == (Address{}) {
+                origin = self.msg.Caller
+            }
+            f.push(origin.Big())
+        case CALLER:
+            f.push(f.caller.Big())
+        case CALLVALUE:
+            f.push(f.value)
+        case CALLDATALOAD:
+            f.push(new(big.Int).SetBytes(slice(f.input, x[0], 32)))
+        case CALLDATASIZE:
+            f.push(big.NewInt(int64(len(f.input))))
+        case CODESIZE:
+            f.push(big.NewInt(int64(len(f.prog.Code))))
+        case CALLDATACOPY, CODECOPY, EXTCODECOPY, RETURNDATACOPY:
+            var data []byte
+            switch op {
+            case CALLDATACOPY:
+                data = f.input
+            case CODECOPY:
+                data = f.prog.Code
+            case EXTCODECOPY:
+                addr := BigToAddress(x[0])
+                if !f.use(self.accessAccount(addr)) {
+                    return nil, HaltOutOfGas
+                }
+                data = self.code(addr)
+                x = x[1:]
+            case RETURNDATACOPY:
+                data = f.returnData
+                end := new(big.Int).Add(x[1], x[2])
+                if end.Cmp(big.NewInt(int64(len(data)))) > 0 {
+                    f.gas = 0
+                    return nil, HaltReturnDataOutOfBounds
+                }
+            }
+            offset, size, ok := f.expand(x[0], x[2])
+            if !ok || !f.use(gasCopy * toWords(uint64(size))) {
+                return nil, HaltOutOfGas
+            }
+            copy(f.memory[offset:offset + size], slice(data, x[1], size))
+        case GASPRICE:
+            f.push(bigOrZero(self.msg.GasPrice))
+        case EXTCODESIZE, EXTCODEHASH:
+            addr := BigToAddress(x[0])
+            if !f.use(self.accessAccount(addr)) {
+                return nil, HaltOutOfGas
+            }
+            code := self.code(addr)
+            switch {
+            case op == EXTCODESIZE:
+                f.push(big.NewInt(int64(len(code))))
+            case self.dead(addr) || !self.World.Exists(addr):
+                f.push(new(big.Int))
+            default:
+                hash := Keccak256(code)
+                f.push(new(big.Int).SetBytes(hash[:]))
+            }
+        case RETURNDATASIZE:
+            f.push(big.NewInt(int64(len(f.returnData))))
+
+        case BLOCKHASH:
+            number := self.Block.Number
+            if x[0].IsUint64() && x[0].Uint64() < number && x[0].Uint64() + 256 >= number {
+                f.push(self.Block.BlockHashes[x[0].Uint64()].Big())
+            } else {
+                f.push(new(big.Int))
+            }
+        case COINBASE:
+            f.push(self.Block.Coinbase.Big())
+        case TIMESTAMP:
+            f.push(new(big.Int).SetUint64(self.Block.Timestamp))
+        case NUMBER:
+            f.push(new(big.Int).SetUint64(self.Block.Number))
+        case DIFFICULTY:
+            f.push(bigOrZero(self.Block.Difficulty))
+        case GASLIMIT:
+            f.push(new(big.Int).SetUint64(self.Block.GasLimit))
+        case CHAINID:
+            f.push(bigOrZero(self.Block.ChainID))
+        case SELFBALANCE:
+            f.push(self.balance(f.address))
+        case BASEFEE:
+            f.push(bigOrZero(self.Block.BaseFee))
+        case BLOBHASH:
+            if x[0].IsUint64() && x[0].Uint64() < uint64(len(self.Block.BlobHashes)) {
+                f.push(self.Block.BlobHashes[x[0].Uint64()].Big())
+            } else {
+                f.push(new(big.Int))
+            }
+        case BLOBBASEFEE:
+            f.push(bigOrZero(self.Block.BlobBaseFee))
+
+        case MLOAD:
+            offset, _, ok := f.expand(x[0], big.NewInt(32))
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            f.push(new(big.Int).SetBytes(f.memory[offset:offset + 32]))
+        case MSTORE:
+            offset, _, ok := f.expand(x[0], big.NewInt(32))
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            x[1].FillBytes(f.memory[offset:offset + 32])
+        case MSTORE8:
+            offset, _, ok := f.expand(x[0], big.NewInt(1))
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            f.memory[offset] = byte(x[1].Uint64())
+        case SLOAD:
+            key := BigToWord(x[0])
+            if self.accessSlot(f.address, key) && !f.use(gasColdSload - gasWarmAccess) {
+                return nil, HaltOutOfGas
+            }
+            f.push(self.storage(f.address, key).Big())
+        case SSTORE:
+            if f.static {
+                f.gas = 0
+                return nil, HaltWriteProtection
+            }
+            if fork >= Istanbul && f.gas <= gasCallStipend {
+                f.gas = 0
+                return nil, HaltOutOfGas
+            }
+            key, value := BigToWord(x[0]), BigToWord(x[1])
+            if self.accessSlot(f.address, key) && !f.use(gasColdSload) {
+                return nil, HaltOutOfGas
+            }
+            gas, refund := sstoreGas(fork, self.originalStorage(f.address, key), self.storage(f.address, key), value)
+            if !f.use(gas) {
+                return nil, HaltOutOfGas
+            }
+            self.refund += refund
+            if value == (Word{}) {
+                delete(self.World.Account(f.address).Storage, key)
+            } else {
+                self.World.Account(f.address).Storage[key] = value
+            }
+            self.writes = append(self.writes, StorageWrite{f.address, key, value})
+        case JUMP, JUMPI:
+            if op == JUMPI && x[1].Sign() == 0 {
+                break
+            }
+            if !x[0].IsInt64() {
+                f.gas = 0
+                return nil, HaltInvalidJump
+            }
+            dest, ok := f.prog.Instructions[int(x[0].Int64())]
+            if !ok || dest.Op != JUMPDEST {
+                f.gas = 0
+                return nil, HaltInvalidJump
+            }
+            next = int(x[0].Int64())
+        case PC:
+            f.push(big.NewInt(int64(pc)))
+        case MSIZE:
+            f.push(big.NewInt(int64(len(f.memory))))
+        case GAS:
+            f.push(new(big.Int).SetUint64(f.gas))
+        case TLOAD:
+            f.push(self.transient[f.address][BigToWord(x[0])].Big())
+        case TSTORE:
+            if f.static {
+                f.gas = 0
+                return nil, HaltWriteProtection
+            }
+            if self.transient[f.address] == nil {
+                self.transient[f.address] = make(map[Word]Word)
+            }
+            self.transient[f.address][BigToWord(x[0])] = BigToWord(x[1])
+        case MCOPY:
+            end := x[0]
+            if x[1].Cmp(end) > 0 {
+                end = x[1]
+            }
+            _, size, ok := f.expand(end, x[2])
+            if !ok || !f.use(gasCopy * toWords(uint64(size))) {
+                return nil, HaltOutOfGas
+            }
+            if size > 0 {
+                dst, src := int(x[0].Uint64()), int(x[1].Uint64())
+                copy(f.memory[dst:dst + size], f.memory[src:src + size])
+            }
+
+        case LOG0, LOG1, LOG2, LOG3, LOG4:
+            if f.static {
+                f.gas = 0
+                return nil, HaltWriteProtection
+            }
+            offset, size, ok := f.expand(x[0], x[1])
+            if !ok || !f.use(gasLogData * uint64(size)) {
+                return nil, HaltOutOfGas
+            }
+            log := Log{Address: f.address, Data: append([]byte{}, f.memory[offset:offset + size]...)}
+            for _, topic := range x[2:] {
+                log.Topics = append(log.Topics, BigToWord(topic))
+            }
+            self.logs = append(self.logs, log)
+
+        case CREATE, CREATE2:
+            if f.static {
+                f.gas = 0
+                return nil, HaltWriteProtection
+            }
+            offset, size, ok := f.expand(x[1], x[2])
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            if fork >= Shanghai {
+                if size > maxInitCodeSize {
+                    f.gas = 0
+                    return nil, HaltOutOfGas
+                }
+                if !f.use(gasInitCodeWord * toWords(uint64(size))) {
+                    return nil, HaltOutOfGas
+                }
+            }
+            if op == CREATE2 && !f.use(gasSha3Word * toWords(uint64(size))) {
+                return nil, HaltOutOfGas
+            }
+            init := append([]byte{}, f.memory[offset:offset + size]...)
+
+            gas := f.gas
+            if fork >= TangerineWhistle {
+                gas -= gas / 64
+            }
+            f.gas -= gas
+            f.returnData = nil
+            if f.depth + 1 > maxCallDepth || self.balance(f.address).Cmp(x[0]) < 0 {
+                f.gas += gas
+                f.push(new(big.Int))
+                break
+            }
+
+            account := self.World.Account(f.address)
+            var addr Address
+            if op == CREATE {
+                addr = createAddress(f.address, account.Nonce)
+            } else {
+                addr = create2Address(f.address, BigToWord(x[3]), init)
+            }
+            account.Nonce += 1
+
+            ret, gasLeft, halt := self.create(f.address, addr, x[0], init, gas, f.depth + 1)
+            if halt == HaltUnsupported {
+                return nil, HaltUnsupported
+            }
+            f.gas += gasLeft
+            f.returnData = ret
+            if halt.Success() {
+                f.push(addr.Big())
+            } else {
+                f.push(new(big.Int))
+            }
+
+        case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+            addr := BigToAddress(x[1])
+            value := new(big.Int)
+            args := x[2:]
+            if op == CALL || op == CALLCODE {
+                value, args = x[2], x[3:]
+            }
+            if op == CALL && f.static && value.Sign() != 0 {
+                f.gas = 0
+                return nil, HaltWriteProtection
+            }
+
+            inOffset, inSize, ok := f.expand(args[0], args[1])
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            outOffset, outSize, ok := f.expand(args[2], args[3])
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            cost := self.accessAccount(addr)
+            if value.Sign() != 0 {
+                cost += gasCallValue
+            }
+            if op == CALL {
+                if fork >= SpuriousDragon && value.Sign() != 0 && self.dead(addr) || fork < SpuriousDragon && !self.World.Exists(addr) {
+                    cost += gasNewAccount
+                }
+            }
+            if !f.use(cost) {
+                return nil, HaltOutOfGas
+            }
+
+            gas := f.gas
+            if fork >= TangerineWhistle {
+                gas -= gas / 64
+                if x[0].IsUint64() && x[0].Uint64() < gas {
+                    gas = x[0].Uint64()
+                }
+            } else if !x[0].IsUint64() || x[0].Uint64() > gas {
+                f.gas = 0
+                return nil, HaltOutOfGas
+            } else {
+                gas = x[0].Uint64()
+            }
+            f.gas -= gas
+            if value.Sign() != 0 {
+                gas += gasCallStipend
+            }
+
+            f.returnData = nil
+            if f.depth + 1 > maxCallDepth || value.Sign() != 0 && self.balance(f.address).Cmp(value) < 0 {
+                f.gas += gas
+                f.push(new(big.Int))
+                break
+            }
+
+            input := append([]byte{}, f.memory[inOffset:inOffset + inSize]...)
+            var ret []byte
+            var gasLeft uint64
+            var halt HaltReason
+            switch op {
+            case CALL:
+                ret, gasLeft, halt = self.call(op, f.address, addr, addr, value, input, gas, f.static, f.depth + 1)
+            case CALLCODE:
+                ret, gasLeft, halt = self.call(op, f.address, f.address, addr, value, input, gas, f.static, f.depth + 1)
+            case DELEGATECALL:
+                ret, gasLeft, halt = self.call(op, f.caller, f.address, addr, f.value, input, gas, f.static, f.depth + 1)
+            case STATICCALL:
+                ret, gasLeft, halt = self.call(op, f.address, addr, addr, value, input, gas, true, f.depth + 1)
+            }
+            if halt == HaltUnsupported {
+                return nil, HaltUnsupported
+            }
+            f.gas += gasLeft
+            f.returnData = ret
+            copy(f.memory[outOffset:outOffset + outSize], ret)
+            f.push(boolToBig(halt.Success()))
+
+        case RETURN, REVERT:
+            offset, size, ok := f.expand(x[0], x[1])
+            if !ok {
+                return nil, HaltOutOfGas
+            }
+            ret = append([]byte{}, f.memory[offset:offset + size]...)
+            if op == REVERT {
+                return ret, HaltRevert
+            }
+            return ret, HaltReturn
+        case SELFDESTRUCT:
+            if f.static {
+                f.gas = 0
+                return nil, HaltWriteProtection
+            }
+            beneficiary := BigToAddress(x[0])
+            var cost uint64
+            if fork >= Berlin && !self.accessedAccounts[beneficiary] {
+                self.accessedAccounts[beneficiary] = true
+                cost += gasColdAccount
+            }
+            balance := self.balance(f.address)
+            switch {
+            case fork >= SpuriousDragon:
+                if balance.Sign() != 0 && self.dead(beneficiary) {
+                    cost += gasNewAccount
+                }
+            case fork >= TangerineWhistle:
+                if !self.World.Exists(beneficiary) {
+                    cost += gasNewAccount
+                }
+            }
+            if !f.use(cost) {
+                return nil, HaltOutOfGas
+            }
+            if fork < London && !self.destructed[f.address] {
+                self.refund += 24000
+            }
+            self.destructed[f.address] = true
+            if fork >= Cancun && !self.created[f.address] && beneficiary == f.address {
+                return nil, HaltSelfDestruct
+            }
+            if beneficiary == f.address {
+                // The balance is burnt
+                self.World.Account(f.address).Balance = new(big.Int)
+            } else {
+                self.transfer(f.address, beneficiary, balance)
+            }
+            return nil, HaltSelfDestruct
+        case STOP:
+            return nil, HaltStop
+        }
+        pc = next
+    }
+}
+
+func bigOrZero(value *big.Int) *big.Int {
+    if value == nil {
+        return new(big.Int)
+    }
+    return value
+}
@@ -0,0 +1,232 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "testing"
+)
+
+// TestSstoreGas runs the cases in the tables of EIP-1283, EIP-2200 and EIP-3529, and some
+// under the rules before net gas metering and EIP-2929, each of which writes slot 0 two
+// or three times. Gas is the total before refunds, which from Berlin includes 2100 for
+// the first access to the cold slot; the tables of EIP-3529 assume it is warm.
+func TestSstoreGas(t *testing.T) {
+    tests := []struct {
+        fork Fork
+        original int64
+        values string              // Values written, one digit each
+        gas uint64
+        refund uint64               // Before the cap on refunds
+    }{
+        {Petersburg, 0, "00", 10012, 0},
+        {Petersburg, 0, "01", 25012, 0},
+        {Petersburg, 0, "10", 25012, 15000},
+        {Petersburg, 1, "00", 10012, 15000},
+        {Petersburg, 1, "01", 25012, 15000},
+        {Petersburg, 1, "21", 10012, 0},
+        {Petersburg, 1, "010", 30018, 30000},
+        {Constantinople, 0, "00", 412, 0},
+        {Constantinople, 0, "01", 20212, 0},
+        {Constantinople, 0, "10", 20212, 19800},
+        {Constantinople, 0, "12", 20212, 0},
+        {Constantinople, 0, "11", 20212, 0},
+        {Constantinople, 1, "00", 5212, 15000},
+        {Constantinople, 1, "01", 5212, 4800},
+        {Constantinople, 1, "02", 5212, 0},
+        {Constantinople, 1, "20", 5212, 15000},
+        {Constantinople, 1, "23", 5212, 0},
+        {Constantinople, 1, "21", 5212, 4800},
+        {Constantinople, 1, "22", 5212, 0},
+        {Constantinople, 1, "10", 5212, 15000},
+        {Constantinople, 1, "12", 5212, 0},
+        {Constantinople, 1, "11", 412, 0},
+        {Constantinople, 0, "101", 40218, 19800},
+        {Constantinople, 1, "010", 10218, 19800},
+        {Istanbul, 0, "00", 1612, 0},
+        {Istanbul, 0, "01", 20812, 0},
+        {Istanbul, 0, "10", 20812, 19200},
+        {Istanbul, 0, "12", 20812, 0},
+        {Istanbul, 0, "11", 20812, 0},
+        {Istanbul, 1, "00", 5812, 15000},
+        {Istanbul, 1, "01", 5812, 4200},
+        {Istanbul, 1, "02", 5812, 0},
+        {Istanbul, 1, "20", 5812, 15000},
+        {Istanbul, 1, "23", 5812, 0},
+        {Istanbul, 1, "21", 5812, 4200},
+        {Istanbul, 1, "22", 5812, 0},
+        {Istanbul, 1, "10", 5812, 15000},
+        {Istanbul, 1, "12", 5812, 0},
+        {Istanbul, 1, "11", 1612, 0},
+        {Istanbul, 0, "101", 40818, 19200},
+        {Istanbul, 1, "010", 10818, 19200},
+        {Berlin, 0, "00", 2312, 0},
+        {Berlin, 0, "10", 22212, 19900},
+        {Berlin, 1, "00", 5112, 15000},
+        {Berlin, 1, "01", 5112, 2800},
+        {Berlin, 1, "11", 2312, 0},
+        {Berlin, 1, "010", 8018, 17800},
+        {London, 0, "00", 2312, 0},
+        {London, 0, "01", 22212, 0},
+        {London, 0, "10", 22212, 19900},
+        {London, 0, "12", 22212, 0},
+        {London, 0, "11", 22212, 0},
+        {London, 1, "00", 5112, 4800},
+        {London, 1, "01", 5112, 2800},
+        {London, 1, "02", 5112, 0},
+        {London, 1, "20", 5112, 4800},
+        {London, 1, "23", 5112, 0},
+        {London, 1, "21", 5112, 2800},
+        {London, 1, "22", 5112, 0},
+        {London, 1, "10", 5112, 4800},
+        {London, 1, "12", 5112, 0},
+        {London, 1, "11", 2312, 0},
+        {London, 0, "101", 42218, 19900},
+        {London, 1, "010", 8018, 7600},
+    }
+
+    to := BigToAddress(big.NewInt(0xc0de))
+    for _, tt := range tests {
+        code := ""
+        for _, v := range tt.values {
+            code += fmt.Sprintf("60%02x600055", v - '0')   // SSTORE(0, v)
+        }
+        world := NewWorldState()
+        world.Account(to).Storage[Word{}] = BigToWord(big.NewInt(tt.original))
+        result := NewInterpreter(tt.fork, world, nil).ExecuteCode(mustDecodeHex(t, code), &Message{To: to, Gas: 100000})
+
+        refund := tt.refund
+        if limit := tt.gas / maxRefundQuotient(tt.fork); refund > limit {
+            refund = limit
+        }
+        if result.Halt != HaltStop || result.GasUsed + result.GasRefund != tt.gas || result.GasRefund != refund {
+            t.Errorf("%v, original %v, writing %v: got %v using %v gas with %v refunded, want %v with %v refunded", tt.fork, tt.original, tt.values, result.Halt, result.GasUsed + result.GasRefund, result.GasRefund, tt.gas, refund)
+        }
+    }
+}
+
+// TestShifts runs the test cases of EIP-145.
+func TestShifts(t *testing.T) {
+    tests := []struct {
+        op OpCode
+        value string
+        shift string
+        result string
+    }{
+        {SHL, "1", "00", "1"},
+        {SHL, "1", "01", "2"},
+        {SHL, "1", "ff", "8000000000000000000000000000000000000000000000000000000000000000"},
+        {SHL, "1", "0100", "0"},
+        {SHL, "1", "0101", "0"},
+        {SHL, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "00", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SHL, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "01", "fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffe"},
+        {SHL, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "ff", "8000000000000000000000000000000000000000000000000000000000000000"},
+        {SHL, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "0100", "0"},
+        {SHL, "0", "01", "0"},
+        {SHL, "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "01", "fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffe"},
+        {SHR, "1", "00", "1"},
+        {SHR, "1", "01", "0"},
+        {SHR, "8000000000000000000000000000000000000000000000000000000000000000", "01", "4000000000000000000000000000000000000000000000000000000000000000"},
+        {SHR, "8000000000000000000000000000000000000000000000000000000000000000", "ff", "1"},
+        {SHR, "8000000000000000000000000000000000000000000000000000000000000000", "0100", "0"},
+        {SHR, "8000000000000000000000000000000000000000000000000000000000000000", "0101", "0"},
+        {SHR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "00", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SHR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "01", "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SHR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "ff", "1"},
+        {SHR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "0100", "0"},
+        {SHR, "0", "01", "0"},
+        {SAR, "1", "00", "1"},
+        {SAR, "1", "01", "0"},
+        {SAR, "8000000000000000000000000000000000000000000000000000000000000000", "01", "c000000000000000000000000000000000000000000000000000000000000000"},
+        {SAR, "8000000000000000000000000000000000000000000000000000000000000000", "ff", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "8000000000000000000000000000000000000000000000000000000000000000", "0100", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "8000000000000000000000000000000000000000000000000000000000000000", "0101", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "00", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "01", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "ff", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "0100", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+        {SAR, "0", "01", "0"},
+        {SAR, "4000000000000000000000000000000000000000000000000000000000000000", "fe", "1"},
+        {SAR, "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "f8", "7f"},
+        {SAR, "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "fe", "1"},
+        {SAR, "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "ff", "0"},
+        {SAR, "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "0100", "0"},
+    }
+
+    for _, tt := range tests {
+        value, _ := new(big.Int).SetString(tt.value, 16)
+        shift, _ := new(big.Int).SetString(tt.shift, 16)
+        asm := NewAssembler(Cancun)
+        asm.Push(value)
+        asm.Push(shift)
+        asm.Op(tt.op)
+        code, err := asm.Assemble()
+        if err != nil {
+            t.Fatal(err)
+        }
+        got := returnTop(t, Cancun, code)
+        if got.Text(16) != tt.result {
+            t.Errorf("%v %v by %v: got %x, want %v", tt.op, tt.value, tt.shift, got, tt.result)
+        }
+    }
+}
+
+// returnTop runs code followed by code returning the value it leaves on the stack.
+func returnTop(t *testing.T, fork Fork, code []byte) *big.Int {
+    code = append(code, mustDecodeHex(t, "60005260206000f3")...)
+    result := NewInterpreter(fork, nil, nil).ExecuteCode(code, &Message{Gas: 100000})
+    if result.Halt != HaltReturn {
+        t.Fatalf("%x halted with %v", code, result.Halt)
+    }
+    return new(big.Int).SetBytes(result.ReturnData)
+}
+
+// TestOpcodeGas checks the gas used by short programs across the forks that repriced them.
+func TestOpcodeGas(t *testing.T) {
+    tests := []struct {
+        code string
+        fork Fork
+        gas uint64
+    }{
+        {"6101006002" + "0a00", Frontier, 36},                  // EXP with a two byte exponent
+        {"6101006002" + "0a00", SpuriousDragon, 116},
+        {"600054" + "00", Frontier, 53},                        // SLOAD
+        {"600054" + "00", TangerineWhistle, 203},
+        {"600054" + "00", Istanbul, 803},
+        {"600054" + "00", Berlin, 2103},
+        {"60005460005400", Berlin, 2206},                       // SLOAD, cold then warm
+        {"600031" + "00", Frontier, 23},                        // BALANCE
+        {"600031" + "00", TangerineWhistle, 403},
+        {"600031" + "00", Istanbul, 703},
+        {"60ff31" + "00", Berlin, 2603},
+        {"30313031" + "00", Berlin, 204},                       // BALANCE of the warm callee
+        {"60003b" + "00", Frontier, 23},                        // EXTCODESIZE
+        {"60003b" + "00", TangerineWhistle, 703},
+        {"60ff3b" + "00", Berlin, 2603},
+        {"604060002000", Frontier, 54},                         // SHA3 of two words
+        {"60016103e05200", Frontier, 107},                      // MSTORE expanding memory to 32 words
+        {"6001617fe05200", Frontier, 5129},                     // ... and to 1024 words
+        {"5f00", Shanghai, 2},                                  // PUSH0
+        {"600160005d60005c00", Cancun, 209},                    // TSTORE then TLOAD
+    }
+
+    to := BigToAddress(big.NewInt(0xc0de))
+    for _, tt := range tests {
+        result := NewInterpreter(tt.fork, NewWorldState(), nil).ExecuteCode(mustDecodeHex(t, tt.code), &Message{To: to, Gas: 100000})
+        if result.Halt != HaltStop || result.GasUsed != tt.gas {
+            t.Errorf("%v on %v: got %v using %v gas, want %v", tt.code, tt.fork, result.Halt, result.GasUsed, tt.gas)
+        }
+    }
+}
+
+// TestUnsupportedPrecompile checks that calling a precompile the interpreter does not
+// implement halts the transaction and records which one was called.
+func TestUnsupportedPrecompile(t *testing.T) {
+    for _, addr := range []int64{8, 10} {
+        // CALL(gas, addr, 0, 0, 0, 0, 0)
+        code := mustDecodeHex(t, fmt.Sprintf("6000600060006000600060%02x5af100", addr))
+        result := NewInterpreter(Cancun, NewWorldState(), nil).ExecuteCode(code, &Message{Gas: 100000})
+        if result.Halt != HaltUnsupported || result.Unsupported != BigToAddress(big.NewInt(addr)) {
+            t.Errorf("calling %#x: got %v with unsupported %v", addr, result.Halt, result.Unsupported)
+        }
+    }
+}
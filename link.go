@@ -0,0 +1,151 @@
+package evmopt
+
+import (
+    "context"
+    "encoding/hex"
+    "fmt"
+    "math/big"
+    "strings"
+)
+
+// Length in hex digits of a library placeholder, such as __$<34 hex digits>$__, or
+// __Name___ padded with underscores in code from solc before 0.5
+const placeholderLength = 40
+
+// ParseBytecode decodes hex code that may contain library placeholders, returning nil if
+// there is no code. Each placeholder reads as zero in Code and has a link reference; the
+// library is named only in old style placeholders, which hold its name.
+func ParseBytecode(object string) (*Bytecode, error) {
+    object = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(object), "0x"), "0X")
+    if object == "" {
+        return nil, nil
+    }
+    ret := &Bytecode{}
+    var b strings.Builder
+    for i := 0; i < len(object); {
+        if object[i] != '_' {
+            b.WriteByte(object[i])
+            i += 1
+            continue
+        }
+        if i % 2 != 0 || i + placeholderLength > len(object) || object[i + 1] != '_' || object[i + placeholderLength - 1] != '_' {
+            return nil, fmt.Errorf("malformed library placeholder at offset %d", i / 2)
+        }
+        placeholder := object[i + 2:i + placeholderLength - 2]
+        ref := LinkReference{Start: i / 2, Length: placeholderLength / 2}
+        if strings.HasPrefix(placeholder, "$") && strings.HasSuffix(placeholder, "$") {
+            ref.Placeholder = placeholder[1:len(placeholder) - 1]
+        } else if name := strings.Trim(placeholder, "_"); strings.Contains(name, ":") {
+            ref.Source, ref.Library = name[:strings.LastIndex(name, ":")], name[strings.LastIndex(name, ":") + 1:]
+        } else {
+            ref.Library = name
+        }
+        ret.LinkReferences = append(ret.LinkReferences, ref)
+        b.WriteString(strings.Repeat("0", placeholderLength))
+        i += placeholderLength
+    }
+
+    code, err := hex.DecodeString(b.String())
+    if err != nil {
+        return nil, err
+    }
+    ret.Code = code
+    return ret, nil
+}
+
+// Name returns the name the library is linked by: its name if known, or its placeholder.
+func (self LinkReference) Name() string {
+    if self.Library != "" {
+        return self.Library
+    }
+    return "$" + self.Placeholder + "$"
+}
+
+// matches returns true if name identifies the library referred to, either as its name,
+// qualified by its source file as "file.sol:Name" or not, or as the placeholder solc
+// derives from the qualified name.
+func (self LinkReference) matches(name string) bool {
+    if self.Library != "" && (name == self.Library || name == self.Source + ":" + self.Library) {
+        return true
+    }
+    if self.Placeholder == "" {
+        return false
+    }
+    hash := Keccak256([]byte(name))
+    return name == self.Name() || hex.EncodeToString(hash[:17]) == self.Placeholder
+}
+
+func (self ImmutableReference) Name() string {
+    return fmt.Sprintf("immutable_%d", self.ID)
+}
+
+// Link returns a copy of the code with the addresses of libraries, keyed by any name
+// LinkReference matches, and the values of immutables, keyed by their AST ID, filled in.
+// References not supplied are left in place.
+func (self *Bytecode) Link(libraries map[string]Address, immutables map[int]*big.Int) (*Bytecode, error) {
+    ret := &Bytecode{Code: append([]byte{}, self.Code...), SourceMap: self.SourceMap}
+    for _, ref := range self.LinkReferences {
+        linked := false
+        for name, address := range libraries {
+            if ref.matches(name) {
+                if ref.Length != len(address) {
+                    return nil, fmt.Errorf("link reference to %v at %d is %d bytes", ref.Name(), ref.Start, ref.Length)
+                }
+                copy(ret.Code[ref.Start:], address[:])
+                linked = true
+                break
+            }
+        }
+        if !linked {
+            ret.LinkReferences = append(ret.LinkReferences, ref)
+        }
+    }
+    for _, ref := range self.ImmutableReferences {
+        value, ok := immutables[ref.ID]
+        if !ok {
+            ret.ImmutableReferences = append(ret.ImmutableReferences, ref)
+            continue
+        }
+        if value.Sign() < 0 || (value.BitLen() + 7) / 8 > ref.Length {
+            return nil, fmt.Errorf("value for %v does not fit in %d bytes", ref.Name(), ref.Length)
+        }
+        value.FillBytes(ret.Code[ref.Start:ref.Start + ref.Length])
+    }
+    return ret, nil
+}
+
+// NewProgramFromBytecode analyses code as NewProgramWithOptions does, treating the
+// operands of PUSHes that hold unlinked library addresses or immutables as unknown
+// values named by the reference, rather than the zeroes in the code.
+func NewProgramFromBytecode(ctx context.Context, code *Bytecode, opts *Options) (*Program, error) {
+    program := decodeProgram(code.Code)
+    for _, ref := range code.LinkReferences {
+        program.markSymbol(ref.Name(), ref.Start, ref.Length)
+    }
+    for _, ref := range code.ImmutableReferences {
+        program.markSymbol(ref.Name(), ref.Start, ref.Length)
+    }
+    return program, program.analyse(ctx, opts)
+}
+
+// markSymbol names the operand of the PUSH whose immediate is the length bytes at start.
+// If there is no such PUSH the diagnostic goes on the instruction that precedes start.
+func (self *Program) markSymbol(name string, start, length int) {
+    owner := start - 1
+    for pc := start - 1; pc >= 0 && pc >= start - 32; pc-- {
+        inst, ok := self.Instructions[pc]
+        if !ok {
+            continue
+        }
+        if inst.Op.IsPush() && pc + 1 <= start && start + length <= pc + 1 + inst.Op.OperandSize() {
+            inst.Symbol = name
+            return
+        }
+        owner = pc
+        break
+    }
+    if owner < 0 {
+        owner = 0
+    }
+    self.diagnose(owner, "%v is not the operand of a PUSH", name)
+}
@@ -0,0 +1,345 @@
+package evmopt
+
+import (
+    "fmt"
+    "sort"
+)
+
+// DominatorTree records the immediate dominator of every block reachable from its root.
+type DominatorTree struct {
+    Root *BasicBlock
+    idom map[*BasicBlock]*BasicBlock
+    children map[*BasicBlock][]*BasicBlock
+}
+
+func newDominatorTree(root *BasicBlock, succs, preds func(*BasicBlock) []*BasicBlock) *DominatorTree {
+    tree := &DominatorTree{
+        Root: root,
+        idom: immediateDominators(root, succs, preds),
+        children: make(map[*BasicBlock][]*BasicBlock),
+    }
+    for block, idom := range tree.idom {
+        if block != root {
+            tree.children[idom] = append(tree.children[idom], block)
+        }
+    }
+    for _, children := range tree.children {
+        sortBlocks(children)
+    }
+    return tree
+}
+
+// Contains returns true if block is reachable from the root, and so in the tree.
+func (self *DominatorTree) Contains(block *BasicBlock) bool {
+    _, ok := self.idom[block]
+    return ok
+}
+
+// Idom returns the immediate dominator of block, or nil for the root and blocks not in the tree.
+func (self *DominatorTree) Idom(block *BasicBlock) *BasicBlock {
+    if block == self.Root {
+        return nil
+    }
+    return self.idom[block]
+}
+
+// Children returns the blocks block immediately dominates, ordered by ID.
+func (self *DominatorTree) Children(block *BasicBlock) []*BasicBlock {
+    return self.children[block]
+}
+
+// Dominates returns true if every path from the root to b passes through a. Every
+// block dominates itself.
+func (self *DominatorTree) Dominates(a, b *BasicBlock) bool {
+    return self.Contains(b) && dominates(self.idom, a, b)
+}
+
+func sortBlocks(blocks []*BasicBlock) {
+    sort.Slice(blocks, func(i, j int) bool { return blocks[i].ID < blocks[j].ID })
+}
+
+func reachableFrom(block *BasicBlock, succs func(*BasicBlock) []*BasicBlock) map[*BasicBlock]bool {
+    seen := map[*BasicBlock]bool{block: true}
+    worklist := []*BasicBlock{block}
+    for len(worklist) > 0 {
+        b := worklist[len(worklist) - 1]
+        worklist = worklist[:len(worklist) - 1]
+        for _, succ := range succs(b) {
+            if !seen[succ] {
+                seen[succ] = true
+                worklist = append(worklist, succ)
+            }
+        }
+    }
+    return seen
+}
+
+// Dominators returns the dominator tree of the control flow graph, rooted at the block
+// at PC 0, or nil if there is no code.
+func (self *Program) Dominators() *DominatorTree {
+    entry := self.BlockAt(0)
+    if entry == nil {
+        return nil
+    }
+    succs := func(b *BasicBlock) []*BasicBlock { return b.Successors }
+    return newDominatorTree(entry, succs, func(b *BasicBlock) []*BasicBlock { return b.Predecessors })
+}
+
+// PostDominators returns the post-dominator tree of the blocks reachable from PC 0. Its
+// root is a virtual exit block with ID -1, which succeeds every block that has no
+// successors; blocks that cannot reach an exit, such as those in infinite loops, are not
+// in the tree.
+func (self *Program) PostDominators() *DominatorTree {
+    entry := self.BlockAt(0)
+    if entry == nil {
+        return nil
+    }
+    reachable := reachableFrom(entry, func(b *BasicBlock) []*BasicBlock { return b.Successors })
+    exit := &BasicBlock{ID: -1}
+    succs := func(b *BasicBlock) (ret []*BasicBlock) {
+        if b == exit {
+            for block := range reachable {
+                if len(block.Successors) == 0 {
+                    ret = append(ret, block)
+                }
+            }
+            sortBlocks(ret)
+            return ret
+        }
+        for _, pred := range b.Predecessors {
+            if reachable[pred] {
+                ret = append(ret, pred)
+            }
+        }
+        return ret
+    }
+    preds := func(b *BasicBlock) []*BasicBlock {
+        if b == exit {
+            return nil
+        }
+        if len(b.Successors) == 0 {
+            return []*BasicBlock{exit}
+        }
+        return b.Successors
+    }
+    return newDominatorTree(exit, succs, preds)
+}
+
+type Edge struct {
+    From *BasicBlock
+    To *BasicBlock
+}
+
+func (self Edge) String() string {
+    return fmt.Sprintf("%d->%d", self.From.ID, self.To.ID)
+}
+
+// Loop is a strongly connected region of the control flow graph. A natural loop has a
+// single entry, its header, which dominates the rest of the body. A region that can be
+// entered at more than one block is irreducible; it has no header and is reported with
+// all its entries.
+type Loop struct {
+    Header *BasicBlock              // The only entry of a natural loop; nil if Irreducible
+    Irreducible bool
+    Entries []*BasicBlock           // Blocks in the body with a predecessor outside it
+    Body []*BasicBlock              // Every block in the loop, including nested loops, by ID
+    BackEdges []Edge                // Edges from the body to an entry
+    Exits []*BasicBlock             // Blocks outside the body with a predecessor in it, by ID
+    Parent *Loop                    // Innermost enclosing loop, or nil
+    Children []*Loop                // Loops nested directly inside this one
+    Depth int                       // Nesting depth; 1 for outermost loops
+    contains map[*BasicBlock]bool
+}
+
+// Contains returns true if block is in the body of the loop.
+func (self *Loop) Contains(block *BasicBlock) bool {
+    return self.contains[block]
+}
+
+func (self *Loop) String() string {
+    if self.Irreducible {
+        ids := make([]int, len(self.Entries))
+        for i, entry := range self.Entries {
+            ids[i] = entry.ID
+        }
+        return fmt.Sprintf("irreducible loop entered at blocks %v (%d blocks)", ids, len(self.Body))
+    }
+    return fmt.Sprintf("loop at %v (%d blocks)", self.Header, len(self.Body))
+}
+
+// Loops returns the loops in the code reachable from PC 0, with every loop before those
+// nested inside it. Calls to internal functions are treated as edges to their return
+// address and each function's loops are found from its entry, so a function called
+// from several places does not appear to be a loop.
+func (self *Program) Loops() []*Loop {
+    entry := self.BlockAt(0)
+    if entry == nil {
+        return nil
+    }
+
+    internals := self.InternalFunctions()
+    calls := make(map[int]*CallSite)
+    returns := make(map[int]bool)
+    for _, fn := range internals {
+        for _, call := range fn.CallSites {
+            calls[call.Jump] = call
+        }
+        for _, pc := range fn.Exits {
+            returns[pc] = true
+        }
+    }
+    succs := func(b *BasicBlock) []*BasicBlock {
+        if call, ok := calls[b.End]; ok {
+            return []*BasicBlock{self.BlockAt(call.Return)}
+        }
+        if returns[b.End] {
+            return nil
+        }
+        return b.Successors
+    }
+    predecessors := make(map[*BasicBlock][]*BasicBlock)
+    for _, block := range self.Blocks {
+        for _, succ := range succs(block) {
+            predecessors[succ] = append(predecessors[succ], block)
+        }
+    }
+    preds := func(b *BasicBlock) []*BasicBlock { return predecessors[b] }
+
+    loops := findLoops(entry, succs, preds)
+    for _, fn := range internals {
+        loops = append(loops, findLoops(self.BlockAt(fn.Entry), succs, preds)...)
+    }
+    return loops
+}
+
+// findLoops builds the loop nesting forest of the graph reachable from root. Each
+// strongly connected component with a cycle is a loop; its entries are removed and the
+// rest of the component searched again for nested loops.
+func findLoops(root *BasicBlock, succs, preds func(*BasicBlock) []*BasicBlock) (loops []*Loop) {
+    reachable := reachableFrom(root, succs)
+    var search func(nodes map[*BasicBlock]bool, parent *Loop)
+    search = func(nodes map[*BasicBlock]bool, parent *Loop) {
+        for _, component := range stronglyConnected(nodes, succs) {
+            if len(component) == 1 && !containsBlock(succs(component[0]), component[0]) {
+                continue
+            }
+            loop := &Loop{Parent: parent, Depth: 1, contains: make(map[*BasicBlock]bool)}
+            for _, block := range component {
+                loop.contains[block] = true
+            }
+
+            isEntry := make(map[*BasicBlock]bool)
+            for _, block := range component {
+                if block == root {
+                    isEntry[block] = true
+                }
+                for _, pred := range preds(block) {
+                    if reachable[pred] && !loop.contains[pred] {
+                        isEntry[block] = true
+                    }
+                }
+            }
+            for _, block := range component {
+                if isEntry[block] {
+                    loop.Entries = append(loop.Entries, block)
+                }
+                for _, succ := range succs(block) {
+                    if isEntry[succ] && loop.contains[succ] {
+                        loop.BackEdges = append(loop.BackEdges, Edge{block, succ})
+                    } else if !loop.contains[succ] && !containsBlock(loop.Exits, succ) {
+                        loop.Exits = append(loop.Exits, succ)
+                    }
+                }
+            }
+            sortBlocks(loop.Exits)
+            loop.Body = component
+            if len(loop.Entries) == 1 {
+                loop.Header = loop.Entries[0]
+            } else {
+                loop.Irreducible = true
+            }
+            if parent != nil {
+                loop.Depth = parent.Depth + 1
+                parent.Children = append(parent.Children, loop)
+            }
+            loops = append(loops, loop)
+
+            inner := make(map[*BasicBlock]bool)
+            for _, block := range component {
+                if !isEntry[block] {
+                    inner[block] = true
+                }
+            }
+            search(inner, loop)
+        }
+    }
+    search(reachable, nil)
+    return loops
+}
+
+func containsBlock(blocks []*BasicBlock, block *BasicBlock) bool {
+    for _, b := range blocks {
+        if b == block {
+            return true
+        }
+    }
+    return false
+}
+
+// stronglyConnected returns the strongly connected components of the subgraph induced
+// by nodes, each sorted by ID, ordered by their lowest ID.
+func stronglyConnected(nodes map[*BasicBlock]bool, succs func(*BasicBlock) []*BasicBlock) [][]*BasicBlock {
+    order := make([]*BasicBlock, 0, len(nodes))
+    for block := range nodes {
+        order = append(order, block)
+    }
+    sortBlocks(order)
+
+    // Tarjan's algorithm
+    index := make(map[*BasicBlock]int)
+    lowlink := make(map[*BasicBlock]int)
+    onStack := make(map[*BasicBlock]bool)
+    var stack []*BasicBlock
+    var components [][]*BasicBlock
+    var connect func(*BasicBlock)
+    connect = func(block *BasicBlock) {
+        index[block] = len(index)
+        lowlink[block] = index[block]
+        stack = append(stack, block)
+        onStack[block] = true
+        for _, succ := range succs(block) {
+            if !nodes[succ] {
+                continue
+            }
+            if _, ok := index[succ]; !ok {
+                connect(succ)
+                if lowlink[succ] < lowlink[block] {
+                    lowlink[block] = lowlink[succ]
+                }
+            } else if onStack[succ] && index[succ] < lowlink[block] {
+                lowlink[block] = index[succ]
+            }
+        }
+        if lowlink[block] == index[block] {
+            var component []*BasicBlock
+            for {
+                b := stack[len(stack) - 1]
+                stack = stack[:len(stack) - 1]
+                onStack[b] = false
+                component = append(component, b)
+                if b == block {
+                    break
+                }
+            }
+            sortBlocks(component)
+            components = append(components, component)
+        }
+    }
+    for _, block := range order {
+        if _, ok := index[block]; !ok {
+            connect(block)
+        }
+    }
+    sort.Slice(components, func(i, j int) bool { return components[i][0].ID < components[j][0].ID })
+    return components
+}
@@ -0,0 +1,90 @@
+package evmopt
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func blockStarts(blocks []*BasicBlock) string {
+    starts := make([]string, len(blocks))
+    for i, block := range blocks {
+        starts[i] = fmt.Sprintf("0x%X", block.Start)
+    }
+    return "[" + strings.Join(starts, " ") + "]"
+}
+
+// Small CFGs, with the blocks they split into
+var (
+    // 0x0 branches to 0x6 or 0xB, which both reach 0xE
+    diamondCode = "600035600b576001600e565b60025b60005500"
+    // 0x2 loops to itself until 0xD
+    loopCode = "60005b60010180600a1060025700"
+    // The outer loop 0x2 to 0x10 contains the inner loop 0x5, and exits to 0x1B
+    nestedCode = "60005b60005b600101806003116005575060010180600311600257" + "00"
+    // 0x0 enters a cycle between 0x6 and 0xE at either block; they exit to 0xD and 0x15
+    irreducibleCode = "600035600e575b600135600e57005b600235600657" + "00"
+)
+
+func TestDominators(t *testing.T) {
+    tests := []struct {
+        code string
+        idoms string                // Immediate dominator of every block after the first
+        ipdoms string               // Immediate post-dominator of every block that can reach an exit
+    }{
+        {diamondCode, "0x6:0x0 0xB:0x0 0xE:0x0", "0x0:0xE 0x6:0xE 0xB:0xE 0xE:exit"},
+        {loopCode, "0x2:0x0 0xD:0x2", "0x0:0x2 0x2:0xD 0xD:exit"},
+        {nestedCode, "0x2:0x0 0x5:0x2 0x10:0x5 0x1B:0x10", "0x0:0x2 0x2:0x5 0x5:0x10 0x10:0x1B 0x1B:exit"},
+        {irreducibleCode, "0x6:0x0 0xD:0x6 0xE:0x0 0x15:0xE", "0x0:exit 0x6:exit 0xD:exit 0xE:exit 0x15:exit"},
+    }
+
+    format := func(prog *Program, tree *DominatorTree) string {
+        var pairs []string
+        for _, block := range prog.Blocks {
+            if idom := tree.Idom(block); idom != nil && idom.ID == -1 {
+                pairs = append(pairs, fmt.Sprintf("0x%X:exit", block.Start))
+            } else if idom != nil {
+                pairs = append(pairs, fmt.Sprintf("0x%X:0x%X", block.Start, idom.Start))
+            }
+        }
+        return strings.Join(pairs, " ")
+    }
+    for _, tt := range tests {
+        prog := NewProgram(mustDecodeHex(t, tt.code))
+        if got := format(prog, prog.Dominators()); got != tt.idoms {
+            t.Errorf("%v: got dominators %v, want %v", tt.code, got, tt.idoms)
+        }
+        if got := format(prog, prog.PostDominators()); got != tt.ipdoms {
+            t.Errorf("%v: got post-dominators %v, want %v", tt.code, got, tt.ipdoms)
+        }
+    }
+}
+
+func TestLoops(t *testing.T) {
+    tests := []struct {
+        code string
+        loops []string              // Outermost first: entries, body, exits and depth
+    }{
+        {diamondCode, nil},
+        {loopCode, []string{"[0x2] [0x2] [0xD] 1"}},
+        {nestedCode, []string{"[0x2] [0x2 0x5 0x10] [0x1B] 1", "[0x5] [0x5] [0x10] 2"}},
+        {irreducibleCode, []string{"irreducible [0x6 0xE] [0x6 0xE] [0xD 0x15] 1"}},
+    }
+
+    for _, tt := range tests {
+        prog := NewProgram(mustDecodeHex(t, tt.code))
+        var got []string
+        for _, loop := range prog.Loops() {
+            desc := fmt.Sprintf("%v %v %v %d", blockStarts(loop.Entries), blockStarts(loop.Body), blockStarts(loop.Exits), loop.Depth)
+            if loop.Irreducible {
+                desc = "irreducible " + desc
+            } else if len(loop.Entries) != 1 || loop.Header != loop.Entries[0] {
+                t.Errorf("%v: loop at %v has entries %v", tt.code, loop.Header, blockStarts(loop.Entries))
+            }
+            got = append(got, desc)
+        }
+        if strings.Join(got, ", ") != strings.Join(tt.loops, ", ") {
+            t.Errorf("%v: got loops %v, want %v", tt.code, got, tt.loops)
+        }
+    }
+}
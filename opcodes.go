@@ -8,7 +8,7 @@ type OpCode byte
 
 func (op OpCode) IsPush() bool {
     switch op {
-    case PUSH1, PUSH2, PUSH3, PUSH4, PUSH5, PUSH6, PUSH7, PUSH8, PUSH9, PUSH10, PUSH11, PUSH12, PUSH13, PUSH14, PUSH15, PUSH16, PUSH17, PUSH18, PUSH19, PUSH20, PUSH21, PUSH22, PUSH23, PUSH24, PUSH25, PUSH26, PUSH27, PUSH28, PUSH29, PUSH30, PUSH31, PUSH32:
+    case PUSH0, PUSH1, PUSH2, PUSH3, PUSH4, PUSH5, PUSH6, PUSH7, PUSH8, PUSH9, PUSH10, PUSH11, PUSH12, PUSH13, PUSH14, PUSH15, PUSH16, PUSH17, PUSH18, PUSH19, PUSH20, PUSH21, PUSH22, PUSH23, PUSH24, PUSH25, PUSH26, PUSH27, PUSH28, PUSH29, PUSH30, PUSH31, PUSH32:
         return true
     }
     return false
@@ -70,6 +70,9 @@ const (
     XOR
     NOT
     BYTE
+    SHL
+    SHR
+    SAR
 
     SHA3 = 0x20
 )
@@ -89,6 +92,9 @@ const (
     GASPRICE
     EXTCODESIZE
     EXTCODECOPY
+    RETURNDATASIZE
+    RETURNDATACOPY
+    EXTCODEHASH
 )
 
 const (
@@ -100,6 +106,11 @@ const (
     NUMBER
     DIFFICULTY
     GASLIMIT
+    CHAINID
+    SELFBALANCE
+    BASEFEE
+    BLOBHASH
+    BLOBBASEFEE
 )
 
 const (
@@ -116,6 +127,10 @@ const (
     MSIZE
     GAS
     JUMPDEST
+    TLOAD
+    TSTORE
+    MCOPY
+    PUSH0
 )
 
 const (
@@ -201,7 +216,11 @@ const (
     CALLCODE
     RETURN
     DELEGATECALL
+    CREATE2
 
+    STATICCALL   = 0xfa
+    REVERT       = 0xfd
+    INVALID      = 0xfe
     SELFDESTRUCT = 0xff
 )
 
@@ -231,6 +250,9 @@ var opCodeToString = map[OpCode]string{
     OR:     "OR",
     XOR:    "XOR",
     BYTE:   "BYTE",
+    SHL:    "SHL",
+    SHR:    "SHR",
+    SAR:    "SAR",
     ADDMOD: "ADDMOD",
     MULMOD: "MULMOD",
 
@@ -249,6 +271,9 @@ var opCodeToString = map[OpCode]string{
     CODESIZE:     "CODESIZE",
     CODECOPY:     "CODECOPY",
     GASPRICE:     "TXGASPRICE",
+    RETURNDATASIZE: "RETURNDATASIZE",
+    RETURNDATACOPY: "RETURNDATACOPY",
+    EXTCODEHASH:    "EXTCODEHASH",
 
     // 0x40 range - block operations
     BLOCKHASH:   "BLOCKHASH",
@@ -257,6 +282,11 @@ var opCodeToString = map[OpCode]string{
     NUMBER:      "NUMBER",
     DIFFICULTY:  "DIFFICULTY",
     GASLIMIT:    "GASLIMIT",
+    CHAINID:     "CHAINID",
+    SELFBALANCE: "SELFBALANCE",
+    BASEFEE:     "BASEFEE",
+    BLOBHASH:    "BLOBHASH",
+    BLOBBASEFEE: "BLOBBASEFEE",
     EXTCODESIZE: "EXTCODESIZE",
     EXTCODECOPY: "EXTCODECOPY",
 
@@ -275,8 +305,12 @@ var opCodeToString = map[OpCode]string{
     MSIZE:    "MSIZE",
     GAS:      "GAS",
     JUMPDEST: "JUMPDEST",
+    TLOAD:    "TLOAD",
+    TSTORE:   "TSTORE",
+    MCOPY:    "MCOPY",
 
     // 0x60 range - push
+    PUSH0:  "PUSH0",
     PUSH1:  "PUSH1",
     PUSH2:  "PUSH2",
     PUSH3:  "PUSH3",
@@ -355,6 +389,10 @@ var opCodeToString = map[OpCode]string{
     RETURN:       "RETURN",
     CALLCODE:     "CALLCODE",
     DELEGATECALL: "DELEGATECALL",
+    CREATE2:      "CREATE2",
+    STATICCALL:   "STATICCALL",
+    REVERT:       "REVERT",
+    INVALID:      "INVALID",
     SELFDESTRUCT: "SELFDESTRUCT",
 }
 
@@ -385,13 +423,16 @@ var opCodeToStackReads = map[OpCode]int{
     SGT:        2,
     EQ:         2,
     ISZERO:     1,
-    SIGNEXTEND: 1,
+    SIGNEXTEND: 2,
 
     // 0x10 range - bit ops
     AND:    2,
     OR:     2,
     XOR:    2,
     BYTE:   2,
+    SHL:    2,
+    SHR:    2,
+    SAR:    2,
     ADDMOD: 3,
     MULMOD: 3,
 
@@ -410,6 +451,9 @@ var opCodeToStackReads = map[OpCode]int{
     CODESIZE:     0,
     CODECOPY:     3,
     GASPRICE:     0,
+    RETURNDATASIZE: 0,
+    RETURNDATACOPY: 3,
+    EXTCODEHASH:    1,
 
     // 0x40 range - block operations
     BLOCKHASH:   1,
@@ -418,6 +462,11 @@ var opCodeToStackReads = map[OpCode]int{
     NUMBER:      0,
     DIFFICULTY:  0,
     GASLIMIT:    0,
+    CHAINID:     0,
+    SELFBALANCE: 0,
+    BASEFEE:     0,
+    BLOBHASH:    1,
+    BLOBBASEFEE: 0,
     EXTCODESIZE: 1,
     EXTCODECOPY: 4,
 
@@ -436,8 +485,12 @@ var opCodeToStackReads = map[OpCode]int{
     MSIZE:    0,
     GAS:      0,
     JUMPDEST: 0,
+    TLOAD:    1,
+    TSTORE:   2,
+    MCOPY:    3,
 
     // 0x60 range - push
+    PUSH0:  0,
     PUSH1:  0,
     PUSH2:  0,
     PUSH3:  0,
@@ -515,7 +568,11 @@ var opCodeToStackReads = map[OpCode]int{
     CALL:         7,
     RETURN:       2,
     CALLCODE:     7,
-    DELEGATECALL: 7,
+    DELEGATECALL: 6,
+    CREATE2:      4,
+    STATICCALL:   6,
+    REVERT:       2,
+    INVALID:      0,
     SELFDESTRUCT: 1,
 }
 
@@ -548,6 +605,9 @@ var opCodeToStackWrites = map[OpCode]int{
     OR:     1,
     XOR:    1,
     BYTE:   1,
+    SHL:    1,
+    SHR:    1,
+    SAR:    1,
     ADDMOD: 1,
     MULMOD: 1,
 
@@ -566,6 +626,9 @@ var opCodeToStackWrites = map[OpCode]int{
     CODESIZE:     1,
     CODECOPY:     0,
     GASPRICE:     1,
+    RETURNDATASIZE: 1,
+    RETURNDATACOPY: 0,
+    EXTCODEHASH:    1,
 
     // 0x40 range - block operations
     BLOCKHASH:   1,
@@ -574,6 +637,11 @@ var opCodeToStackWrites = map[OpCode]int{
     NUMBER:      1,
     DIFFICULTY:  1,
     GASLIMIT:    1,
+    CHAINID:     1,
+    SELFBALANCE: 1,
+    BASEFEE:     1,
+    BLOBHASH:    1,
+    BLOBBASEFEE: 1,
     EXTCODESIZE: 1,
     EXTCODECOPY: 0,
 
@@ -592,8 +660,12 @@ var opCodeToStackWrites = map[OpCode]int{
     MSIZE:    1,
     GAS:      1,
     JUMPDEST: 0,
+    TLOAD:    1,
+    TSTORE:   0,
+    MCOPY:    0,
 
     // 0x60 range - push
+    PUSH0:  1,
     PUSH1:  1,
     PUSH2:  1,
     PUSH3:  1,
@@ -672,6 +744,10 @@ var opCodeToStackWrites = map[OpCode]int{
     RETURN:       0,
     CALLCODE:     1,
     DELEGATECALL: 1,
+    CREATE2:      1,
+    STATICCALL:   1,
+    REVERT:       0,
+    INVALID:      0,
     SELFDESTRUCT: 0,
 }
 
@@ -679,6 +755,220 @@ func (o OpCode) StackWrites() int {
     return opCodeToStackWrites[o]
 }
 
+// Gas charged for each opcode in Frontier, before any dynamic costs; StaticGas applies
+// the repricings of later forks
+var opCodeToGas = map[OpCode]uint64{
+    // 0x0 range - arithmetic ops
+    STOP:       gasZero,
+    ADD:        gasVeryLow,
+    MUL:        gasLow,
+    SUB:        gasVeryLow,
+    DIV:        gasLow,
+    SDIV:       gasLow,
+    MOD:        gasLow,
+    SMOD:       gasLow,
+    EXP:        gasHigh,
+    NOT:        gasVeryLow,
+    LT:         gasVeryLow,
+    GT:         gasVeryLow,
+    SLT:        gasVeryLow,
+    SGT:        gasVeryLow,
+    EQ:         gasVeryLow,
+    ISZERO:     gasVeryLow,
+    SIGNEXTEND: gasLow,
+
+    // 0x10 range - bit ops
+    AND:    gasVeryLow,
+    OR:     gasVeryLow,
+    XOR:    gasVeryLow,
+    BYTE:   gasVeryLow,
+    SHL:    gasVeryLow,
+    SHR:    gasVeryLow,
+    SAR:    gasVeryLow,
+    ADDMOD: gasMid,
+    MULMOD: gasMid,
+
+    // 0x20 range - crypto
+    SHA3: 30,
+
+    // 0x30 range - closure state
+    ADDRESS:      gasBase,
+    BALANCE:      20,
+    ORIGIN:       gasBase,
+    CALLER:       gasBase,
+    CALLVALUE:    gasBase,
+    CALLDATALOAD: gasVeryLow,
+    CALLDATASIZE: gasBase,
+    CALLDATACOPY: gasVeryLow,
+    CODESIZE:     gasBase,
+    CODECOPY:     gasVeryLow,
+    GASPRICE:     gasBase,
+    RETURNDATASIZE: gasBase,
+    RETURNDATACOPY: gasVeryLow,
+    EXTCODEHASH:    400,
+
+    // 0x40 range - block operations
+    BLOCKHASH:   20,
+    COINBASE:    gasBase,
+    TIMESTAMP:   gasBase,
+    NUMBER:      gasBase,
+    DIFFICULTY:  gasBase,
+    GASLIMIT:    gasBase,
+    CHAINID:     gasBase,
+    SELFBALANCE: gasLow,
+    BASEFEE:     gasBase,
+    BLOBHASH:    gasVeryLow,
+    BLOBBASEFEE: gasBase,
+    EXTCODESIZE: 20,
+    EXTCODECOPY: 20,
+
+    // 0x50 range - 'storage' and execution
+    POP: gasBase,
+    //DUP:     "DUP",
+    //SWAP:    "SWAP",
+    MLOAD:    gasVeryLow,
+    MSTORE:   gasVeryLow,
+    MSTORE8:  gasVeryLow,
+    SLOAD:    50,
+    SSTORE:   gasZero,
+    JUMP:     gasMid,
+    JUMPI:    gasHigh,
+    PC:       gasBase,
+    MSIZE:    gasBase,
+    GAS:      gasBase,
+    JUMPDEST: 1,
+    TLOAD:    gasWarmAccess,
+    TSTORE:   gasWarmAccess,
+    MCOPY:    gasVeryLow,
+
+    // 0x60 range - push
+    PUSH0:  gasBase,
+    PUSH1:  gasVeryLow,
+    PUSH2:  gasVeryLow,
+    PUSH3:  gasVeryLow,
+    PUSH4:  gasVeryLow,
+    PUSH5:  gasVeryLow,
+    PUSH6:  gasVeryLow,
+    PUSH7:  gasVeryLow,
+    PUSH8:  gasVeryLow,
+    PUSH9:  gasVeryLow,
+    PUSH10: gasVeryLow,
+    PUSH11: gasVeryLow,
+    PUSH12: gasVeryLow,
+    PUSH13: gasVeryLow,
+    PUSH14: gasVeryLow,
+    PUSH15: gasVeryLow,
+    PUSH16: gasVeryLow,
+    PUSH17: gasVeryLow,
+    PUSH18: gasVeryLow,
+    PUSH19: gasVeryLow,
+    PUSH20: gasVeryLow,
+    PUSH21: gasVeryLow,
+    PUSH22: gasVeryLow,
+    PUSH23: gasVeryLow,
+    PUSH24: gasVeryLow,
+    PUSH25: gasVeryLow,
+    PUSH26: gasVeryLow,
+    PUSH27: gasVeryLow,
+    PUSH28: gasVeryLow,
+    PUSH29: gasVeryLow,
+    PUSH30: gasVeryLow,
+    PUSH31: gasVeryLow,
+    PUSH32: gasVeryLow,
+
+    DUP1:  gasVeryLow,
+    DUP2:  gasVeryLow,
+    DUP3:  gasVeryLow,
+    DUP4:  gasVeryLow,
+    DUP5:  gasVeryLow,
+    DUP6:  gasVeryLow,
+    DUP7:  gasVeryLow,
+    DUP8:  gasVeryLow,
+    DUP9:  gasVeryLow,
+    DUP10: gasVeryLow,
+    DUP11: gasVeryLow,
+    DUP12: gasVeryLow,
+    DUP13: gasVeryLow,
+    DUP14: gasVeryLow,
+    DUP15: gasVeryLow,
+    DUP16: gasVeryLow,
+
+    SWAP1:  gasVeryLow,
+    SWAP2:  gasVeryLow,
+    SWAP3:  gasVeryLow,
+    SWAP4:  gasVeryLow,
+    SWAP5:  gasVeryLow,
+    SWAP6:  gasVeryLow,
+    SWAP7:  gasVeryLow,
+    SWAP8:  gasVeryLow,
+    SWAP9:  gasVeryLow,
+    SWAP10: gasVeryLow,
+    SWAP11: gasVeryLow,
+    SWAP12: gasVeryLow,
+    SWAP13: gasVeryLow,
+    SWAP14: gasVeryLow,
+    SWAP15: gasVeryLow,
+    SWAP16: gasVeryLow,
+    LOG0:   375,
+    LOG1:   375 + gasLogTopic,
+    LOG2:   375 + 2 * gasLogTopic,
+    LOG3:   375 + 3 * gasLogTopic,
+    LOG4:   375 + 4 * gasLogTopic,
+
+    // 0xf0 range
+    CREATE:       gasCreate,
+    CALL:         40,
+    RETURN:       gasZero,
+    CALLCODE:     40,
+    DELEGATECALL: 40,
+    CREATE2:      gasCreate,
+    STATICCALL:   40,
+    REVERT:       gasZero,
+    INVALID:      gasZero,
+    SELFDESTRUCT: gasZero,
+}
+
+// StaticGas returns the gas charged for the opcode under the given fork before any
+// dynamic costs. From Berlin, ops that access accounts or storage are charged the warm
+// access cost here, and the cold surcharge dynamically.
+func (o OpCode) StaticGas(fork Fork) uint64 {
+    fork = fork.resolve()
+    switch o {
+    case BALANCE:
+        switch {
+        case fork >= Berlin: return gasWarmAccess
+        case fork >= Istanbul: return 700
+        case fork >= TangerineWhistle: return 400
+        }
+    case EXTCODESIZE, EXTCODECOPY:
+        switch {
+        case fork >= Berlin: return gasWarmAccess
+        case fork >= TangerineWhistle: return 700
+        }
+    case EXTCODEHASH:
+        switch {
+        case fork >= Berlin: return gasWarmAccess
+        case fork >= Istanbul: return 700
+        }
+    case SLOAD:
+        switch {
+        case fork >= Berlin: return gasWarmAccess
+        case fork >= Istanbul: return 800
+        case fork >= TangerineWhistle: return 200
+        }
+    case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+        switch {
+        case fork >= Berlin: return gasWarmAccess
+        case fork >= TangerineWhistle: return 700
+        }
+    case SELFDESTRUCT:
+        if fork >= TangerineWhistle {
+            return 5000
+        }
+    }
+    return opCodeToGas[o]
+}
+
 var stringToOp = map[string]OpCode{
     "STOP":         STOP,
     "ADD":          ADD,
@@ -701,6 +991,9 @@ var stringToOp = map[string]OpCode{
     "OR":           OR,
     "XOR":          XOR,
     "BYTE":         BYTE,
+    "SHL":          SHL,
+    "SHR":          SHR,
+    "SAR":          SAR,
     "ADDMOD":       ADDMOD,
     "MULMOD":       MULMOD,
     "SHA3":         SHA3,
@@ -716,12 +1009,20 @@ var stringToOp = map[string]OpCode{
     "CODESIZE":     CODESIZE,
     "CODECOPY":     CODECOPY,
     "GASPRICE":     GASPRICE,
+    "RETURNDATASIZE": RETURNDATASIZE,
+    "RETURNDATACOPY": RETURNDATACOPY,
+    "EXTCODEHASH":    EXTCODEHASH,
     "BLOCKHASH":    BLOCKHASH,
     "COINBASE":     COINBASE,
     "TIMESTAMP":    TIMESTAMP,
     "NUMBER":       NUMBER,
     "DIFFICULTY":   DIFFICULTY,
     "GASLIMIT":     GASLIMIT,
+    "CHAINID":      CHAINID,
+    "SELFBALANCE":  SELFBALANCE,
+    "BASEFEE":      BASEFEE,
+    "BLOBHASH":     BLOBHASH,
+    "BLOBBASEFEE":  BLOBBASEFEE,
     "EXTCODESIZE":  EXTCODESIZE,
     "EXTCODECOPY":  EXTCODECOPY,
     "POP":          POP,
@@ -736,6 +1037,10 @@ var stringToOp = map[string]OpCode{
     "MSIZE":        MSIZE,
     "GAS":          GAS,
     "JUMPDEST":     JUMPDEST,
+    "TLOAD":        TLOAD,
+    "TSTORE":       TSTORE,
+    "MCOPY":        MCOPY,
+    "PUSH0":        PUSH0,
     "PUSH1":        PUSH1,
     "PUSH2":        PUSH2,
     "PUSH3":        PUSH3,
@@ -809,6 +1114,10 @@ var stringToOp = map[string]OpCode{
     "CALL":         CALL,
     "RETURN":       RETURN,
     "CALLCODE":     CALLCODE,
+    "CREATE2":      CREATE2,
+    "STATICCALL":   STATICCALL,
+    "REVERT":       REVERT,
+    "INVALID":      INVALID,
     "SELFDESTRUCT": SELFDESTRUCT,
 }
 
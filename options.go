@@ -0,0 +1,94 @@
+package evmopt
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+)
+
+// ErrBudgetExceeded is returned when analysis stops early because it hit
+// the state or time limits set in Options.
+var ErrBudgetExceeded = errors.New("analysis budget exceeded")
+
+// Tracer receives a callback for every state processed during analysis.
+type Tracer interface {
+    CaptureState(pc int, inst *Instruction, stack *StackFrame)
+}
+
+// LogTracer writes every analysis state to the standard logger.
+type LogTracer struct {
+    count int
+}
+
+func (self *LogTracer) CaptureState(pc int, inst *Instruction, stack *StackFrame) {
+    log.Printf("%v PC: 0x%X, op: %v, stack: %v", self.count, pc, inst, stack)
+    self.count += 1
+}
+
+type Options struct {
+    Fork Fork                   // Fork whose semantics to analyse under; zero selects LatestFork
+    Tracer Tracer               // Optional tracer invoked for each analysis state
+    MaxStates int               // Maximum number of analysis states to process; zero for no limit
+    Timeout time.Duration       // Maximum time to spend on analysis; zero for no limit
+    ContextDepth int            // Enclosing calls whose return addresses distinguish analysis states; zero merges all states at a PC
+}
+
+type Status int
+
+const (
+    Complete Status = iota      // Analysis reached a fixed point
+    BudgetExceeded              // Analysis stopped at MaxStates or Timeout; results are partial
+    Cancelled                   // The caller's context was cancelled; results are partial
+)
+
+func (self Status) String() string {
+    switch self {
+    case Complete:
+        return "complete"
+    case BudgetExceeded:
+        return "budget exceeded"
+    case Cancelled:
+        return "cancelled"
+    }
+    return "unknown"
+}
+
+// NewProgramWithOptions decodes and analyses bytecode subject to the limits in opts,
+// which may be nil. If analysis stops early the partially analysed program is
+// returned along with ErrBudgetExceeded or the context's error, and its Status
+// records why.
+func NewProgramWithOptions(ctx context.Context, bytecode []byte, opts *Options) (*Program, error) {
+    program := decodeProgram(bytecode)
+    return program, program.analyse(ctx, opts)
+}
+
+// analyse builds the reaching definitions of a decoded program and sets its Status.
+func (self *Program) analyse(ctx context.Context, opts *Options) error {
+    if opts == nil {
+        opts = &Options{}
+    }
+    self.Fork = opts.Fork.resolve()
+
+    actx := ctx
+    if opts.Timeout > 0 {
+        var cancel context.CancelFunc
+        actx, cancel = context.WithTimeout(ctx, opts.Timeout)
+        defer cancel()
+    }
+
+    err := self.buildReachings(actx, opts)
+    switch {
+    case err == nil:
+        self.Status = Complete
+    case err == ErrBudgetExceeded:
+        self.Status = BudgetExceeded
+    case ctx.Err() == nil && !expired(ctx):
+        // Only our own deadline expired
+        self.Status = BudgetExceeded
+        err = ErrBudgetExceeded
+    default:
+        self.Status = Cancelled
+    }
+    return err
+}
@@ -0,0 +1,81 @@
+package evmopt
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+// countingTracer counts analysis states, sleeping on the first one for delay.
+type countingTracer struct {
+    states int
+    delay time.Duration
+}
+
+func (self *countingTracer) CaptureState(pc int, inst *Instruction, stack *StackFrame) {
+    if self.states == 0 {
+        time.Sleep(self.delay)
+    }
+    self.states += 1
+}
+
+func TestAnalysisBudgets(t *testing.T) {
+    code := loadContract(t, "ens_resolver")
+    full := NewProgram(code)
+    if full.Status != Complete {
+        t.Fatalf("got status %v without limits, want %v", full.Status, Complete)
+    }
+
+    cancelled, cancel := context.WithCancel(context.Background())
+    cancel()
+    tests := []struct {
+        name string
+        ctx context.Context
+        opts Options
+        status Status
+        err error
+        maxStates int                   // Most states the tracer may see, or -1 for any number
+    }{
+        {"unlimited", context.Background(), Options{}, Complete, nil, -1},
+        {"cancelled", cancelled, Options{}, Cancelled, context.Canceled, 0},
+        // The deadline passes while the first state is traced, and is seen at the next check
+        {"timeout", context.Background(), Options{Timeout: time.Millisecond, Tracer: &countingTracer{delay: 10 * time.Millisecond}}, BudgetExceeded, ErrBudgetExceeded, contextCheckInterval},
+        {"max states", context.Background(), Options{MaxStates: 50}, BudgetExceeded, ErrBudgetExceeded, 50},
+    }
+
+    for _, tt := range tests {
+        tracer, ok := tt.opts.Tracer.(*countingTracer)
+        if !ok {
+            tracer = &countingTracer{}
+            tt.opts.Tracer = tracer
+        }
+        prog, err := NewProgramWithOptions(tt.ctx, code, &tt.opts)
+        if prog == nil {
+            t.Fatalf("%v: got no program", tt.name)
+        }
+        if err != tt.err || prog.Status != tt.status {
+            t.Errorf("%v: got %v and status %v, want %v and %v", tt.name, err, prog.Status, tt.err, tt.status)
+            continue
+        }
+        if tt.maxStates >= 0 && tracer.states > tt.maxStates {
+            t.Errorf("%v: analysed %v states, want at most %v", tt.name, tracer.states, tt.maxStates)
+        }
+
+        // A partial result has every instruction and block, and operand sources that
+        // are a subset of those found by a complete analysis
+        if len(prog.Instructions) != len(full.Instructions) || len(prog.Blocks) != len(full.Blocks) {
+            t.Errorf("%v: got %v instructions in %v blocks, want %v in %v", tt.name, len(prog.Instructions), len(prog.Blocks), len(full.Instructions), len(full.Blocks))
+        }
+        for pc, inst := range prog.Instructions {
+            for i, sources := range inst.ReachedBy {
+                for source := range sources {
+                    if !full.Instructions[pc].ReachedBy[i][source] {
+                        t.Errorf("%v: operand %v of 0x%X reached by 0x%X only in the partial result", tt.name, i, pc, source)
+                    }
+                }
+            }
+        }
+        prog.ExternalFunctions()
+        prog.FunctionGas()
+    }
+}
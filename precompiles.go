@@ -0,0 +1,513 @@
+package evmopt
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "math/big"
+    "math/bits"
+)
+
+// runPrecompile executes the precompile at addr. The alt_bn128 pairing check at 0x8 and
+// the KZG point evaluation at 0xA are not implemented, and halt with HaltUnsupported.
+// Input a precompile rejects halts with HaltPrecompileFailure, consuming all the gas.
+func (self *Interpreter) runPrecompile(addr Address, input []byte, gas uint64) (ret []byte, gasLeft uint64, halt HaltReason) {
+    var cost uint64
+    ok := true
+    switch addr.Big().Int64() {
+    case 1:
+        cost = 3000
+        if cost <= gas {
+            ret = ecrecover(input)
+        }
+    case 2:
+        cost = 60 + 12 * toWords(uint64(len(input)))
+        if cost <= gas {
+            sum := sha256.Sum256(input)
+            ret = sum[:]
+        }
+    case 3:
+        cost = 600 + 120 * toWords(uint64(len(input)))
+        if cost <= gas {
+            sum := ripemd160(input)
+            ret = append(make([]byte, 12), sum[:]...)
+        }
+    case 4:
+        cost = 15 + 3 * toWords(uint64(len(input)))
+        ret = append([]byte{}, input...)
+    case 5:
+        var bounded bool
+        if cost, bounded = modexpGas(self.Fork, input); !bounded || cost > gas {
+            return nil, 0, HaltOutOfGas
+        }
+        ret = modexp(input)
+    case 6:
+        cost = 500
+        if self.Fork >= Istanbul {
+            cost = 150
+        }
+        if cost <= gas {
+            ret, ok = bn256Add(input)
+        }
+    case 7:
+        cost = 40000
+        if self.Fork >= Istanbul {
+            cost = 6000
+        }
+        if cost <= gas {
+            ret, ok = bn256ScalarMul(input)
+        }
+    case 9:
+        if len(input) != 213 {
+            return nil, 0, HaltPrecompileFailure
+        }
+        cost = uint64(binary.BigEndian.Uint32(input))
+        if cost <= gas {
+            ret, ok = blake2F(input)
+        }
+    default:
+        return nil, 0, HaltUnsupported
+    }
+    if cost > gas {
+        return nil, 0, HaltOutOfGas
+    }
+    if !ok {
+        return nil, 0, HaltPrecompileFailure
+    }
+    return ret, gas - cost, HaltReturn
+}
+
+// padInput returns input zero padded or truncated to size bytes.
+func padInput(input []byte, size int) []byte {
+    ret := make([]byte, size)
+    copy(ret, input)
+    return ret
+}
+
+// weierstrass is a curve y^2 = x^3 + b over the integers modulo p, which is 3 mod 4.
+type weierstrass struct {
+    p *big.Int
+    b *big.Int
+}
+
+// curvePoint is a point in affine coordinates; the point at infinity has a nil x.
+type curvePoint struct {
+    x, y *big.Int
+}
+
+func mustParseHex(s string) *big.Int {
+    n, ok := new(big.Int).SetString(s, 16)
+    if !ok {
+        panic("invalid hex constant " + s)
+    }
+    return n
+}
+
+var (
+    secp256k1 = &weierstrass{
+        p: mustParseHex("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"),
+        b: big.NewInt(7),
+    }
+    secp256k1N = mustParseHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+    secp256k1G = curvePoint{
+        mustParseHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+        mustParseHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+    }
+
+    bn256 = &weierstrass{
+        p: mustParseHex("30644e72e131a029b85045b68181585d97816a916871ca8d3c208c16d87cfd47"),
+        b: big.NewInt(3),
+    }
+)
+
+// onCurve returns true if (x, y) is a point of the curve with coordinates below p.
+func (self *weierstrass) onCurve(x, y *big.Int) bool {
+    if x.Cmp(self.p) >= 0 || y.Cmp(self.p) >= 0 {
+        return false
+    }
+    return new(big.Int).Mod(new(big.Int).Mul(y, y), self.p).Cmp(self.rhs(x)) == 0
+}
+
+// rhs returns x^3 + b modulo p.
+func (self *weierstrass) rhs(x *big.Int) *big.Int {
+    ret := new(big.Int).Exp(x, big.NewInt(3), self.p)
+    ret.Add(ret, self.b)
+    return ret.Mod(ret, self.p)
+}
+
+func (self *weierstrass) add(a, b curvePoint) curvePoint {
+    switch {
+    case a.x == nil:
+        return b
+    case b.x == nil:
+        return a
+    }
+    var slope *big.Int
+    if a.x.Cmp(b.x) == 0 {
+        if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+            return curvePoint{}
+        }
+        // Tangent: 3x^2 / 2y
+        slope = new(big.Int).Mul(a.x, a.x)
+        slope.Mul(slope, big.NewInt(3))
+        slope.Mul(slope, new(big.Int).ModInverse(new(big.Int).Lsh(a.y, 1), self.p))
+    } else {
+        slope = new(big.Int).Sub(b.y, a.y)
+        slope.Mul(slope, new(big.Int).ModInverse(new(big.Int).Mod(new(big.Int).Sub(b.x, a.x), self.p), self.p))
+    }
+    slope.Mod(slope, self.p)
+    x := new(big.Int).Mul(slope, slope)
+    x.Sub(x, a.x)
+    x.Sub(x, b.x)
+    x.Mod(x, self.p)
+    y := new(big.Int).Sub(a.x, x)
+    y.Mul(y, slope)
+    y.Sub(y, a.y)
+    y.Mod(y, self.p)
+    return curvePoint{x, y}
+}
+
+func (self *weierstrass) mul(a curvePoint, k *big.Int) curvePoint {
+    var ret curvePoint
+    for i := k.BitLen() - 1; i >= 0; i-- {
+        ret = self.add(ret, ret)
+        if k.Bit(i) == 1 {
+            ret = self.add(ret, a)
+        }
+    }
+    return ret
+}
+
+// ecrecover returns the address, as a word, of the key that signed a hash, given as
+// hash, v, r and s words, or nothing if the signature is invalid.
+func ecrecover(input []byte) []byte {
+    input = padInput(input, 128)
+    hash := new(big.Int).SetBytes(input[:32])
+    v := new(big.Int).SetBytes(input[32:64])
+    r := new(big.Int).SetBytes(input[64:96])
+    s := new(big.Int).SetBytes(input[96:128])
+    if !v.IsInt64() || (v.Int64() != 27 && v.Int64() != 28) {
+        return nil
+    }
+    if r.Sign() == 0 || r.Cmp(secp256k1N) >= 0 || s.Sign() == 0 || s.Cmp(secp256k1N) >= 0 {
+        return nil
+    }
+
+    // The point whose x coordinate is r, with the parity of y given by v
+    curve := secp256k1
+    y := new(big.Int).Exp(curve.rhs(r), new(big.Int).Rsh(new(big.Int).Add(curve.p, big.NewInt(1)), 2), curve.p)
+    if !curve.onCurve(r, y) {
+        return nil
+    }
+    if y.Bit(0) != uint(v.Int64() - 27) {
+        y.Sub(curve.p, y)
+    }
+
+    // key = (s * R - hash * G) / r
+    rInv := new(big.Int).ModInverse(r, secp256k1N)
+    u1 := new(big.Int).Mul(new(big.Int).Sub(secp256k1N, new(big.Int).Mod(hash, secp256k1N)), rInv)
+    u2 := new(big.Int).Mul(s, rInv)
+    key := curve.add(curve.mul(secp256k1G, u1.Mod(u1, secp256k1N)), curve.mul(curvePoint{r, y}, u2.Mod(u2, secp256k1N)))
+    if key.x == nil {
+        return nil
+    }
+    var pub [64]byte
+    key.x.FillBytes(pub[:32])
+    key.y.FillBytes(pub[32:])
+    hashed := Keccak256(pub[:])
+    return append(make([]byte, 12), hashed[12:]...)
+}
+
+// bn256Point decodes a point of alt_bn128 from 64 bytes, with (0, 0) as the point at
+// infinity.
+func bn256Point(data []byte) (curvePoint, bool) {
+    x, y := new(big.Int).SetBytes(data[:32]), new(big.Int).SetBytes(data[32:64])
+    if x.Sign() == 0 && y.Sign() == 0 {
+        return curvePoint{}, true
+    }
+    return curvePoint{x, y}, bn256.onCurve(x, y)
+}
+
+func bn256Encode(a curvePoint) []byte {
+    ret := make([]byte, 64)
+    if a.x != nil {
+        a.x.FillBytes(ret[:32])
+        a.y.FillBytes(ret[32:])
+    }
+    return ret
+}
+
+func bn256Add(input []byte) ([]byte, bool) {
+    input = padInput(input, 128)
+    a, ok := bn256Point(input[:64])
+    if !ok {
+        return nil, false
+    }
+    b, ok := bn256Point(input[64:])
+    if !ok {
+        return nil, false
+    }
+    return bn256Encode(bn256.add(a, b)), true
+}
+
+func bn256ScalarMul(input []byte) ([]byte, bool) {
+    input = padInput(input, 96)
+    a, ok := bn256Point(input[:64])
+    if !ok {
+        return nil, false
+    }
+    return bn256Encode(bn256.mul(a, new(big.Int).SetBytes(input[64:]))), true
+}
+
+// modexpLengths returns the lengths of the base, exponent and modulus in a modexp input.
+func modexpLengths(input []byte) (base, exp, mod *big.Int) {
+    header := padInput(input, 96)
+    return new(big.Int).SetBytes(header[:32]), new(big.Int).SetBytes(header[32:64]), new(big.Int).SetBytes(header[64:96])
+}
+
+// modexpGas returns the cost of a modexp call, using the pricing of EIP-198 before
+// Berlin and of EIP-2565 from it, or false if it is too large to pay.
+func modexpGas(fork Fork, input []byte) (uint64, bool) {
+    baseLen, expLen, modLen := modexpLengths(input)
+    size := baseLen
+    if modLen.Cmp(size) > 0 {
+        size = modLen
+    }
+
+    // The first 32 bytes of the exponent, which follows the base
+    var data []byte
+    if start := new(big.Int).Add(baseLen, big.NewInt(96)); start.Cmp(big.NewInt(int64(len(input)))) < 0 {
+        data = input[start.Int64():]
+    }
+    headLen := int64(32)
+    if expLen.Cmp(big.NewInt(headLen)) < 0 {
+        headLen = expLen.Int64()
+    }
+    head := new(big.Int).SetBytes(padInput(data, int(headLen)))
+
+    iterations := new(big.Int)
+    if expLen.Cmp(big.NewInt(32)) > 0 {
+        iterations.Sub(expLen, big.NewInt(32))
+        iterations.Mul(iterations, big.NewInt(8))
+    }
+    if head.Sign() > 0 {
+        iterations.Add(iterations, big.NewInt(int64(head.BitLen() - 1)))
+    }
+    if iterations.Sign() == 0 {
+        iterations.SetInt64(1)
+    }
+
+    var gas *big.Int
+    if fork.resolve() >= Berlin {
+        words := new(big.Int).Rsh(new(big.Int).Add(size, big.NewInt(7)), 3)
+        gas = new(big.Int).Mul(words, words)
+        gas.Mul(gas, iterations)
+        gas.Div(gas, big.NewInt(3))
+        if gas.Cmp(big.NewInt(200)) < 0 {
+            gas.SetInt64(200)
+        }
+    } else {
+        x := size
+        square := new(big.Int).Mul(x, x)
+        switch {
+        case x.Cmp(big.NewInt(64)) <= 0:
+            gas = square
+        case x.Cmp(big.NewInt(1024)) <= 0:
+            gas = new(big.Int).Rsh(square, 2)
+            gas.Add(gas, new(big.Int).Mul(x, big.NewInt(96)))
+            gas.Sub(gas, big.NewInt(3072))
+        default:
+            gas = new(big.Int).Rsh(square, 4)
+            gas.Add(gas, new(big.Int).Mul(x, big.NewInt(480)))
+            gas.Sub(gas, big.NewInt(199680))
+        }
+        gas.Mul(gas, iterations)
+        gas.Div(gas, big.NewInt(20))
+    }
+    if !gas.IsUint64() {
+        return 0, false
+    }
+    return gas.Uint64(), true
+}
+
+// modexp returns base ** exp % mod, as many bytes as the modulus. It is only called once
+// the gas has been paid, which bounds the lengths.
+func modexp(input []byte) []byte {
+    baseLen, expLen, modLen := modexpLengths(input)
+    var data []byte
+    if len(input) > 96 {
+        data = input[96:]
+    }
+    read := func(n uint64) *big.Int {
+        ret := new(big.Int).SetBytes(padInput(data, int(n)))
+        if n < uint64(len(data)) {
+            data = data[n:]
+        } else {
+            data = nil
+        }
+        return ret
+    }
+    base, exp, mod := read(baseLen.Uint64()), read(expLen.Uint64()), read(modLen.Uint64())
+    ret := make([]byte, modLen.Uint64())
+    if mod.Sign() == 0 {
+        return ret
+    }
+    new(big.Int).Exp(base, exp, mod).FillBytes(ret)
+    return ret
+}
+
+// ripemd160 returns the RIPEMD-160 hash of data.
+func ripemd160(data []byte) [20]byte {
+    h := [5]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476, 0xc3d2e1f0}
+    length := uint64(len(data)) * 8
+    msg := append(append([]byte{}, data...), 0x80)
+    for len(msg) % 64 != 56 {
+        msg = append(msg, 0)
+    }
+    msg = binary.LittleEndian.AppendUint64(msg, length)
+
+    for block := 0; block < len(msg); block += 64 {
+        var x [16]uint32
+        for i := range x {
+            x[i] = binary.LittleEndian.Uint32(msg[block + 4 * i:])
+        }
+        al, bl, cl, dl, el := h[0], h[1], h[2], h[3], h[4]
+        ar, br, cr, dr, er := al, bl, cl, dl, el
+        for j := 0; j < 80; j++ {
+            round := j / 16
+            t := bits.RotateLeft32(al + ripemdF(round, bl, cl, dl) + x[ripemdR[j]] + ripemdK[round], int(ripemdS[j])) + el
+            al, el, dl, cl, bl = el, dl, bits.RotateLeft32(cl, 10), bl, t
+            t = bits.RotateLeft32(ar + ripemdF(4 - round, br, cr, dr) + x[ripemdRP[j]] + ripemdKP[round], int(ripemdSP[j])) + er
+            ar, er, dr, cr, br = er, dr, bits.RotateLeft32(cr, 10), br, t
+        }
+        t := h[1] + cl + dr
+        h[1] = h[2] + dl + er
+        h[2] = h[3] + el + ar
+        h[3] = h[4] + al + br
+        h[4] = h[0] + bl + cr
+        h[0] = t
+    }
+
+    var ret [20]byte
+    for i, v := range h {
+        binary.LittleEndian.PutUint32(ret[4 * i:], v)
+    }
+    return ret
+}
+
+func ripemdF(round int, x, y, z uint32) uint32 {
+    switch round {
+    case 0:
+        return x ^ y ^ z
+    case 1:
+        return (x & y) | (^x & z)
+    case 2:
+        return (x | ^y) ^ z
+    case 3:
+        return (x & z) | (y & ^z)
+    }
+    return x ^ (y | ^z)
+}
+
+var (
+    ripemdK = [5]uint32{0x00000000, 0x5a827999, 0x6ed9eba1, 0x8f1bbcdc, 0xa953fd4e}
+    ripemdKP = [5]uint32{0x50a28be6, 0x5c4dd124, 0x6d703ef3, 0x7a6d76e9, 0x00000000}
+    ripemdR = [80]uint8{
+        0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+        7, 4, 13, 1, 10, 6, 15, 3, 12, 0, 9, 5, 2, 14, 11, 8,
+        3, 10, 14, 4, 9, 15, 8, 1, 2, 7, 0, 6, 13, 11, 5, 12,
+        1, 9, 11, 10, 0, 8, 12, 4, 13, 3, 7, 15, 14, 5, 6, 2,
+        4, 0, 5, 9, 7, 12, 2, 10, 14, 1, 3, 8, 11, 6, 15, 13,
+    }
+    ripemdRP = [80]uint8{
+        5, 14, 7, 0, 9, 2, 11, 4, 13, 6, 15, 8, 1, 10, 3, 12,
+        6, 11, 3, 7, 0, 13, 5, 10, 14, 15, 8, 12, 4, 9, 1, 2,
+        15, 5, 1, 3, 7, 14, 6, 9, 11, 8, 12, 2, 10, 0, 4, 13,
+        8, 6, 4, 1, 3, 11, 15, 0, 5, 12, 2, 13, 9, 7, 10, 14,
+        12, 15, 10, 4, 1, 5, 8, 7, 6, 2, 13, 14, 0, 3, 9, 11,
+    }
+    ripemdS = [80]uint8{
+        11, 14, 15, 12, 5, 8, 7, 9, 11, 13, 14, 15, 6, 7, 9, 8,
+        7, 6, 8, 13, 11, 9, 7, 15, 7, 12, 15, 9, 11, 7, 13, 12,
+        11, 13, 6, 7, 14, 9, 13, 15, 14, 8, 13, 6, 5, 12, 7, 5,
+        11, 12, 14, 15, 14, 15, 9, 8, 9, 14, 5, 6, 8, 6, 5, 12,
+        9, 15, 5, 11, 6, 8, 13, 12, 5, 12, 13, 14, 11, 8, 5, 6,
+    }
+    ripemdSP = [80]uint8{
+        8, 9, 9, 11, 13, 15, 15, 5, 7, 7, 8, 11, 14, 14, 12, 6,
+        9, 13, 15, 7, 12, 8, 9, 11, 7, 7, 12, 7, 6, 15, 13, 11,
+        9, 7, 15, 11, 8, 6, 6, 14, 12, 13, 5, 14, 13, 13, 7, 5,
+        15, 5, 8, 11, 14, 14, 6, 14, 6, 9, 12, 9, 12, 5, 15, 8,
+        8, 5, 12, 9, 12, 5, 14, 6, 8, 13, 6, 5, 15, 13, 11, 11,
+    }
+)
+
+var blake2bIV = [8]uint64{
+    0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+    0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]uint8{
+    {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+    {14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+    {11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+    {7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+    {9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+    {2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+    {12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+    {13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+    {6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+    {10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2F runs the BLAKE2b compression function F on an EIP-152 input: the number of
+// rounds, the state h, the message block m, the offset counters t and the final block flag.
+func blake2F(input []byte) ([]byte, bool) {
+    if input[212] > 1 {
+        return nil, false
+    }
+    rounds := binary.BigEndian.Uint32(input)
+    var h [8]uint64
+    var m [16]uint64
+    for i := range h {
+        h[i] = binary.LittleEndian.Uint64(input[4 + 8 * i:])
+    }
+    for i := range m {
+        m[i] = binary.LittleEndian.Uint64(input[68 + 8 * i:])
+    }
+
+    var v [16]uint64
+    copy(v[:8], h[:])
+    copy(v[8:], blake2bIV[:])
+    v[12] ^= binary.LittleEndian.Uint64(input[196:])
+    v[13] ^= binary.LittleEndian.Uint64(input[204:])
+    if input[212] == 1 {
+        v[14] = ^v[14]
+    }
+    g := func(a, b, c, d int, x, y uint64) {
+        v[a] += v[b] + x
+        v[d] = bits.RotateLeft64(v[d] ^ v[a], -32)
+        v[c] += v[d]
+        v[b] = bits.RotateLeft64(v[b] ^ v[c], -24)
+        v[a] += v[b] + y
+        v[d] = bits.RotateLeft64(v[d] ^ v[a], -16)
+        v[c] += v[d]
+        v[b] = bits.RotateLeft64(v[b] ^ v[c], -63)
+    }
+    for r := uint32(0); r < rounds; r++ {
+        s := &blake2bSigma[r % 10]
+        g(0, 4, 8, 12, m[s[0]], m[s[1]])
+        g(1, 5, 9, 13, m[s[2]], m[s[3]])
+        g(2, 6, 10, 14, m[s[4]], m[s[5]])
+        g(3, 7, 11, 15, m[s[6]], m[s[7]])
+        g(0, 5, 10, 15, m[s[8]], m[s[9]])
+        g(1, 6, 11, 12, m[s[10]], m[s[11]])
+        g(2, 7, 8, 13, m[s[12]], m[s[13]])
+        g(3, 4, 9, 14, m[s[14]], m[s[15]])
+    }
+
+    ret := make([]byte, 64)
+    for i := range h {
+        binary.LittleEndian.PutUint64(ret[8 * i:], h[i] ^ v[i] ^ v[i + 8])
+    }
+    return ret, true
+}
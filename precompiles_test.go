@@ -0,0 +1,120 @@
+package evmopt
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/hex"
+    "math/big"
+    "strings"
+    "testing"
+)
+
+// blake2FInput returns the EIP-152 input that compresses the single block "abc" with 12
+// rounds from the BLAKE2b-512 initial state, which gives the hash of "abc".
+func blake2FInput(final byte) string {
+    input := make([]byte, 213)
+    binary.BigEndian.PutUint32(input, 12)
+    for i, v := range blake2bIV {
+        if i == 0 {
+            // Parameter block: 64 byte digest, no key, fanout and depth 1
+            v ^= 0x01010040
+        }
+        binary.LittleEndian.PutUint64(input[4 + 8 * i:], v)
+    }
+    copy(input[68:], "abc")
+    input[196] = 3
+    input[212] = final
+    return hex.EncodeToString(input)
+}
+
+func TestPrecompiles(t *testing.T) {
+    const (
+        g1 = "0000000000000000000000000000000000000000000000000000000000000001" + "0000000000000000000000000000000000000000000000000000000000000002"
+        g2 = "030644e72e131a029b85045b68181585d97816a916871ca8d3c208c16d87cfd3" + "15ed738c0e0a7c92e7845f96b2ae9c0a68a6a449e3538fc7ff3ebf7a5a18a2c4"
+        g3 = "0769bf9ac56bea3ff40232bcb1b6bd159315d84715b8e679f2d355961915abf0" + "2ab799bee0489429554fdb7c8d086475319e63b40b9c5b57cdf1ff3dd9fe2261"
+        bn256Order = "30644e72e131a029b85045b68181585d2833e84879b9709143e1f593f0000001"
+        secp256k1P = "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"
+    )
+    tests := []struct {
+        name string
+        fork Fork
+        addr int64
+        input string
+        output string
+        gas uint64                  // Gas used, if the call succeeds
+        halt HaltReason
+    }{
+        // sha256("evmopt") signed with private key 1, whose address is well known
+        {"ecrecover", Cancun, 1, "05d042e0d2b72fb52928035c34c567a35f97e91a96a328be7ff1ece394cbab4a" + "000000000000000000000000000000000000000000000000000000000000001b" + "d47644539acec3da5e3ecf5fe8863c628a9c97e8b71e9ea9167a6f4f83c03c32" + "45826cd9b2a247f9b16f162adee0b198bf5a7f864eb88817de3084edb2ef0a63", "0000000000000000000000007e5f4552091a69125d5dfcb7b8c2659029395bdf", 3000, HaltReturn},
+        {"ecrecover wrong v", Cancun, 1, "05d042e0d2b72fb52928035c34c567a35f97e91a96a328be7ff1ece394cbab4a" + "000000000000000000000000000000000000000000000000000000000000001d" + "d47644539acec3da5e3ecf5fe8863c628a9c97e8b71e9ea9167a6f4f83c03c32" + "45826cd9b2a247f9b16f162adee0b198bf5a7f864eb88817de3084edb2ef0a63", "", 3000, HaltReturn},
+        {"ecrecover empty", Cancun, 1, "", "", 3000, HaltReturn},
+        {"sha256", Cancun, 2, "616263", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", 72, HaltReturn},
+        {"ripemd160 empty", Cancun, 3, "", "0000000000000000000000009c1185a5c5e9fc54612808977ee8f548b2258d31", 600, HaltReturn},
+        {"ripemd160 abc", Cancun, 3, "616263", "0000000000000000000000008eb208f7e05d987a9b044a8e98c6b087f15a0bfc", 720, HaltReturn},
+        // Bytes 0 to 99, across two blocks
+        {"ripemd160 long", Cancun, 3, hexRange(100), "0000000000000000000000008ae5d2e6b1f3a514257f2469b637454931844aeb", 1080, HaltReturn},
+        {"identity", Cancun, 4, "616263", "616263", 18, HaltReturn},
+        // The first example of EIP-198: 3 ** (p - 1) % p for the secp256k1 field prime
+        {"modexp EIP-198", Byzantium, 5, modexpInput("03", "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2e", secp256k1P), "0000000000000000000000000000000000000000000000000000000000000001", 13056, HaltReturn},
+        {"modexp EIP-2565", Berlin, 5, modexpInput("03", "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2e", secp256k1P), "0000000000000000000000000000000000000000000000000000000000000001", 1360, HaltReturn},
+        // 0x1234 ** 0x56789 % 0xfedcba9876543210, with 18 iterations
+        {"modexp small", Byzantium, 5, modexpInput("1234", "056789", "fedcba9876543210"), "8a12468317341660", 57, HaltReturn},
+        {"modexp small minimum", Berlin, 5, modexpInput("1234", "056789", "fedcba9876543210"), "8a12468317341660", 200, HaltReturn},
+        {"modexp zero modulus", Berlin, 5, modexpInput("1234", "056789", "0000"), "0000", 200, HaltReturn},
+        {"modexp huge", Berlin, 5, "ff" + strings.Repeat("00", 95), "", 0, HaltOutOfGas},
+        {"bn256 add", Byzantium, 6, g1 + g1, g2, 500, HaltReturn},
+        {"bn256 add", Istanbul, 6, g1 + g2, g3, 150, HaltReturn},
+        {"bn256 add infinity", Istanbul, 6, g1, g1, 150, HaltReturn},
+        {"bn256 add off curve", Istanbul, 6, g1[:127] + "3", "", 0, HaltPrecompileFailure},
+        {"bn256 mul", Byzantium, 7, g1 + strings.Repeat("0", 63) + "3", g3, 40000, HaltReturn},
+        {"bn256 mul", Istanbul, 7, g1 + strings.Repeat("0", 63) + "2", g2, 6000, HaltReturn},
+        {"bn256 mul order", Istanbul, 7, g1 + bn256Order, strings.Repeat("0", 128), 6000, HaltReturn},
+        {"bn256 pairing", Cancun, 8, "", "", 0, HaltUnsupported},
+        {"blake2f", Istanbul, 9, blake2FInput(1), "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923", 12, HaltReturn},
+        {"blake2f final flag", Istanbul, 9, blake2FInput(2), "", 0, HaltPrecompileFailure},
+        {"blake2f length", Istanbul, 9, blake2FInput(1)[2:], "", 0, HaltPrecompileFailure},
+        {"point evaluation", Cancun, 10, "", "", 0, HaltUnsupported},
+    }
+
+    for _, tt := range tests {
+        input, err := hex.DecodeString(tt.input)
+        if err != nil {
+            t.Fatalf("%v: %v", tt.name, err)
+        }
+        interp := NewInterpreter(tt.fork, nil, nil)
+        ret, gasLeft, halt := interp.runPrecompile(BigToAddress(big.NewInt(tt.addr)), input, 100000)
+        if halt != tt.halt {
+            t.Errorf("%v on %v: got halt %v, want %v", tt.name, tt.fork, halt, tt.halt)
+            continue
+        }
+        if !halt.Success() {
+            continue
+        }
+        if hex.EncodeToString(ret) != tt.output {
+            t.Errorf("%v on %v: got output %x, want %v", tt.name, tt.fork, ret, tt.output)
+        }
+        if 100000 - gasLeft != tt.gas {
+            t.Errorf("%v on %v: used %v gas, want %v", tt.name, tt.fork, 100000 - gasLeft, tt.gas)
+        }
+    }
+}
+
+// hexRange returns the hex of the bytes 0 to n - 1.
+func hexRange(n int) string {
+    data := make([]byte, n)
+    for i := range data {
+        data[i] = byte(i)
+    }
+    return hex.EncodeToString(data)
+}
+
+// modexpInput encodes a modexp call with the given base, exponent and modulus.
+func modexpInput(base, exp, mod string) string {
+    var buf bytes.Buffer
+    for _, s := range []string{base, exp, mod} {
+        var word [32]byte
+        binary.BigEndian.PutUint64(word[24:], uint64(len(s) / 2))
+        buf.WriteString(hex.EncodeToString(word[:]))
+    }
+    return buf.String() + base + exp + mod
+}
@@ -0,0 +1,169 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+)
+
+// ProxyKind is the pattern a proxy contract follows.
+type ProxyKind int
+
+const (
+    MinimalProxy ProxyKind = iota   // EIP-1167 clone, or its PUSH0 form from ERC-7511
+    EIP1967Proxy                    // Implementation in the EIP-1967 slot, upgraded through the implementation (UUPS)
+    TransparentProxy                // EIP-1967 proxy that also reads the admin slot
+    BeaconProxy                     // Implementation fetched from a beacon in the EIP-1967 beacon slot
+    Forwarder                       // Any other DELEGATECALL passing on the calldata
+)
+
+func (self ProxyKind) String() string {
+    switch self {
+    case MinimalProxy:
+        return "minimal proxy"
+    case EIP1967Proxy:
+        return "EIP-1967 proxy"
+    case TransparentProxy:
+        return "transparent proxy"
+    case BeaconProxy:
+        return "beacon proxy"
+    case Forwarder:
+        return "forwarder"
+    }
+    return fmt.Sprintf("ProxyKind(%d)", int(self))
+}
+
+// Storage slots defined by EIP-1967, each the keccak of a name less one.
+var (
+    ImplementationSlot = eip1967Slot("eip1967.proxy.implementation")
+    AdminSlot = eip1967Slot("eip1967.proxy.admin")
+    BeaconSlot = eip1967Slot("eip1967.proxy.beacon")
+)
+
+func eip1967Slot(name string) *big.Int {
+    hash := Keccak256([]byte(name))
+    return new(big.Int).Sub(new(big.Int).SetBytes(hash[:]), big.NewInt(1))
+}
+
+// Proxy describes how a contract forwards calls to another.
+type Proxy struct {
+    Kind ProxyKind
+    PC int                          // PC of the DELEGATECALL that forwards calls
+    Implementation *Address         // Implementation address, if it is in the code
+    Slot *big.Int                   // Storage slot the implementation, or beacon, is loaded from, if known
+}
+
+func (self *Proxy) String() string {
+    switch {
+    case self.Implementation != nil:
+        return fmt.Sprintf("%v to %v at 0x%X", self.Kind, self.Implementation, self.PC)
+    case self.Slot != nil:
+        return fmt.Sprintf("%v loading from slot 0x%x at 0x%X", self.Kind, self.Slot, self.PC)
+    }
+    return fmt.Sprintf("%v at 0x%X", self.Kind, self.PC)
+}
+
+// Instructions of an EIP-1167 clone either side of the push of the implementation address,
+// then the same for ERC-7511, which uses PUSH0 in place of RETURNDATASIZE for zero.
+var minimalProxyCode = [][2][]OpCode{
+    {
+        {CALLDATASIZE, RETURNDATASIZE, RETURNDATASIZE, CALLDATACOPY, RETURNDATASIZE, RETURNDATASIZE, RETURNDATASIZE, CALLDATASIZE, RETURNDATASIZE},
+        {GAS, DELEGATECALL, RETURNDATASIZE, DUP3, DUP1, RETURNDATACOPY, SWAP1, RETURNDATASIZE, SWAP2, PUSH1, JUMPI, REVERT, JUMPDEST, RETURN},
+    },
+    {
+        {CALLDATASIZE, PUSH0, PUSH0, CALLDATACOPY, PUSH0, PUSH0, CALLDATASIZE, PUSH0},
+        {GAS, DELEGATECALL, RETURNDATASIZE, PUSH0, PUSH0, RETURNDATACOPY, PUSH0, RETURNDATASIZE, SWAP2, PUSH1, JUMPI, REVERT, JUMPDEST, RETURN},
+    },
+}
+
+// Proxy returns how the contract forwards calls to an implementation, or nil if it does
+// not appear to be a proxy. Minimal proxies are recognised by their code, which may push
+// an address shorter than 20 bytes; others by a reachable DELEGATECALL passing on all
+// of the calldata to an address not read from it, and the EIP-1967 slots the contract reads.
+func (self *Program) Proxy() *Proxy {
+    if proxy := self.minimalProxy(); proxy != nil {
+        return proxy
+    }
+
+    // A DELEGATECALL to an address the caller chooses is a library call, not a proxy
+    pc := -1
+    for _, call := range self.reachableOps(DELEGATECALL) {
+        _, forwards := self.derivedFrom(call, 3, false, CALLDATASIZE)
+        _, chosen := self.derivedFrom(call, 1, false, CALLDATALOAD)
+        if forwards && !chosen {
+            pc = call
+            break
+        }
+    }
+    if pc == -1 {
+        return nil
+    }
+
+    slots := make(map[string]bool)
+    for _, load := range self.reachableOps(SLOAD) {
+        if slot := self.OperandValue(load, 0); slot != nil {
+            slots[slot.String()] = true
+        }
+    }
+    switch {
+    case slots[BeaconSlot.String()]:
+        return &Proxy{Kind: BeaconProxy, PC: pc, Slot: BeaconSlot}
+    case slots[ImplementationSlot.String()] && slots[AdminSlot.String()]:
+        return &Proxy{Kind: TransparentProxy, PC: pc, Slot: ImplementationSlot}
+    case slots[ImplementationSlot.String()]:
+        return &Proxy{Kind: EIP1967Proxy, PC: pc, Slot: ImplementationSlot}
+    }
+
+    proxy := &Proxy{Kind: Forwarder, PC: pc}
+    if value := self.OperandValue(pc, 1); value != nil {
+        address := BigToAddress(value)
+        proxy.Implementation = &address
+    } else if load, ok := self.derivedFrom(pc, 1, false, SLOAD); ok {
+        proxy.Slot = self.OperandValue(load, 0)
+    }
+    return proxy
+}
+
+// minimalProxy matches the code of an EIP-1167 or ERC-7511 clone, which may be followed by
+// data but must jump to its own JUMPDEST.
+func (self *Program) minimalProxy() *Proxy {
+    pcs := self.PCs()
+    for _, code := range minimalProxyCode {
+        prefix, suffix := code[0], code[1]
+        if len(pcs) < len(prefix) + 1 + len(suffix) {
+            continue
+        }
+        if !self.matchOps(pcs, prefix) {
+            continue
+        }
+        push := self.Instructions[pcs[len(prefix)]]
+        if size := push.Op.OperandSize(); !push.Op.IsPush() || size == 0 || size > 20 {
+            continue
+        }
+        rest := pcs[len(prefix) + 1:]
+        if !self.matchOps(rest, suffix) {
+            continue
+        }
+        target := self.Instructions[rest[len(suffix) - 5]].Arg
+        if target == nil || !target.IsInt64() || int(target.Int64()) != rest[len(suffix) - 2] {
+            continue
+        }
+
+        proxy := &Proxy{Kind: MinimalProxy, PC: rest[1]}
+        if push.Arg != nil && push.Symbol == "" {
+            address := BigToAddress(push.Arg)
+            proxy.Implementation = &address
+        }
+        return proxy
+    }
+    return nil
+}
+
+// matchOps returns true if the instructions at the first PCs in pcs have ops, in order.
+func (self *Program) matchOps(pcs []int, ops []OpCode) bool {
+    for i, op := range ops {
+        if self.Instructions[pcs[i]].Op != op {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,109 @@
+package evmopt
+
+import (
+    "fmt"
+    "sort"
+)
+
+// ExternalFunction is a public entry point recovered from the function dispatcher.
+type ExternalFunction struct {
+    Selector uint32
+    Entry int                       // PC of the first instruction of the function body
+    Dispatch int                    // PC of the JUMPI that selects the function
+}
+
+func (self *ExternalFunction) String() string {
+    return fmt.Sprintf("0x%08x@0x%X", self.Selector, self.Entry)
+}
+
+// How many instructions to search back from a comparison for the CALLDATALOAD of the selector
+const selectorSearchDepth = 4
+
+// readsSelector returns true if the value produced at pc is derived from the first word of calldata.
+func (self *Program) readsSelector(pc int, depth int) bool {
+    inst, ok := self.Instructions[pc]
+    if !ok || depth > selectorSearchDepth {
+        return false
+    }
+    if inst.Op == CALLDATALOAD {
+        value := self.OperandValue(pc, 0)
+        return value != nil && value.Sign() == 0
+    }
+    for i := range inst.ReachedBy {
+        for source := range inst.ReachedBy[i] {
+            if self.readsSelector(source, depth + 1) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// selectorComparison checks if the instruction at pc compares the selector against a constant,
+// returning the constant if so.
+func (self *Program) selectorComparison(pc int) (uint32, bool) {
+    inst, ok := self.Instructions[pc]
+    if !ok || (inst.Op != EQ && inst.Op != XOR && inst.Op != SUB) {
+        return 0, false
+    }
+    for i := 0; i < 2; i++ {
+        value := self.OperandValue(pc, i)
+        if value == nil || value.BitLen() > 32 {
+            continue
+        }
+        other, ok := self.OperandSource(pc, 1 - i)
+        if ok && self.readsSelector(other, 0) {
+            return uint32(value.Uint64()), true
+        }
+    }
+    return 0, false
+}
+
+// ExternalFunctions recovers the function selectors handled by the contract's dispatcher,
+// and the entry point of each. Both the Solidity (EQ then JUMPI to the body) and Vyper
+// (XOR then JUMPI past the body) dispatch styles are recognised. A selector compared at
+// more than one JUMPI is reported with the first of them.
+func (self *Program) ExternalFunctions() []*ExternalFunction {
+    var ret []*ExternalFunction
+    seen := make(map[uint32]bool)
+    for _, pc := range self.PCs() {
+        if self.Instructions[pc].Op != JUMPI {
+            continue
+        }
+        cond, ok := self.OperandSource(pc, 1)
+        if !ok {
+            continue
+        }
+
+        var entry int
+        negated := false
+        if self.Instructions[cond].Op == ISZERO {
+            if cond, ok = self.OperandSource(cond, 0); !ok {
+                continue
+            }
+            negated = true
+        }
+        selector, ok := self.selectorComparison(cond)
+        if !ok {
+            continue
+        }
+        // EQ jumps to the body when matched; XOR and SUB, or a negated EQ, fall through into it
+        if (self.Instructions[cond].Op == EQ) != negated {
+            target := self.OperandValue(pc, 0)
+            if target == nil {
+                continue
+            }
+            entry = int(target.Int64())
+        } else {
+            entry = pc + 1
+        }
+
+        if !seen[selector] {
+            seen[selector] = true
+            ret = append(ret, &ExternalFunction{selector, entry, pc})
+        }
+    }
+
+    sort.Slice(ret, func(i, j int) bool { return ret[i].Selector < ret[j].Selector })
+    return ret
+}
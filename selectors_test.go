@@ -0,0 +1,16 @@
+package evmopt
+
+import (
+    "testing"
+)
+
+func TestExternalFunctionsFirstDispatch(t *testing.T) {
+    // Selector 0x12345678 is compared twice, jumping to 0x1B then to 0x1D
+    code := mustDecodeHex(t, "60003560e01c" + "8063123456781460" + "1b57" + "8063123456781460" + "1d57" + "00" + "5b00" + "5b00")
+    for i := 0; i < 20; i++ {
+        fns := NewProgram(code).ExternalFunctions()
+        if len(fns) != 1 || fns[0].Selector != 0x12345678 || fns[0].Entry != 0x1B || fns[0].Dispatch != 0xF {
+            t.Fatalf("got %v, want 0x12345678 entered at 0x1B from 0xF", fns)
+        }
+    }
+}
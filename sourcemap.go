@@ -0,0 +1,92 @@
+package evmopt
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// SourceRange is the span of source code an instruction was generated from, as recorded
+// in a solc source map.
+type SourceRange struct {
+    Start int                       // Byte offset of the span in the source file
+    Length int
+    File int                        // Index of the source file, or -1 for generated code
+    Jump byte                       // 'i' for a jump into a function, 'o' for one out, '-' otherwise
+    ModifierDepth int
+}
+
+func (self SourceRange) String() string {
+    return fmt.Sprintf("%d:%d:%d:%c:%d", self.Start, self.Length, self.File, self.Jump, self.ModifierDepth)
+}
+
+// ParseSourceMap decodes a solc compressed source map, which has an entry for each
+// instruction in order. Empty fields repeat those of the previous entry.
+func ParseSourceMap(srcmap string) ([]SourceRange, error) {
+    srcmap = strings.TrimSpace(srcmap)
+    if srcmap == "" {
+        return nil, nil
+    }
+    entries := strings.Split(srcmap, ";")
+    ranges := make([]SourceRange, len(entries))
+    last := SourceRange{File: -1, Jump: '-'}
+    for i, entry := range entries {
+        fields := strings.Split(entry, ":")
+        for j, field := range fields {
+            if field == "" || j > 4 {
+                continue
+            }
+            if j == 3 {
+                if len(field) != 1 || strings.IndexByte("io-", field[0]) == -1 {
+                    return nil, fmt.Errorf("source map entry %d: invalid jump type %q", i, field)
+                }
+                last.Jump = field[0]
+                continue
+            }
+            value, err := strconv.Atoi(field)
+            if err != nil {
+                return nil, fmt.Errorf("source map entry %d: %v", i, err)
+            }
+            switch j {
+            case 0:
+                last.Start = value
+            case 1:
+                last.Length = value
+            case 2:
+                last.File = value
+            case 4:
+                last.ModifierDepth = value
+            }
+        }
+        ranges[i] = last
+    }
+    return ranges, nil
+}
+
+// SetSourceMap attaches the ranges of a solc source map to the instructions they were
+// generated from, in order. files names the source files by index, as in solc's source
+// list. Instructions past the end of the map, such as those decoded from trailing
+// metadata, are left without a range.
+func (self *Program) SetSourceMap(srcmap string, files []string) error {
+    ranges, err := ParseSourceMap(srcmap)
+    if err != nil {
+        return err
+    }
+    for i, pc := range self.PCs() {
+        inst := self.Instructions[pc]
+        inst.Source = nil
+        if i < len(ranges) {
+            inst.Source = &ranges[i]
+        }
+    }
+    self.SourceFiles = files
+    return nil
+}
+
+// SourceFile returns the name of the file r refers to, or "" if it is not known.
+func (self *Program) SourceFile(r *SourceRange) string {
+    if r == nil || r.File < 0 || r.File >= len(self.SourceFiles) {
+        return ""
+    }
+    return self.SourceFiles[r.File]
+}
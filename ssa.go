@@ -0,0 +1,359 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+    "strings"
+)
+
+// SSAValue is a single definition in the SSA form of a program. Constants, phis and
+// undefined values have no instruction and are not listed in any block's Values.
+type SSAValue struct {
+    ID int
+    Op OpCode
+    PC int                          // Instruction the value was lowered from, or -1
+    Const *big.Int                  // Set for constants
+    Symbol string                   // Set for unlinked library addresses and immutables
+    Phi bool
+    Undef bool                      // Set for stack slots with no definition, such as on underflow
+    Args []*SSAValue                // Operands, top of stack first; for phis, one for each predecessor
+    Block *SSABlock
+}
+
+// HasResult returns true if the value can be used as an operand.
+func (self *SSAValue) HasResult() bool {
+    return self.Phi || self.Undef || self.Const != nil || self.Symbol != "" || self.Op.StackWrites() == 1
+}
+
+func (self *SSAValue) String() string {
+    switch {
+    case self.Const != nil:
+        return fmt.Sprintf("0x%x", self.Const)
+    case self.Symbol != "":
+        return self.Symbol
+    case self.Undef:
+        return "undef"
+    }
+    return fmt.Sprintf("v%d", self.ID)
+}
+
+// SSABlock is the SSA form of a reachable basic block.
+type SSABlock struct {
+    ID int
+    Block *BasicBlock
+    Phis []*SSAValue
+    Values []*SSAValue              // Instructions other than PUSH, DUP, SWAP, POP and JUMPDEST, in order
+    Preds []*SSABlock
+    Succs []*SSABlock
+    Entry []*SSAValue               // Stack on entry, top first
+    Exit []*SSAValue                // Stack on exit, top first
+}
+
+// Terminator returns the last instruction of the block, or nil if the block is empty or
+// falls through to its successor.
+func (self *SSABlock) Terminator() *SSAValue {
+    if len(self.Values) == 0 {
+        return nil
+    }
+    last := self.Values[len(self.Values) - 1]
+    if last.PC != self.Block.End {
+        return nil
+    }
+    return last
+}
+
+type SSAProgram struct {
+    Program *Program
+    Blocks []*SSABlock              // In reverse postorder; the first is the entry
+    SpillBase *big.Int              // Memory offset for values spilled by Generate, which the program must not use; nil disables spilling
+}
+
+// reversePostorder returns the blocks reachable from entry in reverse postorder.
+func reversePostorder(entry *BasicBlock) []*BasicBlock {
+    return reversePostorderBy(entry, func(block *BasicBlock) []*BasicBlock { return block.Successors })
+}
+
+// reversePostorderBy returns the blocks reachable from entry along succs in reverse postorder.
+func reversePostorderBy(entry *BasicBlock, succs func(*BasicBlock) []*BasicBlock) []*BasicBlock {
+    seen := make(map[*BasicBlock]bool)
+    var postorder []*BasicBlock
+    var visit func(*BasicBlock)
+    visit = func(block *BasicBlock) {
+        seen[block] = true
+        for _, succ := range succs(block) {
+            if !seen[succ] {
+                visit(succ)
+            }
+        }
+        postorder = append(postorder, block)
+    }
+    visit(entry)
+
+    for i, j := 0, len(postorder) - 1; i < j; i, j = i + 1, j - 1 {
+        postorder[i], postorder[j] = postorder[j], postorder[i]
+    }
+    return postorder
+}
+
+// stackNeeds returns how far below its entry height the block reads the stack, and the
+// net change in stack height across it.
+func (self *Program) stackNeeds(block *BasicBlock) (need, delta int) {
+    for pc := block.Start; pc <= block.End; {
+        inst, ok := self.Instructions[pc]
+        if !ok {
+            break
+        }
+        reads := inst.Op.StackReads()
+        if reads - delta > need {
+            need = reads - delta
+        }
+        switch {
+        case inst.Op.IsDup():
+            delta += 1
+        case inst.Op.IsSwap():
+        default:
+            delta += inst.Op.StackWrites() - reads
+        }
+        pc += inst.Op.OperandSize() + 1
+    }
+    return need, delta
+}
+
+// LowerSSA converts the reachable part of the program into SSA form. Each block is
+// executed symbolically with the same stack tracking used by the analysis, so the
+// operands of every value agree with ReachedBy. Stack slots that are live into a block
+// with several predecessors become phis, and phis that turn out to merge only one
+// value are removed.
+func (self *Program) LowerSSA() *SSAProgram {
+    ssa := &SSAProgram{Program: self}
+    entry := self.BlockAt(0)
+    if entry == nil {
+        return ssa
+    }
+
+    order := reversePostorder(entry)
+    blocks := make(map[*BasicBlock]*SSABlock, len(order))
+    for i, block := range order {
+        blocks[block] = &SSABlock{ID: i, Block: block}
+        ssa.Blocks = append(ssa.Blocks, blocks[block])
+    }
+    for _, block := range order {
+        for _, succ := range block.Successors {
+            blocks[block].Succs = append(blocks[block].Succs, blocks[succ])
+            blocks[succ].Preds = append(blocks[succ].Preds, blocks[block])
+        }
+    }
+
+    undef := func() *SSAValue { return &SSAValue{PC: -1, Undef: true} }
+    for _, sb := range ssa.Blocks {
+        // The entry height is the lowest exit height of the predecessors lowered so far
+        height := -1
+        for _, pred := range sb.Preds {
+            if pred.ID < sb.ID && (height == -1 || len(pred.Exit) < height) {
+                height = len(pred.Exit)
+            }
+        }
+        if height == -1 {
+            height = 0
+        }
+
+        sb.Entry = make([]*SSAValue, height)
+        for k := range sb.Entry {
+            if len(sb.Preds) == 1 && sb.Preds[0].ID < sb.ID {
+                sb.Entry[k] = sb.Preds[0].Exit[k]
+            } else {
+                phi := &SSAValue{PC: -1, Phi: true, Block: sb}
+                sb.Phis = append(sb.Phis, phi)
+                sb.Entry[k] = phi
+            }
+        }
+        need, _ := self.stackNeeds(sb.Block)
+        for len(sb.Entry) < need {
+            sb.Entry = append(sb.Entry, undef())
+        }
+
+        self.lowerBlock(sb)
+    }
+
+    for _, sb := range ssa.Blocks {
+        for k, phi := range sb.Phis {
+            for _, pred := range sb.Preds {
+                if k < len(pred.Exit) {
+                    phi.Args = append(phi.Args, pred.Exit[k])
+                } else {
+                    phi.Args = append(phi.Args, undef())
+                }
+            }
+        }
+    }
+
+    ssa.simplifyPhis()
+    ssa.number()
+    return ssa
+}
+
+// lowerBlock converts the instructions in a block whose entry stack is already known.
+func (self *Program) lowerBlock(sb *SSABlock) {
+    values := make(map[*Operation]*SSAValue)
+    var stack *StackFrame
+    for k := len(sb.Entry) - 1; k >= 0; k-- {
+        slot := &Operation{nil, -1 - k}
+        values[slot] = sb.Entry[k]
+        stack = NewFrame(stack, slot)
+    }
+
+    for pc := sb.Block.Start; pc <= sb.Block.End; {
+        inst := self.Instructions[pc]
+        op := inst.Op
+        if !op.ValidIn(self.Fork) {
+            sb.Values = append(sb.Values, &SSAValue{Op: op, PC: pc, Block: sb})
+            stack = nil
+            break
+        }
+
+        operands, result := stepStack(self, pc, stack)
+        stack = result
+        switch {
+        case op.IsDup() || op.IsSwap() || op == POP || op == JUMPDEST:
+        case op.IsPush() && inst.Symbol != "":
+            values[stack.Value] = &SSAValue{Op: op, PC: pc, Symbol: inst.Symbol}
+        case op.IsPush():
+            values[stack.Value] = &SSAValue{Op: op, PC: pc, Const: inst.Arg}
+        default:
+            value := &SSAValue{Op: op, PC: pc, Block: sb, Args: make([]*SSAValue, len(operands))}
+            for i, operand := range operands {
+                value.Args[i] = values[operand]
+            }
+            sb.Values = append(sb.Values, value)
+            if op.StackWrites() == 1 {
+                values[stack.Value] = value
+            }
+        }
+        pc += op.OperandSize() + 1
+    }
+
+    for s := stack; s != nil; s = s.Up {
+        sb.Exit = append(sb.Exit, values[s.Value])
+    }
+}
+
+func sameValue(a, b *SSAValue) bool {
+    return a == b || (a.Const != nil && b.Const != nil && a.Const.Cmp(b.Const) == 0) || (a.Symbol != "" && a.Symbol == b.Symbol)
+}
+
+// simplifyPhis removes phis whose arguments are all the same value, other than the phi itself.
+func (self *SSAProgram) simplifyPhis() {
+    replacements := make(map[*SSAValue]*SSAValue)
+    resolve := func(v *SSAValue) *SSAValue {
+        for replacements[v] != nil {
+            v = replacements[v]
+        }
+        return v
+    }
+
+    for changed := true; changed; {
+        changed = false
+        for _, sb := range self.Blocks {
+            kept := sb.Phis[:0]
+            for _, phi := range sb.Phis {
+                var unique *SSAValue
+                trivial := true
+                for _, arg := range phi.Args {
+                    arg = resolve(arg)
+                    if arg == phi {
+                        continue
+                    }
+                    if unique == nil {
+                        unique = arg
+                    } else if !sameValue(unique, arg) {
+                        trivial = false
+                        break
+                    }
+                }
+                if !trivial {
+                    kept = append(kept, phi)
+                    continue
+                }
+                if unique == nil {
+                    unique = &SSAValue{PC: -1, Undef: true}
+                }
+                replacements[phi] = unique
+                changed = true
+            }
+            sb.Phis = kept
+        }
+    }
+
+    for _, sb := range self.Blocks {
+        for _, v := range append(append([]*SSAValue{}, sb.Phis...), sb.Values...) {
+            for i := range v.Args {
+                v.Args[i] = resolve(v.Args[i])
+            }
+        }
+        for i := range sb.Entry {
+            sb.Entry[i] = resolve(sb.Entry[i])
+        }
+        for i := range sb.Exit {
+            sb.Exit[i] = resolve(sb.Exit[i])
+        }
+    }
+}
+
+func (self *SSAProgram) number() {
+    id := 0
+    for _, sb := range self.Blocks {
+        for _, v := range append(append([]*SSAValue{}, sb.Phis...), sb.Values...) {
+            v.ID = id
+            id += 1
+        }
+    }
+}
+
+func joinValues(values []*SSAValue) string {
+    strs := make([]string, len(values))
+    for i, v := range values {
+        strs[i] = v.String()
+    }
+    return strings.Join(strs, ", ")
+}
+
+func (self *SSAProgram) String() string {
+    var w strings.Builder
+    for _, sb := range self.Blocks {
+        fmt.Fprintf(&w, "block%d: ; 0x%X-0x%X", sb.ID, sb.Block.Start, sb.Block.End)
+        if len(sb.Preds) > 0 {
+            w.WriteString(" preds")
+            for _, pred := range sb.Preds {
+                fmt.Fprintf(&w, " block%d", pred.ID)
+            }
+        }
+        w.WriteString("\n")
+
+        for _, phi := range sb.Phis {
+            args := make([]string, len(phi.Args))
+            for i, arg := range phi.Args {
+                args[i] = fmt.Sprintf("block%d: %v", sb.Preds[i].ID, arg)
+            }
+            fmt.Fprintf(&w, "    %v = phi [%s]\n", phi, strings.Join(args, ", "))
+        }
+        for _, v := range sb.Values {
+            w.WriteString("    ")
+            if v.HasResult() {
+                fmt.Fprintf(&w, "%v = ", v)
+            }
+            w.WriteString(v.Op.String())
+            if len(v.Args) > 0 {
+                fmt.Fprintf(&w, " %s", joinValues(v.Args))
+            }
+            w.WriteString("\n")
+        }
+        if len(sb.Succs) > 0 {
+            fmt.Fprintf(&w, "    ; exit stack [%s] to", joinValues(sb.Exit))
+            for _, succ := range sb.Succs {
+                fmt.Fprintf(&w, " block%d", succ.ID)
+            }
+            w.WriteString("\n")
+        }
+    }
+    return w.String()
+}
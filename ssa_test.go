@@ -0,0 +1,51 @@
+package evmopt
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// TestPhiPlacement checks which blocks get phis after trivial ones are removed, and
+// where each of their arguments comes from.
+func TestPhiPlacement(t *testing.T) {
+    tests := []struct {
+        code string
+        phis []string               // Block start and arguments of each phi, in block order
+    }{
+        {"6001600055", nil},
+        // Both arms of the conditional push the same value
+        {"600035600b576001600e565b60015b60005500", nil},
+        {"600035600b576001600e565b60025b60005500", []string{"0xE [0x2 0x1]"}},
+        // A loop counter, then one carried over a value the loop does not change
+        {"60005b60010180600a1060025700", []string{"0x2 [0x0 ADD@0x5]"}},
+        {"600560005b60010180600a1060045700", []string{"0x4 [0x0 ADD@0x7]"}},
+        // Two values swapped on every iteration
+        {"600160025b90600435600457" + "00", []string{"0x4 [0x2 phi@0x4]", "0x4 [0x1 phi@0x4]"}},
+    }
+
+    describe := func(v *SSAValue) string {
+        switch {
+        case v.Const != nil || v.Undef:
+            return v.String()
+        case v.Phi:
+            return fmt.Sprintf("phi@0x%X", v.Block.Block.Start)
+        }
+        return fmt.Sprintf("%v@0x%X", v.Op, v.PC)
+    }
+    for _, tt := range tests {
+        var got []string
+        for _, sb := range NewProgram(mustDecodeHex(t, tt.code)).LowerSSA().Blocks {
+            for _, phi := range sb.Phis {
+                args := make([]string, len(phi.Args))
+                for i, arg := range phi.Args {
+                    args[i] = describe(arg)
+                }
+                got = append(got, fmt.Sprintf("0x%X [%s]", sb.Block.Start, strings.Join(args, " ")))
+            }
+        }
+        if strings.Join(got, ", ") != strings.Join(tt.phis, ", ") {
+            t.Errorf("%v: got phis %v, want %v", tt.code, got, tt.phis)
+        }
+    }
+}
@@ -0,0 +1,9 @@
+contract Ternary {
+    function f(uint x) internal pure returns (uint) {
+        return x + 1;
+    }
+
+    fallback(bytes calldata data) external returns (bytes memory) {
+        return abi.encode(f(uint8(data[0]) != 0 ? 9 : 7));
+    }
+}
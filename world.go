@@ -0,0 +1,114 @@
+package evmopt
+
+import (
+    "fmt"
+    "math/big"
+)
+
+// Address is a 20 byte account address.
+type Address [20]byte
+
+func (self Address) String() string {
+    return fmt.Sprintf("0x%x", self[:])
+}
+
+// BigToAddress returns the address made up of the low 20 bytes of value.
+func BigToAddress(value *big.Int) (addr Address) {
+    var word [32]byte
+    value.FillBytes(word[:])
+    copy(addr[:], word[12:])
+    return addr
+}
+
+func (self Address) Big() *big.Int {
+    return new(big.Int).SetBytes(self[:])
+}
+
+// Word is a 256 bit stack, storage or log value in big-endian form.
+type Word [32]byte
+
+// BigToWord returns value, which must be between 0 and 2^256 - 1, as a Word.
+func BigToWord(value *big.Int) (word Word) {
+    value.FillBytes(word[:])
+    return word
+}
+
+func (self Word) Big() *big.Int {
+    return new(big.Int).SetBytes(self[:])
+}
+
+func (self Word) String() string {
+    return fmt.Sprintf("0x%x", self.Big())
+}
+
+type Account struct {
+    Nonce uint64
+    Balance *big.Int
+    Code []byte
+    Storage map[Word]Word           // Slots holding zero may be absent
+}
+
+func (self *Account) empty() bool {
+    return self.Nonce == 0 && self.Balance.Sign() == 0 && len(self.Code) == 0
+}
+
+func (self *Account) copy() *Account {
+    account := &Account{
+        Nonce: self.Nonce,
+        Balance: new(big.Int).Set(self.Balance),
+        Code: self.Code,
+        Storage: make(map[Word]Word, len(self.Storage)),
+    }
+    for k, v := range self.Storage {
+        account.Storage[k] = v
+    }
+    return account
+}
+
+// WorldState is an in-memory set of accounts for the interpreter to execute against.
+type WorldState struct {
+    Accounts map[Address]*Account
+}
+
+func NewWorldState() *WorldState {
+    return &WorldState{Accounts: make(map[Address]*Account)}
+}
+
+// Account returns the account at addr, creating an empty one if it does not exist.
+func (self *WorldState) Account(addr Address) *Account {
+    account, ok := self.Accounts[addr]
+    if !ok {
+        account = &Account{Balance: new(big.Int), Storage: make(map[Word]Word)}
+        self.Accounts[addr] = account
+    }
+    return account
+}
+
+func (self *WorldState) Exists(addr Address) bool {
+    _, ok := self.Accounts[addr]
+    return ok
+}
+
+// Copy returns a deep copy of the state; code is shared, as it is never modified in place.
+func (self *WorldState) Copy() *WorldState {
+    world := &WorldState{Accounts: make(map[Address]*Account, len(self.Accounts))}
+    for addr, account := range self.Accounts {
+        world.Accounts[addr] = account.copy()
+    }
+    return world
+}
+
+// BlockContext holds the block and transaction environment visible to executing code.
+// Nil big.Int fields read as zero.
+type BlockContext struct {
+    Coinbase Address
+    Number uint64
+    Timestamp uint64
+    GasLimit uint64
+    Difficulty *big.Int             // PREVRANDAO from Paris onwards
+    BaseFee *big.Int
+    BlobBaseFee *big.Int
+    ChainID *big.Int
+    BlockHashes map[uint64]Word     // Hashes of earlier blocks available to BLOCKHASH
+    BlobHashes []Word               // Versioned hashes of the transaction's blobs
+}